@@ -0,0 +1,352 @@
+package cluster
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// cronSearchYears bounds the search for the next time matching a cron expression, covering
+	// expressions such as "0 0 29 2 MON" that rarely match.
+	cronSearchYears = 30
+
+	// cronCatchUpGrace is how late a run may start and still be considered on time.
+	cronCatchUpGrace = time.Minute
+)
+
+// CronCatchUpPolicy determines how a cron job handles runs missed while no plugin instance was
+// able to run it, such as while the plugin was disabled.
+type CronCatchUpPolicy int
+
+const (
+	// CronCatchUpRunOnce runs the job once as soon as possible when one or more runs were missed.
+	CronCatchUpRunOnce CronCatchUpPolicy = iota
+
+	// CronCatchUpSkip skips missed runs, waiting for the next scheduled time.
+	CronCatchUpSkip
+)
+
+// cronField is the set of values matched by a cron expression field, as a bitmask.
+type cronField uint64
+
+func (f cronField) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+// cronFieldBounds describes the allowed values of a cron expression field.
+type cronFieldBounds struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSeconds = cronFieldBounds{name: "second", min: 0, max: 59}
+	cronMinutes = cronFieldBounds{name: "minute", min: 0, max: 59}
+	cronHours   = cronFieldBounds{name: "hour", min: 0, max: 23}
+	cronDays    = cronFieldBounds{name: "day of month", min: 1, max: 31}
+	cronMonths  = cronFieldBounds{name: "month", min: 1, max: 12, names: map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}}
+	// Both 0 and 7 are Sunday.
+	cronWeekdays = cronFieldBounds{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+	}}
+)
+
+// cronDescriptors are the predefined schedules usable in place of a cron expression.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronSchedule is a parsed cron expression, matched against the wall clock of a time zone.
+type cronSchedule struct {
+	second, minute, hour, day, month, weekday cronField
+
+	// dayStar and weekdayStar are set when the corresponding field is unrestricted. As with
+	// the standard cron, a day matches either restricted field when both are restricted.
+	dayStar, weekdayStar bool
+
+	location *time.Location
+}
+
+// MakeWaitForCron creates a function scheduling a job to run at the times matching the given cron
+// expression, in the given time zone.
+//
+// The expression has five fields for the minute, hour, day of month, month and day of week, or
+// six fields with a leading field for the second. Each field is either a wildcard (*, or ? for
+// days), a value, a range (a-b), or a comma separated list of those, with an optional step
+// (*/15, 1-30/2). Months and days of the week may also be named (JAN-DEC, SUN-SAT). The
+// descriptors @yearly, @monthly, @weekly, @daily and @hourly are also accepted.
+//
+// For example, to run a job at 9:00 AM on weekdays in New York:
+//
+//	location, _ := time.LoadLocation("America/New_York")
+//	MakeWaitForCron("0 9 * * MON-FRI", location, CronCatchUpRunOnce)
+//
+// Times are matched against the wall clock of the given location, or UTC if nil. When a daylight
+// saving time transition skips a matching time, the job runs when it would have had the clock
+// not moved forward, an hour later in most time zones. When a transition repeats a matching time,
+// the job only runs the first time.
+//
+// A job is not run again while it is still running, and runs missed since the job last finished
+// are handled according to the given catch-up policy. A job that has not previously run waits
+// for the next matching time.
+func MakeWaitForCron(expr string, location *time.Location, catchUp CronCatchUpPolicy) (NextWaitInterval, error) {
+	schedule, err := parseCron(expr, location)
+	if err != nil {
+		return nil, err
+	}
+
+	if schedule.next(time.Now()).IsZero() {
+		return nil, errors.Errorf("cron expression %q never matches", expr)
+	}
+
+	return func(now time.Time, metadata JobMetadata) time.Duration {
+		// Run if a matching time was reached recently, and the job has not run since.
+		from := now.Add(-cronCatchUpGrace)
+		if metadata.LastFinished.After(from) {
+			from = metadata.LastFinished
+		}
+		if due := schedule.next(from); !due.IsZero() && !due.After(now) {
+			return 0
+		}
+
+		// Otherwise, catch up on runs missed earlier if requested.
+		if catchUp == CronCatchUpRunOnce && !metadata.LastFinished.IsZero() {
+			if due := schedule.next(metadata.LastFinished); !due.IsZero() && !due.After(now) {
+				return 0
+			}
+		}
+
+		next := schedule.next(now)
+		if next.IsZero() {
+			return maxWaitInterval
+		}
+
+		return next.Sub(now)
+	}, nil
+}
+
+// parseCron parses a cron expression as documented by MakeWaitForCron.
+func parseCron(expr string, location *time.Location) (*cronSchedule, error) {
+	if location == nil {
+		location = time.UTC
+	}
+
+	if descriptor, ok := cronDescriptors[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = descriptor
+	}
+
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, errors.Errorf("cron expression %q must have five or six fields", expr)
+	}
+
+	schedule := &cronSchedule{
+		location:    location,
+		dayStar:     strings.HasPrefix(fields[3], "*") || strings.HasPrefix(fields[3], "?"),
+		weekdayStar: strings.HasPrefix(fields[5], "*") || strings.HasPrefix(fields[5], "?"),
+	}
+
+	var err error
+	for i, target := range []struct {
+		field  *cronField
+		bounds cronFieldBounds
+	}{
+		{&schedule.second, cronSeconds},
+		{&schedule.minute, cronMinutes},
+		{&schedule.hour, cronHours},
+		{&schedule.day, cronDays},
+		{&schedule.month, cronMonths},
+		{&schedule.weekday, cronWeekdays},
+	} {
+		*target.field, err = parseCronField(fields[i], target.bounds, i == 3 || i == 5)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression %q", expr)
+		}
+	}
+
+	if schedule.weekday.has(7) {
+		schedule.weekday = schedule.weekday&^(1<<7) | 1
+	}
+
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and wildcards with optional
+// steps.
+func parseCronField(field string, bounds cronFieldBounds, allowQuestionMark bool) (cronField, error) {
+	var result cronField
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step %q in %s field", stepPart, bounds.name)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*" || (rangePart == "?" && allowQuestionMark):
+			low, high = bounds.min, bounds.max
+		default:
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			low, err = parseCronValue(lowPart, bounds)
+			if err != nil {
+				return 0, err
+			}
+
+			switch {
+			case isRange:
+				high, err = parseCronValue(highPart, bounds)
+				if err != nil {
+					return 0, err
+				}
+			case hasStep:
+				high = bounds.max
+			default:
+				high = low
+			}
+
+			if low > high {
+				return 0, errors.Errorf("invalid range %q in %s field", rangePart, bounds.name)
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			result |= 1 << uint(value)
+		}
+	}
+
+	return result, nil
+}
+
+// parseCronValue parses a single numeric or named value of a cron expression field.
+func parseCronValue(value string, bounds cronFieldBounds) (int, error) {
+	if named, ok := bounds.names[strings.ToUpper(value)]; ok {
+		return named, nil
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < bounds.min || parsed > bounds.max {
+		return 0, errors.Errorf("invalid value %q in %s field", value, bounds.name)
+	}
+
+	return parsed, nil
+}
+
+// matchesDay returns whether the given day matches the day of month and day of week fields.
+func (s *cronSchedule) matchesDay(civil time.Time) bool {
+	dayMatch := s.day.has(civil.Day())
+	weekdayMatch := s.weekday.has(int(civil.Weekday()))
+
+	if s.dayStar || s.weekdayStar {
+		return dayMatch && weekdayMatch
+	}
+
+	return dayMatch || weekdayMatch
+}
+
+// nextCivil returns the first wall clock time at or after the given one matching the schedule,
+// or the zero time if there is none. Wall clock times are represented in UTC, which has no
+// daylight saving time transitions to account for.
+func (s *cronSchedule) nextCivil(civil time.Time) time.Time {
+	limit := civil.Year() + cronSearchYears
+	for civil.Year() <= limit {
+		year, month, day := civil.Date()
+		switch {
+		case !s.month.has(int(month)):
+			civil = time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(civil):
+			civil = time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+		case !s.hour.has(civil.Hour()):
+			civil = civil.Truncate(time.Hour).Add(time.Hour)
+		case !s.minute.has(civil.Minute()):
+			civil = civil.Truncate(time.Minute).Add(time.Minute)
+		case !s.second.has(civil.Second()):
+			// Skip directly to the next matching second, if any in this minute.
+			next := s.second >> uint(civil.Second()+1)
+			if next == 0 {
+				civil = civil.Truncate(time.Minute).Add(time.Minute)
+			} else {
+				civil = civil.Add(time.Duration(bits.TrailingZeros64(uint64(next))+1) * time.Second)
+			}
+		default:
+			return civil
+		}
+	}
+
+	return time.Time{}
+}
+
+// instant returns the time at which the given wall clock time occurs in the schedule's location.
+func (s *cronSchedule) instant(civil time.Time) time.Time {
+	year, month, day := civil.Date()
+	hour, minute, second := civil.Clock()
+
+	t := time.Date(year, month, day, hour, minute, second, 0, s.location)
+	if toCivil(t).Equal(civil) {
+		return t
+	}
+
+	// The wall clock time was skipped by a transition moving the clock forward, such as when
+	// daylight saving time starts. Use the offset in effect before the transition, which is the
+	// smaller of the offsets on either side of it.
+	_, offset := t.Zone()
+	_, otherOffset := civil.Add(-time.Duration(offset) * time.Second).In(s.location).Zone()
+	if otherOffset < offset {
+		offset = otherOffset
+	}
+
+	return civil.Add(-time.Duration(offset) * time.Second).In(s.location)
+}
+
+// next returns the first time strictly after t matching the schedule, or the zero time if there
+// is none.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	civil := toCivil(t.In(s.location).Truncate(time.Second)).Add(time.Second)
+	for {
+		civil = s.nextCivil(civil)
+		if civil.IsZero() {
+			return time.Time{}
+		}
+
+		// Wall clock times repeated by a transition moving the clock backward, such as when
+		// daylight saving time ends, may have already occurred.
+		if next := s.instant(civil); next.After(t) {
+			return next
+		}
+
+		civil = civil.Add(time.Second)
+	}
+}
+
+// toCivil returns the wall clock time of t, represented in UTC.
+func toCivil(t time.Time) time.Time {
+	year, month, day := t.Date()
+	hour, minute, second := t.Clock()
+
+	return time.Date(year, month, day, hour, minute, second, 0, time.UTC)
+}
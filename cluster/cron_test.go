@@ -0,0 +1,227 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	require.NoError(t, err)
+
+	return location
+}
+
+func TestParseCron(t *testing.T) {
+	valid := []string{
+		"* * * * *",
+		"0 9 * * MON-FRI",
+		"*/15 * * * *",
+		"0 0 1,15 * ?",
+		"30 0 9 * * mon",
+		"0 0 * * 7",
+		"0 12 * JAN-MAR/2 *",
+		"5/10 * * * *",
+		"@daily",
+		"@hourly",
+	}
+	for _, expr := range valid {
+		_, err := parseCron(expr, nil)
+		assert.NoError(t, err, expr)
+	}
+
+	invalid := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * FOO",
+		"*/0 * * * *",
+		"10-5 * * * *",
+		"? * * * *",
+		"@never",
+	}
+	for _, expr := range invalid {
+		_, err := parseCron(expr, nil)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork := mustLoadLocation(t, "America/New_York")
+	berlin := mustLoadLocation(t, "Europe/Berlin")
+
+	testCases := []struct {
+		description string
+		expr        string
+		location    *time.Location
+		from        time.Time
+		expected    []time.Time
+	}{
+		{
+			"every minute",
+			"* * * * *",
+			time.UTC,
+			time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC),
+			[]time.Time{
+				time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 10, 2, 0, 0, time.UTC),
+			},
+		},
+		{
+			"seconds field",
+			"*/20 * * * * *",
+			time.UTC,
+			time.Date(2024, 1, 1, 10, 0, 30, 0, time.UTC),
+			[]time.Time{
+				time.Date(2024, 1, 1, 10, 0, 40, 0, time.UTC),
+				time.Date(2024, 1, 1, 10, 1, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 10, 1, 20, 0, time.UTC),
+			},
+		},
+		{
+			"weekdays at 9",
+			"0 9 * * MON-FRI",
+			newYork,
+			time.Date(2024, 1, 5, 9, 0, 0, 0, newYork), // Friday
+			[]time.Time{
+				time.Date(2024, 1, 8, 9, 0, 0, 0, newYork),
+				time.Date(2024, 1, 9, 9, 0, 0, 0, newYork),
+			},
+		},
+		{
+			"day of month or day of week",
+			"0 0 1 * SUN",
+			time.UTC,
+			time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{
+				time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 14, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 21, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 28, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			"leap day",
+			"0 0 29 2 *",
+			time.UTC,
+			time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			[]time.Time{
+				time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			"time skipped by daylight saving time",
+			"30 2 * * *",
+			newYork,
+			time.Date(2024, 3, 9, 3, 0, 0, 0, newYork),
+			[]time.Time{
+				time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), // 3:30 EDT
+				time.Date(2024, 3, 11, 6, 30, 0, 0, time.UTC), // 2:30 EDT
+			},
+		},
+		{
+			"time skipped by daylight saving time east of UTC",
+			"30 2 * * *",
+			berlin,
+			time.Date(2024, 3, 30, 3, 0, 0, 0, berlin),
+			[]time.Time{
+				time.Date(2024, 3, 31, 1, 30, 0, 0, time.UTC), // 3:30 CEST
+				time.Date(2024, 4, 1, 0, 30, 0, 0, time.UTC),  // 2:30 CEST
+			},
+		},
+		{
+			"time repeated by daylight saving time",
+			"30 1 * * *",
+			newYork,
+			time.Date(2024, 11, 2, 3, 0, 0, 0, newYork),
+			[]time.Time{
+				time.Date(2024, 11, 3, 5, 30, 0, 0, time.UTC), // 1:30 EDT
+				time.Date(2024, 11, 4, 6, 30, 0, 0, time.UTC), // 1:30 EST
+			},
+		},
+		{
+			"hourly across daylight saving time ending",
+			"0 * * * *",
+			newYork,
+			time.Date(2024, 11, 3, 4, 30, 0, 0, time.UTC), // 0:30 EDT
+			[]time.Time{
+				time.Date(2024, 11, 3, 5, 0, 0, 0, time.UTC), // 1:00 EDT
+				time.Date(2024, 11, 3, 7, 0, 0, 0, time.UTC), // 2:00 EST
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.description, func(t *testing.T) {
+			schedule, err := parseCron(testCase.expr, testCase.location)
+			require.NoError(t, err)
+
+			from := testCase.from
+			for _, expected := range testCase.expected {
+				next := schedule.next(from)
+				assert.True(t, expected.Equal(next), "expected %v, got %v", expected, next)
+				from = next
+			}
+		})
+	}
+
+	t.Run("never matches", func(t *testing.T) {
+		schedule, err := parseCron("0 0 30 2 *", nil)
+		require.NoError(t, err)
+		assert.True(t, schedule.next(time.Now()).IsZero())
+
+		_, err = MakeWaitForCron("0 0 30 2 *", nil, CronCatchUpRunOnce)
+		require.Error(t, err)
+	})
+}
+
+func TestMakeWaitForCron(t *testing.T) {
+	_, err := MakeWaitForCron("invalid", nil, CronCatchUpRunOnce)
+	require.Error(t, err)
+
+	// Daily at 9:00 UTC.
+	at9 := time.Date(2024, 1, 10, 9, 0, 0, 0, time.UTC)
+
+	for _, policy := range []CronCatchUpPolicy{CronCatchUpRunOnce, CronCatchUpSkip} {
+		waitForCron, err := MakeWaitForCron("0 9 * * *", time.UTC, policy)
+		require.NoError(t, err)
+
+		// Never run waits for the next time.
+		assert.Equal(t, time.Hour, waitForCron(at9.Add(-time.Hour), JobMetadata{}))
+
+		// Due now, or recently.
+		lastFinished := at9.Add(-24 * time.Hour).Add(time.Minute)
+		assert.Equal(t, time.Duration(0), waitForCron(at9, JobMetadata{LastFinished: lastFinished}))
+		assert.Equal(t, time.Duration(0), waitForCron(at9.Add(10*time.Second), JobMetadata{}))
+
+		// Already run.
+		assert.Equal(t, 24*time.Hour-time.Minute, waitForCron(at9.Add(time.Minute), JobMetadata{LastFinished: at9.Add(30 * time.Second)}))
+	}
+
+	t.Run("run once when missed", func(t *testing.T) {
+		waitForCron, err := MakeWaitForCron("0 9 * * *", time.UTC, CronCatchUpRunOnce)
+		require.NoError(t, err)
+
+		lastFinished := at9.Add(-72 * time.Hour)
+		assert.Equal(t, time.Duration(0), waitForCron(at9.Add(time.Hour), JobMetadata{LastFinished: lastFinished}))
+	})
+
+	t.Run("skip when missed", func(t *testing.T) {
+		waitForCron, err := MakeWaitForCron("0 9 * * *", time.UTC, CronCatchUpSkip)
+		require.NoError(t, err)
+
+		lastFinished := at9.Add(-72 * time.Hour)
+		assert.Equal(t, 23*time.Hour, waitForCron(at9.Add(time.Hour), JobMetadata{LastFinished: lastFinished}))
+	})
+}
@@ -0,0 +1,250 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// leaderPrefix is used to namespace key values created for an election.
+	leaderPrefix = "leader_"
+)
+
+// ElectionPluginAPI is the plugin API interface required to elect a leader.
+type ElectionPluginAPI interface {
+	MutexReaderPluginAPI
+}
+
+// LeaderInfo identifies the leader of an election.
+type LeaderInfo struct {
+	// ID identifies the elected plugin instance, and is unique to each Election.
+	ID string
+
+	// Hostname is the host name of the server running the elected plugin instance.
+	Hostname string
+
+	// ElectedAt is when the leader was elected.
+	ElectedAt time.Time
+}
+
+// ElectionCallbacks are called when a plugin instance becomes or stops being the leader.
+type ElectionCallbacks struct {
+	// OnElected is called in its own goroutine when the plugin instance is elected, and must be
+	// set. Its context is cancelled as soon as the plugin instance stops being the leader, at
+	// which point OnElected must return. If OnElected returns earlier, the plugin instance
+	// resigns and runs for election again after a delay, giving other plugin instances a chance
+	// to be elected.
+	OnElected func(ctx context.Context)
+
+	// OnDemoted is called once the plugin instance stopped being the leader and OnElected
+	// returned.
+	OnDemoted func()
+}
+
+// Election elects a single leader among the plugin instances running for the same election,
+// such as to run a long-lived loop on exactly one plugin instance.
+//
+// Leadership is held with a Mutex, and lost as soon as the mutex fails to be refreshed. As the
+// mutex may expire before its loss is noticed, the leader's work should tolerate briefly
+// overlapping with the next leader's.
+type Election struct {
+	pluginAPI ElectionPluginAPI
+	name      string
+	mutex     *Mutex
+	callbacks ElectionCallbacks
+	id        string
+	hostname  string
+
+	leader int32
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Elect runs for the election with the given name until Close is called.
+func Elect(pluginAPI ElectionPluginAPI, name string, callbacks ElectionCallbacks) (*Election, error) {
+	if name == "" {
+		return nil, errors.New("must specify valid election name")
+	}
+	if callbacks.OnElected == nil {
+		return nil, errors.New("must specify OnElected callback")
+	}
+
+	mutex, err := NewMutex(pluginAPI, leaderPrefix+name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create election mutex")
+	}
+
+	hostname, _ := os.Hostname()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Election{
+		pluginAPI: pluginAPI,
+		name:      name,
+		mutex:     mutex,
+		callbacks: callbacks,
+		id:        model.NewId(),
+		hostname:  hostname,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+
+	go e.run(ctx)
+
+	return e, nil
+}
+
+// ID returns the identifier of this plugin instance in the election, as reported by Leader.
+func (e *Election) ID() string {
+	return e.id
+}
+
+// IsLeader returns whether this plugin instance is currently the leader.
+func (e *Election) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// Leader returns the current leader of the election, or nil if there is none.
+func (e *Election) Leader() (*LeaderInfo, error) {
+	return Leader(e.pluginAPI, e.name)
+}
+
+// Close stops running for the election, resigning if this plugin instance is the leader. It
+// returns once OnElected and OnDemoted have returned.
+func (e *Election) Close() {
+	e.cancel()
+	<-e.done
+}
+
+func (e *Election) run(ctx context.Context) {
+	defer close(e.done)
+
+	for {
+		lost, err := e.mutex.lockWithContext(ctx)
+		if err != nil {
+			return
+		}
+
+		e.lead(ctx, lost)
+
+		// Wait before running for election again, so that other plugin instances may be elected
+		// and an OnElected returning right away doesn't spin.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(refreshInterval + time.Duration(rand.Int63n(int64(jitterWaitInterval)))):
+		}
+	}
+}
+
+// lead runs the leader's work until leadership is lost, the election is closed, or the work
+// returns.
+func (e *Election) lead(ctx context.Context, lost <-chan struct{}) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	info := LeaderInfo{
+		ID:        e.id,
+		Hostname:  e.hostname,
+		ElectedAt: time.Now(),
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		e.pluginAPI.LogError("failed to marshal leader", "err", err, "election", e.name)
+	}
+	e.saveLeader(data, nil)
+
+	atomic.StoreInt32(&e.leader, 1)
+
+	elected := make(chan struct{})
+	go func() {
+		defer close(elected)
+		e.callbacks.OnElected(leaderCtx)
+	}()
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	isLost := false
+	for running := true; running; {
+		select {
+		case <-lost:
+			isLost = true
+			running = false
+		case <-ctx.Done():
+			running = false
+		case <-elected:
+			running = false
+		case <-ticker.C:
+			e.saveLeader(data, data)
+		}
+	}
+
+	cancel()
+	<-elected
+	atomic.StoreInt32(&e.leader, 0)
+
+	if isLost {
+		// Another plugin instance may have been elected already, so leave its mutex be.
+		e.mutex.stopRefreshing()
+	} else {
+		_, _ = e.pluginAPI.KVSetWithOptions(leaderPrefix+e.name, nil, model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: data,
+		})
+		e.mutex.Unlock()
+	}
+
+	if e.callbacks.OnDemoted != nil {
+		e.callbacks.OnDemoted()
+	}
+}
+
+// saveLeader writes the leader's identity, expiring along with the election mutex.
+func (e *Election) saveLeader(data, oldData []byte) {
+	options := model.PluginKVSetOptions{
+		Atomic:          oldData != nil,
+		OldValue:        oldData,
+		ExpireInSeconds: int64(ttl / time.Second),
+	}
+
+	ok, appErr := e.pluginAPI.KVSetWithOptions(leaderPrefix+e.name, data, options)
+	if appErr == nil && !ok {
+		// The leader key expired or was removed. As the election mutex is held, it is safe to
+		// write it again regardless.
+		e.pluginAPI.LogError("leader changed unexpectedly, saving it again", "election", e.name)
+
+		options.Atomic = false
+		options.OldValue = nil
+		_, appErr = e.pluginAPI.KVSetWithOptions(leaderPrefix+e.name, data, options)
+	}
+	if appErr != nil {
+		e.pluginAPI.LogError("failed to save leader", "err", appErr, "election", e.name)
+	}
+}
+
+// Leader returns the current leader of the election with the given name, or nil if there is
+// none. It may be called by any plugin instance, including those not running for the election.
+func Leader(pluginAPI ElectionPluginAPI, name string) (*LeaderInfo, error) {
+	data, appErr := pluginAPI.KVGet(leaderPrefix + name)
+	if appErr != nil {
+		return nil, errors.Wrap(normalizeAppErr(appErr), "failed to read leader")
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var info LeaderInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, errors.Wrap(err, "failed to decode leader")
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,29 @@
+package cluster
+
+import (
+	"context"
+
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+func ExampleElect() {
+	// Use p.API from your plugin instead.
+	pluginAPI := plugin.API(nil)
+
+	election, err := Elect(pluginAPI, "sync", ElectionCallbacks{
+		OnElected: func(ctx context.Context) {
+			// long-lived work to do until ctx is cancelled
+			<-ctx.Done()
+		},
+		OnDemoted: func() {
+			// clean up after the work stopped
+		},
+	})
+	if err != nil {
+		panic("failed to run for election")
+	}
+
+	// main thread
+
+	defer election.Close()
+}
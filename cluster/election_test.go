@@ -0,0 +1,220 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type electionRecorder struct {
+	lock    sync.Mutex
+	ctx     context.Context
+	elected chan struct{}
+	demoted chan struct{}
+}
+
+func newElectionRecorder() *electionRecorder {
+	return &electionRecorder{
+		elected: make(chan struct{}, 10),
+		demoted: make(chan struct{}, 10),
+	}
+}
+
+func (r *electionRecorder) callbacks() ElectionCallbacks {
+	return ElectionCallbacks{
+		OnElected: func(ctx context.Context) {
+			r.lock.Lock()
+			r.ctx = ctx
+			r.lock.Unlock()
+
+			r.elected <- struct{}{}
+			<-ctx.Done()
+		},
+		OnDemoted: func() {
+			r.demoted <- struct{}{}
+		},
+	}
+}
+
+func (r *electionRecorder) leaderCtx() context.Context {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.ctx
+}
+
+func requireSignal(t *testing.T, c <-chan struct{}, timeout time.Duration, msg string) {
+	t.Helper()
+
+	select {
+	case <-c:
+	case <-time.After(timeout):
+		require.Fail(t, msg)
+	}
+}
+
+func requireNoSignal(t *testing.T, c <-chan struct{}, wait time.Duration, msg string) {
+	t.Helper()
+
+	select {
+	case <-c:
+		require.Fail(t, msg)
+	case <-time.After(wait):
+	}
+}
+
+func TestElect(t *testing.T) {
+	t.Run("empty name", func(t *testing.T) {
+		e, err := Elect(newMockPluginAPI(t), "", newElectionRecorder().callbacks())
+		require.Error(t, err)
+		require.Nil(t, e)
+	})
+
+	t.Run("missing OnElected", func(t *testing.T) {
+		e, err := Elect(newMockPluginAPI(t), "election", ElectionCallbacks{})
+		require.Error(t, err)
+		require.Nil(t, e)
+	})
+
+	t.Run("single candidate", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		recorder := newElectionRecorder()
+
+		e, err := Elect(mockPluginAPI, "election", recorder.callbacks())
+		require.NoError(t, err)
+
+		requireSignal(t, recorder.elected, time.Second, "expected to be elected")
+		assert.True(t, e.IsLeader())
+
+		leader, err := e.Leader()
+		require.NoError(t, err)
+		require.NotNil(t, leader)
+		assert.Equal(t, e.ID(), leader.ID)
+		assert.False(t, leader.ElectedAt.IsZero())
+
+		e.Close()
+		requireSignal(t, recorder.demoted, time.Second, "expected to be demoted")
+		assert.Error(t, recorder.leaderCtx().Err())
+		assert.False(t, e.IsLeader())
+
+		leader, err = Leader(mockPluginAPI, "election")
+		require.NoError(t, err)
+		assert.Nil(t, leader)
+	})
+
+	t.Run("failover", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		recorder1 := newElectionRecorder()
+		recorder2 := newElectionRecorder()
+
+		e1, err := Elect(mockPluginAPI, "election", recorder1.callbacks())
+		require.NoError(t, err)
+		requireSignal(t, recorder1.elected, time.Second, "expected first candidate to be elected")
+
+		e2, err := Elect(mockPluginAPI, "election", recorder2.callbacks())
+		require.NoError(t, err)
+		defer e2.Close()
+		requireNoSignal(t, recorder2.elected, 100*time.Millisecond, "second candidate unexpectedly elected")
+
+		leader, err := Leader(mockPluginAPI, "election")
+		require.NoError(t, err)
+		require.NotNil(t, leader)
+		assert.Equal(t, e1.ID(), leader.ID)
+
+		e1.Close()
+		requireSignal(t, recorder1.demoted, time.Second, "expected first candidate to be demoted")
+		requireSignal(t, recorder2.elected, 2*time.Second, "expected second candidate to be elected")
+		assert.True(t, e2.IsLeader())
+
+		leader, err = Leader(mockPluginAPI, "election")
+		require.NoError(t, err)
+		require.NotNil(t, leader)
+		assert.Equal(t, e2.ID(), leader.ID)
+	})
+
+	t.Run("resigns when work returns", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+
+		elected := make(chan struct{}, 10)
+		demoted := make(chan struct{}, 10)
+		e, err := Elect(mockPluginAPI, "election", ElectionCallbacks{
+			OnElected: func(ctx context.Context) {
+				elected <- struct{}{}
+			},
+			OnDemoted: func() {
+				demoted <- struct{}{}
+			},
+		})
+		require.NoError(t, err)
+		defer e.Close()
+
+		requireSignal(t, elected, time.Second, "expected to be elected")
+		requireSignal(t, demoted, time.Second, "expected to be demoted")
+		requireNoSignal(t, elected, time.Second, "expected not to be elected again right away")
+		requireSignal(t, elected, refreshInterval+2*time.Second, "expected to be elected again")
+	})
+
+	t.Run("leader saved again once removed", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		e := &Election{
+			pluginAPI: mockPluginAPI,
+			name:      "election",
+			id:        "id",
+		}
+
+		data := []byte(`{"ID":"id"}`)
+		e.saveLeader(data, nil)
+		appErr := mockPluginAPI.KVDelete(leaderPrefix + "election")
+		require.Nil(t, appErr)
+
+		e.saveLeader(data, data)
+
+		leader, err := e.Leader()
+		require.NoError(t, err)
+		require.NotNil(t, leader)
+		assert.Equal(t, "id", leader.ID)
+	})
+
+	t.Run("leadership lost", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		recorder := newElectionRecorder()
+
+		mutex := mustNewMutex(mockPluginAPI, leaderPrefix+"election")
+		e := &Election{
+			pluginAPI: mockPluginAPI,
+			name:      "election",
+			mutex:     mutex,
+			callbacks: recorder.callbacks(),
+			id:        "id",
+		}
+
+		_, err := mutex.lockWithContext(context.Background())
+		require.NoError(t, err)
+
+		lost := make(chan struct{})
+		led := make(chan struct{})
+		go func() {
+			defer close(led)
+			e.lead(context.Background(), lost)
+		}()
+
+		requireSignal(t, recorder.elected, time.Second, "expected to be elected")
+		assert.True(t, e.IsLeader())
+		require.NoError(t, recorder.leaderCtx().Err())
+
+		close(lost)
+		requireSignal(t, recorder.demoted, time.Second, "expected to be demoted")
+		requireSignal(t, led, time.Second, "expected leadership to end")
+		assert.Error(t, recorder.leaderCtx().Err())
+		assert.False(t, e.IsLeader())
+
+		// The mutex is left to expire rather than deleted, as it may belong to the next leader.
+		value, appErr := mockPluginAPI.KVGet(mutex.key)
+		require.Nil(t, appErr)
+		assert.NotNil(t, value)
+	})
+}
@@ -1,8 +1,9 @@
 package cluster
 
 import (
+	"context"
 	"encoding/json"
-	"sync"
+	"os"
 	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
@@ -17,8 +18,7 @@ const (
 
 // JobPluginAPI is the plugin API interface required to schedule jobs.
 type JobPluginAPI interface {
-	MutexPluginAPI
-	KVGet(key string) ([]byte, *model.AppError)
+	MutexReaderPluginAPI
 	KVDelete(key string) *model.AppError
 	KVList(page, count int) ([]string, *model.AppError)
 }
@@ -29,6 +29,25 @@ type JobConfig struct {
 	Interval time.Duration
 }
 
+// defaultJobHistorySize is the default number of runs kept in a job's history.
+const defaultJobHistorySize = 10
+
+// maxJobRunErrorLength bounds the length of the error recorded for a failed run.
+const maxJobRunErrorLength = 1024
+
+// JobOptions configures a scheduled job.
+type JobOptions struct {
+	// HistorySize is the number of most recent runs kept in the job's history, defaulting to 10.
+	HistorySize int
+
+	// Timeout, if set, cancels the context passed to the callback once a run has taken this long.
+	Timeout time.Duration
+
+	// FailureBackoff, if set, returns how long to wait after the given number of consecutive
+	// failed runs before retrying, instead of waiting for the next scheduled run.
+	FailureBackoff func(failures int) time.Duration
+}
+
 // NextWaitInterval is a callback computing the next wait interval for a job.
 type NextWaitInterval func(now time.Time, metadata JobMetadata) time.Duration
 
@@ -97,23 +116,87 @@ type Job struct {
 	key              string
 	mutex            *Mutex
 	nextWaitInterval NextWaitInterval
-	callback         func()
+	callback         func(ctx context.Context) error
+	options          JobOptions
 
-	stopOnce sync.Once
-	stop     chan bool
-	done     chan bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan bool
 }
 
 // JobMetadata persists metadata about job execution.
 type JobMetadata struct {
 	// LastFinished is the last time the job finished anywhere in the cluster.
 	LastFinished time.Time
+
+	// NextRun is when the job is next expected to run.
+	NextRun time.Time `json:",omitempty"`
+
+	// ConsecutiveFailures is the number of runs that failed since the last successful one.
+	ConsecutiveFailures int `json:",omitempty"`
+
+	// Current is the run in progress, if any. It is left set if the plugin instance running the
+	// job died or was interrupted during the run, until the job is next locked after the mutex
+	// TTL has passed since the run started.
+	Current *JobRun `json:",omitempty"`
+
+	// History holds the most recent runs, most recent first.
+	History []JobRun `json:",omitempty"`
+}
+
+// JobRun describes a single run of a job.
+type JobRun struct {
+	// Started is when the run started.
+	Started time.Time
+
+	// Finished is when the run finished, unset while running.
+	Finished time.Time
+
+	// Duration is how long the run took.
+	Duration time.Duration
+
+	// NodeID is the host name of the server running the plugin instance that ran the job.
+	NodeID string
+
+	// Error is the error returned by the run, if it failed.
+	Error string `json:",omitempty"`
+}
+
+// JobStatus reports the status of a job, as returned by InspectJob.
+type JobStatus struct {
+	JobMetadata
+
+	// Running is true while a plugin instance is running the job.
+	Running bool
 }
 
 // Schedule creates a scheduled job.
 func Schedule(pluginAPI JobPluginAPI, key string, nextWaitInterval NextWaitInterval, callback func()) (*Job, error) {
+	return ScheduleWithOptions(pluginAPI, key, nextWaitInterval, func(ctx context.Context) error {
+		callback()
+		return nil
+	}, JobOptions{})
+}
+
+// ScheduleWithOptions creates a scheduled job whose callback may fail, with the given options.
+//
+// The context passed to the callback is canceled when the job is closed, when the run exceeds
+// the configured timeout, or as soon as the job mutex is lost and another plugin instance may
+// start running the job. Failed runs are logged and recorded in the job's history, as reported by
+// InspectJob. Runs interrupted by closing the job or losing its mutex are not recorded.
+func ScheduleWithOptions(
+	pluginAPI JobPluginAPI,
+	key string,
+	nextWaitInterval NextWaitInterval,
+	callback func(ctx context.Context) error,
+	options JobOptions,
+) (*Job, error) {
 	key = cronPrefix + key
 
+	if options.HistorySize <= 0 {
+		options.HistorySize = defaultJobHistorySize
+	}
+
 	mutex, err := NewMutex(pluginAPI, key)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create job mutex")
@@ -125,18 +208,47 @@ func Schedule(pluginAPI JobPluginAPI, key string, nextWaitInterval NextWaitInter
 		mutex:            mutex,
 		nextWaitInterval: nextWaitInterval,
 		callback:         callback,
-		stop:             make(chan bool),
+		options:          options,
 		done:             make(chan bool),
 	}
+	job.ctx, job.cancel = context.WithCancel(context.Background())
 
 	go job.run()
 
 	return job, nil
 }
 
+// InspectJob returns the status of the job with the given key, as scheduled by any plugin
+// instance.
+func InspectJob(pluginAPI JobPluginAPI, key string) (*JobStatus, error) {
+	key = cronPrefix + key
+
+	metadata, err := readJobMetadata(pluginAPI, key)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &JobStatus{JobMetadata: metadata}
+
+	if metadata.Current != nil {
+		// The run was interrupted if the job mutex expired.
+		owner, inspectErr := InspectMutex(pluginAPI, key)
+		if inspectErr != nil {
+			return nil, errors.Wrap(inspectErr, "failed to inspect job mutex")
+		}
+		status.Running = owner != nil
+	}
+
+	return status, nil
+}
+
 // readMetadata reads the job execution metadata from the kv store.
 func (j *Job) readMetadata() (JobMetadata, error) {
-	data, appErr := j.pluginAPI.KVGet(j.key)
+	return readJobMetadata(j.pluginAPI, j.key)
+}
+
+func readJobMetadata(pluginAPI JobPluginAPI, key string) (JobMetadata, error) {
+	data, appErr := pluginAPI.KVGet(key)
 	if appErr != nil {
 		return JobMetadata{}, errors.Wrap(appErr, "failed to read data")
 	}
@@ -179,14 +291,18 @@ func (j *Job) run() {
 
 	for {
 		select {
-		case <-j.stop:
+		case <-j.ctx.Done():
 			return
 		case <-time.After(waitInterval):
 		}
 
 		func() {
 			// Acquire the corresponding job lock and hold it throughout execution.
-			j.mutex.Lock()
+			heldCtx, err := j.mutex.LockWithHeldContext(j.ctx)
+			if err != nil {
+				// The job was closed.
+				return
+			}
 			defer j.mutex.Unlock()
 
 			metadata, err := j.readMetadata()
@@ -196,33 +312,123 @@ func (j *Job) run() {
 				return
 			}
 
+			// Clear a run left in progress by a plugin instance that died or was interrupted, as it
+			// can no longer be running once the mutex is held long after it started.
+			now := time.Now()
+			staleRun := metadata.Current != nil && now.Sub(metadata.Current.Started) > j.mutex.ttl
+			if staleRun {
+				metadata.Current = nil
+			}
+
 			// Is it time to run the job?
-			waitInterval = j.nextWaitInterval(time.Now(), metadata)
+			waitInterval = j.waitInterval(now, metadata)
 			if waitInterval > 0 {
+				// Keep the next run reported by InspectJob up to date.
+				nextRun := now.Add(waitInterval)
+				if staleRun || nextRun.Sub(metadata.NextRun).Abs() > time.Second {
+					metadata.NextRun = nextRun
+					if err = j.saveMetadata(metadata); err != nil {
+						j.pluginAPI.LogError("failed to write job data", "err", err, "key", j.key)
+					}
+				}
 				return
 			}
 
 			// Run the job
-			j.callback()
+			hostname, _ := os.Hostname()
+			run := JobRun{
+				Started: now,
+				NodeID:  hostname,
+			}
+
+			metadata.Current = &run
+			if err = j.saveMetadata(metadata); err != nil {
+				j.pluginAPI.LogError("failed to write job data", "err", err, "key", j.key)
+			}
+
+			runCtx := heldCtx
+			if j.options.Timeout > 0 {
+				var cancel context.CancelFunc
+				runCtx, cancel = context.WithTimeout(heldCtx, j.options.Timeout)
+				defer cancel()
+			}
+
+			runErr := j.callback(runCtx)
+
+			// Leave a run interrupted by losing the mutex or closing the job out of the history, as
+			// it did not fail on its own. Nothing is saved, as another plugin instance may hold the
+			// mutex once lost. Runs that completed before noticing the job was closed are recorded.
+			if heldCtx.Err() != nil && (j.ctx.Err() == nil || runErr != nil) {
+				if j.ctx.Err() == nil {
+					j.pluginAPI.LogError("job interrupted by losing its mutex", "err", runErr, "key", j.key)
+				}
+				waitInterval = nextWaitInterval(0, nil)
+				return
+			}
+
+			run.Finished = time.Now()
+			run.Duration = run.Finished.Sub(run.Started)
+			if runErr != nil {
+				j.pluginAPI.LogError("job failed", "err", runErr, "key", j.key)
+
+				run.Error = runErr.Error()
+				if len(run.Error) > maxJobRunErrorLength {
+					run.Error = run.Error[:maxJobRunErrorLength]
+				}
+				metadata.ConsecutiveFailures++
+			} else {
+				metadata.ConsecutiveFailures = 0
+			}
+
+			metadata.LastFinished = run.Finished
+			metadata.Current = nil
+			metadata.History = append([]JobRun{run}, metadata.History...)
+			if len(metadata.History) > j.options.HistorySize {
+				metadata.History = metadata.History[:j.options.HistorySize]
+			}
 
-			metadata.LastFinished = time.Now()
+			now = time.Now()
+			waitInterval = j.waitInterval(now, metadata)
+			metadata.NextRun = now.Add(waitInterval)
 
 			err = j.saveMetadata(metadata)
 			if err != nil {
 				j.pluginAPI.LogError("failed to write job data", "err", err, "key", j.key)
 			}
-
-			waitInterval = j.nextWaitInterval(time.Now(), metadata)
 		}()
 	}
 }
 
+// waitInterval determines how long to wait until the next run, backing off after failed runs if
+// configured.
+func (j *Job) waitInterval(now time.Time, metadata JobMetadata) time.Duration {
+	if metadata.ConsecutiveFailures > 0 && j.options.FailureBackoff != nil {
+		wait := j.options.FailureBackoff(metadata.ConsecutiveFailures) - now.Sub(metadata.LastFinished)
+		if wait < 0 {
+			return 0
+		}
+
+		return wait
+	}
+
+	return j.nextWaitInterval(now, metadata)
+}
+
 // Close terminates a scheduled job, preventing it from being scheduled on this plugin instance.
+// The context of a running callback is canceled, and Close waits for the callback to return.
 func (j *Job) Close() error {
-	j.stopOnce.Do(func() {
-		close(j.stop)
-	})
-	<-j.done
+	return j.CloseWithContext(context.Background())
+}
 
-	return nil
+// CloseWithContext terminates a scheduled job like Close, but gives up waiting for a running
+// callback to return once the given context is done, returning the context's error.
+func (j *Job) CloseWithContext(ctx context.Context) error {
+	j.cancel()
+
+	select {
+	case <-j.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
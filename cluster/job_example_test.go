@@ -23,3 +23,30 @@ func ExampleSchedule() {
 
 	defer job.Close()
 }
+
+func ExampleMakeWaitForCron() {
+	// Use p.API from your plugin instead.
+	pluginAPI := plugin.API(nil)
+
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		panic("failed to load location")
+	}
+
+	// Send a digest at 9:00 AM on weekdays, once as soon as possible if missed.
+	waitForCron, err := MakeWaitForCron("0 9 * * MON-FRI", location, CronCatchUpRunOnce)
+	if err != nil {
+		panic("invalid cron expression")
+	}
+
+	job, err := Schedule(pluginAPI, "digest", waitForCron, func() {
+		// send the digest
+	})
+	if err != nil {
+		panic("failed to schedule job")
+	}
+
+	// main thread
+
+	defer job.Close()
+}
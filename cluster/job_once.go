@@ -1,9 +1,12 @@
 package cluster
 
 import (
+	"context"
 	"encoding/json"
+	"math"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
@@ -14,6 +17,23 @@ const (
 	// oncePrefix is used to namespace key values created for a scheduleOnce job
 	oncePrefix = "once_"
 
+	// onceNamespacePrefix is used to namespace key values created for a scheduleOnce job by a
+	// scheduler with its own namespace. The namespace follows, separated from the job key by a
+	// colon.
+	onceNamespacePrefix = "once:"
+
+	// onceIndexPrefix is used to namespace key values indexing the jobs of the scheduler returned
+	// by GetJobOnceScheduler.
+	onceIndexPrefix = "onceindex_"
+
+	// onceIndexNamespacePrefix is used to namespace key values indexing the jobs of a scheduler
+	// with its own namespace. The namespace follows, separated from the shard by a colon.
+	onceIndexNamespacePrefix = "onceindex:"
+
+	// onceIndexShards is the number of key values the index of a scheduler's jobs is spread
+	// across.
+	onceIndexShards = 32
+
 	// keysPerPage is the maximum number of keys to retrieve from the db per call
 	keysPerPage = 1000
 
@@ -30,26 +50,176 @@ const (
 	// scheduleOnceJitter is the range of jitter to add to intervals to avoid contention issues
 	scheduleOnceJitter = 100 * time.Millisecond
 
+	// jobOnceChangeEventID identifies the cluster events notifying of changes to scheduled jobs.
+	// Like other identifiers reserved for use by this module, it starts with "mmi_".
+	jobOnceChangeEventID = "mmi_job_once_change"
+
 	// propsLimit is the maximum length in bytes of the json-representation of a job's props.
 	// It exists to prevent job go rountines from consuming too much memory, as they are long running.
 	propsLimit = 10000
 )
 
+// errNoJobOnceHandler is returned when no handler is registered for a job's type.
+var errNoJobOnceHandler = errors.New("no handler registered for job type")
+
+// clusterEventPublisher is the plugin API interface required to notify other plugin instances of
+// changes to scheduled jobs. It is optional: without it, changes are picked up when polling.
+type clusterEventPublisher interface {
+	PublishPluginClusterEvent(ev model.PluginClusterEvent, opts model.PluginClusterEventSendOptions) error
+}
+
+// jobOnceChange is the payload of a change event.
+type jobOnceChange struct {
+	Namespace string `json:"n,omitempty"`
+	Key       string `json:"k"`
+}
+
 type JobOnceMetadata struct {
 	Key   string
 	RunAt time.Time
 	Props any
+
+	// Type is the job type, selecting the handler registered with RegisterHandler. Jobs without
+	// a type are passed to the callback set with SetCallback.
+	Type string `json:",omitempty"`
+
+	// Timeout, if set, cancels the context passed to the callback once the job has run for this
+	// long.
+	Timeout time.Duration `json:",omitempty"`
+
+	// Retry, if set, is the policy for retrying the job when its callback returns an error.
+	Retry *JobOnceRetryPolicy `json:",omitempty"`
+
+	// Attempts is the number of times the job's callback returned an error.
+	Attempts int `json:",omitempty"`
+
+	// LastError is the error last returned by the job's callback.
+	LastError string `json:",omitempty"`
+
+	// NextAttemptAt, if set, is the time at which the job is retried after an error.
+	NextAttemptAt time.Time
+
+	// Failed is set once the job's callback returned an error and no attempts are left. Failed
+	// jobs are not run again unless requeued with Requeue.
+	Failed bool `json:",omitempty"`
+
+	// Revision is incremented each time the job is rescheduled, for plugin instances tracking the
+	// job to pick up the change.
+	Revision int64 `json:",omitempty"`
+}
+
+// DecodeProps decodes the job's props into v, such as the struct they were scheduled with.
+func (m JobOnceMetadata) DecodeProps(v any) error {
+	data, err := json.Marshal(m.Props)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal props")
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrap(err, "failed to decode props")
+	}
+
+	return nil
+}
+
+// TypedJobOnceHandler adapts a handler receiving the job's props decoded into T, for use with
+// RegisterHandler.
+func TypedJobOnceHandler[T any](handler func(ctx context.Context, metadata JobOnceMetadata, props T) error) func(ctx context.Context, metadata JobOnceMetadata) error {
+	return func(ctx context.Context, metadata JobOnceMetadata) error {
+		var props T
+		if err := metadata.DecodeProps(&props); err != nil {
+			return err
+		}
+
+		return handler(ctx, metadata, props)
+	}
+}
+
+// JobOnceOptions configures a job scheduled to run once.
+type JobOnceOptions struct {
+	// Type is the job type, selecting the handler registered with RegisterHandler.
+	Type string
+
+	// Timeout, if set, cancels the context passed to the callback once the job has run for this
+	// long.
+	Timeout time.Duration
+
+	// Retry, if set, retries the job when its callback returns an error. Without a retry
+	// policy, the job fails as soon as its callback returns an error.
+	Retry *JobOnceRetryPolicy
+}
+
+// JobOnceRetryPolicy configures how a job scheduled to run once is retried when its callback
+// returns an error.
+type JobOnceRetryPolicy struct {
+	// MaxAttempts is the maximum number of times the callback is called, including the first
+	// attempt, before the job fails.
+	MaxAttempts int
+
+	// InitialBackoff is the time to wait before the first retry. Defaults to one minute.
+	InitialBackoff time.Duration
+
+	// MaxBackoff bounds the time to wait between retries. Defaults to one hour.
+	MaxBackoff time.Duration
+
+	// Multiplier increases the time to wait after each retry. Defaults to 2.
+	Multiplier float64
+}
+
+// backoff returns the time to wait before retrying a job after the given number of failed
+// attempts.
+func (p JobOnceRetryPolicy) backoff(attempts int) time.Duration {
+	initialBackoff := p.InitialBackoff
+	if initialBackoff <= 0 {
+		initialBackoff = time.Minute
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = time.Hour
+	}
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	backoff := float64(initialBackoff) * math.Pow(multiplier, float64(attempts-1))
+	if backoff > float64(maxBackoff) {
+		return maxBackoff
+	}
+
+	return time.Duration(backoff)
 }
 
 type JobOnce struct {
 	pluginAPI    JobPluginAPI
 	clusterMutex *Mutex
-
-	// key is the original key. It is prefixed with oncePrefix when used as a key in the KVStore
-	key      string
+	index        *keyIndex
+	namespace    string
+
+	// key is the original key. It is prefixed according to the scheduler's namespace when used
+	// as a key in the KVStore, as metadataKey.
+	key         string
+	metadataKey string
+	jobType     string
+	timeout     time.Duration
+	retry       *JobOnceRetryPolicy
+	numFails    int
+
+	// mu guards the fields changed when the job is rescheduled.
+	mu       sync.Mutex
 	props    any
 	runAt    time.Time
-	numFails int
+	revision int64
+
+	// wake signals the job.run go routine to pick up a change to the job.
+	wake chan struct{}
+
+	// ctx is canceled to interrupt the job, both when canceled and when the scheduler is closed.
+	ctx       context.Context
+	cancelCtx context.CancelFunc
+
+	// stopped is set when the scheduler is closed, leaving the job scheduled.
+	stopped int32
 
 	// done signals the job.run go routine to exit
 	done     chan bool
@@ -67,10 +237,14 @@ type JobOnce struct {
 // Cancel terminates a scheduled job, preventing it from being scheduled on this plugin instance.
 // It also removes the job from the db, preventing it from being run in the future.
 func (j *JobOnce) Cancel() {
-	j.clusterMutex.Lock()
-	defer j.clusterMutex.Unlock()
+	// Interrupt the callback if running, as it holds the mutex.
+	j.cancelCtx()
 
+	j.clusterMutex.Lock()
 	j.cancelWhileHoldingMutex()
+	j.clusterMutex.Unlock()
+
+	publishJobOnceChange(j.pluginAPI, j.namespace, j.key)
 
 	// join the running goroutine
 	j.joinOnce.Do(func() {
@@ -78,8 +252,8 @@ func (j *JobOnce) Cancel() {
 	})
 }
 
-func newJobOnce(pluginAPI JobPluginAPI, key string, runAt time.Time, callback *syncedCallback, jobs *syncedJobs, props any) (*JobOnce, error) {
-	mutex, err := NewMutex(pluginAPI, key)
+func (s *JobOnceScheduler) newJobOnce(key string, runAt time.Time, props any) (*JobOnce, error) {
+	mutex, err := NewMutex(s.pluginAPI, s.mutexKey(key))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create job mutex")
 	}
@@ -93,38 +267,55 @@ func newJobOnce(pluginAPI JobPluginAPI, key string, runAt time.Time, callback *s
 		return nil, errors.Errorf("props length extends limit")
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &JobOnce{
-		pluginAPI:      pluginAPI,
+		pluginAPI:      s.pluginAPI,
 		clusterMutex:   mutex,
+		index:          s.index,
+		namespace:      s.namespace,
 		key:            key,
+		metadataKey:    s.metadataKey(key),
 		props:          props,
 		runAt:          runAt,
+		wake:           make(chan struct{}, 1),
 		done:           make(chan bool),
 		join:           make(chan bool),
-		storedCallback: callback,
-		activeJobs:     jobs,
+		storedCallback: s.storedCallback,
+		activeJobs:     s.activeJobs,
+		ctx:            ctx,
+		cancelCtx:      cancel,
 	}, nil
 }
 
 func (j *JobOnce) run() {
 	defer close(j.join)
 
+	j.mu.Lock()
 	wait := time.Until(j.runAt)
+	j.mu.Unlock()
 
 	for {
 		select {
 		case <-j.done:
 			return
+		case <-j.ctx.Done():
+			return
+		case <-j.wake:
 		case <-time.After(wait + addJitter()):
 		}
 
 		func() {
 			// Acquire the cluster mutex while we're trying to do the job
-			j.clusterMutex.Lock()
+			heldCtx, err := j.clusterMutex.LockWithHeldContext(j.ctx)
+			if err != nil {
+				// The job was canceled, or the scheduler closed.
+				return
+			}
 			defer j.clusterMutex.Unlock()
 
 			// Check that the job has not been completed
-			metadata, err := readMetadata(j.pluginAPI, j.key)
+			metadata, err := readMetadata(j.pluginAPI, j.metadataKey)
 			if err != nil {
 				j.numFails++
 				if j.numFails > maxNumFails {
@@ -137,30 +328,175 @@ func (j *JobOnce) run() {
 				return
 			}
 
-			// If key doesn't exist, or if the runAt has changed, the original job has been completed already
-			if metadata == nil || !j.runAt.Equal(metadata.RunAt) {
+			// If key doesn't exist, the job has been completed or canceled already
+			if metadata == nil {
 				j.cancelWhileHoldingMutex()
 				return
 			}
 
-			j.executeJob()
+			// Wait until the job is due, as it may have been rescheduled.
+			if untilRunAt := j.refresh(*metadata); untilRunAt > 0 {
+				wait = untilRunAt
+				return
+			}
+
+			// Leave failed jobs in the db until requeued.
+			if metadata.Failed {
+				j.untrackWhileHoldingMutex()
+				return
+			}
+
+			// Wait for the next attempt if the job is being retried.
+			if untilNextAttempt := time.Until(metadata.NextAttemptAt); untilNextAttempt > 0 {
+				wait = untilNextAttempt
+				return
+			}
+
+			if err = j.executeJob(heldCtx, *metadata); err != nil {
+				if errors.Is(err, errNoJobOnceHandler) {
+					// Leave the job to a plugin instance able to handle it.
+					j.pluginAPI.LogError("failed to run job", "err", err, "key", j.key)
+					j.untrackWhileHoldingMutex()
+					return
+				}
+
+				// Leave the job interrupted by closing the scheduler to run again once started.
+				if atomic.LoadInt32(&j.stopped) == 1 {
+					return
+				}
+
+				// Retry the job interrupted by losing the mutex, as it did not fail on its own. The
+				// metadata is left alone, as another plugin instance may now hold the mutex.
+				if heldCtx.Err() != nil && j.ctx.Err() == nil {
+					j.pluginAPI.LogError("job interrupted by losing its mutex", "err", err, "key", j.key)
+					return
+				}
+
+				// Errors from jobs interrupted by Cancel are expected.
+				if j.ctx.Err() == nil {
+					j.pluginAPI.LogError("job failed", "err", err, "key", j.key, "attempt", metadata.Attempts+1)
+					wait = j.failWhileHoldingMutex(*metadata, err)
+					return
+				}
+			}
 
 			j.cancelWhileHoldingMutex()
 		}()
 	}
 }
 
-func (j *JobOnce) executeJob() {
+func (j *JobOnce) executeJob(ctx context.Context, metadata JobOnceMetadata) (err error) {
 	j.storedCallback.mu.Lock()
 	defer j.storedCallback.mu.Unlock()
 
-	j.storedCallback.callback(j.key, j.props)
+	defer func() {
+		if r := recover(); r != nil {
+			err = errors.Errorf("job panicked: %v", r)
+		}
+	}()
+
+	if metadata.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, metadata.Timeout)
+		defer cancel()
+	}
+
+	if metadata.Type != "" {
+		handler, ok := j.storedCallback.handlers[metadata.Type]
+		if !ok {
+			return errors.Wrap(errNoJobOnceHandler, metadata.Type)
+		}
+
+		return handler(ctx, metadata)
+	}
+
+	if j.storedCallback.callback == nil {
+		return errors.Wrap(errNoJobOnceHandler, "callback not set")
+	}
+
+	j.mu.Lock()
+	props := j.props
+	j.mu.Unlock()
+
+	return j.storedCallback.callback(ctx, j.key, props)
+}
+
+// refresh picks up the time and props of the job from its stored metadata if it was rescheduled
+// since, returning the time until the job is due.
+func (j *JobOnce) refresh(metadata JobOnceMetadata) time.Duration {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if metadata.Revision != j.revision || !metadata.RunAt.Equal(j.runAt) {
+		j.runAt = metadata.RunAt
+		j.props = metadata.Props
+		j.revision = metadata.Revision
+	}
+
+	return time.Until(j.runAt)
+}
+
+// reschedule changes the time and props of the job on this plugin instance, once changed in the
+// db, and wakes the job.run go routine to pick up the change.
+func (j *JobOnce) reschedule(runAt time.Time, props any, revision int64) {
+	j.mu.Lock()
+	j.runAt = runAt
+	j.props = props
+	j.revision = revision
+	j.mu.Unlock()
+
+	j.wakeUp()
+}
+
+// wakeUp wakes the job.run go routine to read the job's metadata again.
+func (j *JobOnce) wakeUp() {
+	select {
+	case j.wake <- struct{}{}:
+	default:
+	}
 }
 
-// readMetadata reads the job's stored metadata. If the caller wishes to make an atomic
-// read/write, the cluster mutex for job's key should be held.
-func readMetadata(pluginAPI JobPluginAPI, key string) (*JobOnceMetadata, error) {
-	data, appErr := pluginAPI.KVGet(oncePrefix + key)
+// failWhileHoldingMutex records the error returned by the job's callback, scheduling the next
+// attempt according to the job's retry policy, or marking the job as failed once no attempts are
+// left. It returns the time to wait before the next attempt. It assumes the caller holds the job's
+// mutex.
+func (j *JobOnce) failWhileHoldingMutex(metadata JobOnceMetadata, jobErr error) time.Duration {
+	metadata.Attempts++
+	metadata.LastError = jobErr.Error()
+	if len(metadata.LastError) > maxJobRunErrorLength {
+		metadata.LastError = metadata.LastError[:maxJobRunErrorLength]
+	}
+
+	if metadata.Retry == nil || metadata.Attempts >= metadata.Retry.MaxAttempts {
+		metadata.Failed = true
+		metadata.NextAttemptAt = time.Time{}
+	} else {
+		metadata.NextAttemptAt = time.Now().Add(metadata.Retry.backoff(metadata.Attempts))
+	}
+
+	if err := updateMetadata(j.pluginAPI, j.metadataKey, metadata); err != nil {
+		j.pluginAPI.LogError("failed to record job failure", "err", err, "key", j.key)
+		return waitAfterFail
+	}
+
+	if metadata.Failed {
+		j.untrackWhileHoldingMutex()
+		return 0
+	}
+
+	return time.Until(metadata.NextAttemptAt)
+}
+
+// stop interrupts the job without canceling it, leaving it scheduled.
+func (j *JobOnce) stop() {
+	atomic.StoreInt32(&j.stopped, 1)
+	j.cancelCtx()
+}
+
+// readMetadata reads the job's stored metadata from the given key value. If the caller wishes to
+// make an atomic read/write, the cluster mutex for job's key should be held.
+func readMetadata(pluginAPI JobPluginAPI, metadataKey string) (*JobOnceMetadata, error) {
+	data, appErr := pluginAPI.KVGet(metadataKey)
 	if appErr != nil {
 		return nil, errors.Wrap(normalizeAppErr(appErr), "failed to read data")
 	}
@@ -177,6 +513,21 @@ func readMetadata(pluginAPI JobPluginAPI, key string) (*JobOnceMetadata, error)
 	return &metadata, nil
 }
 
+// updateMetadata overwrites the job's stored metadata at the given key value. The cluster mutex
+// for the job's key should be held.
+func updateMetadata(pluginAPI JobPluginAPI, metadataKey string, metadata JobOnceMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal data")
+	}
+
+	if _, appErr := pluginAPI.KVSetWithOptions(metadataKey, data, model.PluginKVSetOptions{}); appErr != nil {
+		return normalizeAppErr(appErr)
+	}
+
+	return nil
+}
+
 // saveMetadata writes the job's metadata to the kvstore. saveMetadata acquires the job's cluster lock.
 // saveMetadata will not overwrite an existing key.
 func (j *JobOnce) saveMetadata() error {
@@ -184,16 +535,23 @@ func (j *JobOnce) saveMetadata() error {
 	defer j.clusterMutex.Unlock()
 
 	metadata := JobOnceMetadata{
-		Key:   j.key,
-		Props: j.props,
-		RunAt: j.runAt,
+		Key:     j.key,
+		Type:    j.jobType,
+		Props:   j.props,
+		RunAt:   j.runAt,
+		Timeout: j.timeout,
+		Retry:   j.retry,
 	}
 	data, err := json.Marshal(metadata)
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal data")
 	}
 
-	ok, appErr := j.pluginAPI.KVSetWithOptions(oncePrefix+j.key, data, model.PluginKVSetOptions{
+	if err = j.index.add(j.key); err != nil {
+		return err
+	}
+
+	ok, appErr := j.pluginAPI.KVSetWithOptions(j.metadataKey, data, model.PluginKVSetOptions{
 		Atomic:   true,
 		OldValue: nil,
 	})
@@ -207,11 +565,60 @@ func (j *JobOnce) saveMetadata() error {
 	return nil
 }
 
+// upsertMetadata writes the job's metadata to the kvstore, overwriting the metadata of an existing
+// job with the same key. Without options, the job must exist and keeps its options. upsertMetadata
+// acquires the job's cluster lock.
+func (j *JobOnce) upsertMetadata(options *JobOnceOptions) (*JobOnceMetadata, error) {
+	j.clusterMutex.Lock()
+	defer j.clusterMutex.Unlock()
+
+	existing, err := readMetadata(j.pluginAPI, j.metadataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read job metadata")
+	}
+	if existing == nil && options == nil {
+		return nil, errors.Errorf("job %s not found", j.key)
+	}
+
+	metadata := JobOnceMetadata{
+		Key:   j.key,
+		RunAt: j.runAt,
+		Props: j.props,
+	}
+	if existing != nil {
+		metadata.Type = existing.Type
+		metadata.Timeout = existing.Timeout
+		metadata.Retry = existing.Retry
+		metadata.Revision = existing.Revision + 1
+	}
+	if options != nil {
+		metadata.Type = options.Type
+		metadata.Timeout = options.Timeout
+		metadata.Retry = options.Retry
+	}
+
+	if err = j.index.add(j.key); err != nil {
+		return nil, err
+	}
+
+	if err = updateMetadata(j.pluginAPI, j.metadataKey, metadata); err != nil {
+		return nil, errors.Wrap(err, "could not save job metadata")
+	}
+
+	return &metadata, nil
+}
+
 // cancelWhileHoldingMutex assumes the caller holds the job's mutex.
 func (j *JobOnce) cancelWhileHoldingMutex() {
 	// remove the job from the kv store, if it exists
-	_ = j.pluginAPI.KVDelete(oncePrefix + j.key)
+	_ = j.pluginAPI.KVDelete(j.metadataKey)
+
+	j.untrackWhileHoldingMutex()
+}
 
+// untrackWhileHoldingMutex stops the job on this plugin instance, leaving it scheduled. It
+// assumes the caller holds the job's mutex.
+func (j *JobOnce) untrackWhileHoldingMutex() {
 	j.activeJobs.mu.Lock()
 	defer j.activeJobs.mu.Unlock()
 	delete(j.activeJobs.jobs, j.key)
@@ -221,6 +628,34 @@ func (j *JobOnce) cancelWhileHoldingMutex() {
 	})
 }
 
+// missingPublisherOnce logs that job changes cannot be published at most once.
+var missingPublisherOnce sync.Once
+
+// publishJobOnceChange notifies other plugin instances of a change to the job with the given key,
+// if the plugin API is able to. Other plugin instances otherwise pick up the change when polling.
+func publishJobOnceChange(pluginAPI JobPluginAPI, namespace, key string) {
+	publisher, ok := pluginAPI.(clusterEventPublisher)
+	if !ok {
+		missingPublisherOnce.Do(func() {
+			pluginAPI.LogError("plugin API cannot publish cluster events, job changes are picked up when polling only")
+		})
+		return
+	}
+
+	data, err := json.Marshal(jobOnceChange{Namespace: namespace, Key: key})
+	if err == nil {
+		err = publisher.PublishPluginClusterEvent(model.PluginClusterEvent{
+			Id:   jobOnceChangeEventID,
+			Data: data,
+		}, model.PluginClusterEventSendOptions{
+			SendType: model.PluginClusterEventSendTypeReliable,
+		})
+	}
+	if err != nil {
+		pluginAPI.LogError("failed to publish job change", "err", err, "key", key)
+	}
+}
+
 func addJitter() time.Duration {
 	return time.Duration(rand.Int63n(int64(scheduleOnceJitter)))
 }
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -43,3 +44,34 @@ func ExampleJobOnceScheduler_ScheduleOnce() {
 		}
 	}()
 }
+
+type ReminderProps struct {
+	UserID string
+}
+
+func ExampleNewJobOnceScheduler() {
+	// Use p.API from your plugin instead.
+	pluginAPI := plugin.API(nil)
+
+	// Create a scheduler whose jobs are separate from those of other schedulers in the plugin.
+	scheduler, err := NewJobOnceScheduler(pluginAPI, "reminders")
+	if err != nil {
+		panic("failed to create scheduler")
+	}
+
+	// Register a handler for each type of job, with props decoded into the expected struct.
+	_ = scheduler.RegisterHandler("reminder", TypedJobOnceHandler(func(ctx context.Context, metadata JobOnceMetadata, props ReminderProps) error {
+		// remind props.UserID
+		return nil
+	}))
+
+	_ = scheduler.Start()
+	defer scheduler.Close()
+
+	// Jobs changed on other plugin instances are picked up immediately once the plugin passes
+	// cluster events from its OnPluginClusterEvent hook to scheduler.HandleClusterEvent.
+
+	_, _ = scheduler.ScheduleOnceWithOptions("reminder-1", time.Now().Add(2*time.Hour), ReminderProps{UserID: "user"}, JobOnceOptions{
+		Type: "reminder",
+	})
+}
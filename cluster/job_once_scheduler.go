@@ -4,10 +4,13 @@
 package cluster
 
 import (
+	"context"
+	"encoding/json"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/pkg/errors"
 )
 
@@ -15,7 +18,8 @@ import (
 // called once at a time (the client does not need to worry about concurrency within the callback)
 type syncedCallback struct {
 	mu       sync.Mutex
-	callback func(string, any)
+	callback func(ctx context.Context, key string, props any) error
+	handlers map[string]func(ctx context.Context, metadata JobOnceMetadata) error
 }
 
 type syncedJobs struct {
@@ -26,8 +30,22 @@ type syncedJobs struct {
 type JobOnceScheduler struct {
 	pluginAPI JobPluginAPI
 
-	startedMu sync.RWMutex
-	started   bool
+	// namespace separates the jobs of this scheduler from those of other schedulers. It is empty
+	// for the scheduler returned by GetJobOnceScheduler.
+	namespace string
+
+	// index lists the keys of the scheduler's jobs. Jobs are added to the index before being
+	// stored, and pruned from the index once found deleted, both while holding the job's mutex,
+	// so that the index lists every stored job. It may also list jobs deleted since last listed.
+	index *keyIndex
+
+	startedMu    sync.RWMutex
+	started      bool
+	pollInterval time.Duration
+
+	// stopPolling and pollingDone manage the goroutine polling for new jobs while started.
+	stopPolling chan struct{}
+	pollingDone chan struct{}
 
 	activeJobs     *syncedJobs
 	storedCallback *syncedCallback
@@ -38,22 +56,82 @@ var s *JobOnceScheduler
 
 // GetJobOnceScheduler returns a scheduler which is ready to have its callback set. Repeated
 // calls will return the same scheduler.
+//
+// Use NewJobOnceScheduler instead to manage jobs independently of other parts of the plugin.
 func GetJobOnceScheduler(pluginAPI JobPluginAPI) *JobOnceScheduler {
 	schedulerOnce.Do(func() {
-		s = &JobOnceScheduler{
-			pluginAPI: pluginAPI,
-			activeJobs: &syncedJobs{
-				jobs: make(map[string]*JobOnce),
-			},
-			storedCallback: &syncedCallback{},
-		}
+		s = newJobOnceScheduler(pluginAPI, "")
 	})
 	return s
 }
 
+// NewJobOnceScheduler creates a scheduler managing its own jobs, unrelated to those of the
+// scheduler returned by GetJobOnceScheduler or with a different namespace. Schedulers with the
+// same namespace on other plugin instances share their jobs.
+//
+// The namespace must not be empty or contain a colon.
+func NewJobOnceScheduler(pluginAPI JobPluginAPI, namespace string) (*JobOnceScheduler, error) {
+	if namespace == "" || strings.Contains(namespace, ":") {
+		return nil, errors.Errorf("invalid scheduler namespace %q", namespace)
+	}
+
+	return newJobOnceScheduler(pluginAPI, namespace), nil
+}
+
+func newJobOnceScheduler(pluginAPI JobPluginAPI, namespace string) *JobOnceScheduler {
+	indexPrefix := onceIndexPrefix
+	if namespace != "" {
+		indexPrefix = onceIndexNamespacePrefix + namespace + ":"
+	}
+
+	return &JobOnceScheduler{
+		pluginAPI: pluginAPI,
+		namespace: namespace,
+		index: &keyIndex{
+			pluginAPI: pluginAPI,
+			prefix:    indexPrefix,
+			shards:    onceIndexShards,
+		},
+		pollInterval: pollNewJobsInterval,
+		activeJobs: &syncedJobs{
+			jobs: make(map[string]*JobOnce),
+		},
+		storedCallback: &syncedCallback{
+			handlers: make(map[string]func(ctx context.Context, metadata JobOnceMetadata) error),
+		},
+	}
+}
+
+// keyPrefix returns the prefix of the key values holding the scheduler's jobs.
+func (s *JobOnceScheduler) keyPrefix() string {
+	if s.namespace == "" {
+		return oncePrefix
+	}
+
+	return onceNamespacePrefix + s.namespace + ":"
+}
+
+// metadataKey returns the key value holding the metadata of the job with the given key.
+func (s *JobOnceScheduler) metadataKey(key string) string {
+	return s.keyPrefix() + key
+}
+
+// mutexKey returns the name of the mutex guarding the job with the given key.
+func (s *JobOnceScheduler) mutexKey(key string) string {
+	if s.namespace == "" {
+		return key
+	}
+
+	return s.metadataKey(key)
+}
+
 // Start starts the Scheduler. It finds all previous ScheduleOnce jobs and starts them running, and
 // fires any jobs that have reached or exceeded their runAt time. Thus, even if a cluster goes down
 // and is restarted, Start will restart previously scheduled jobs.
+//
+// Jobs scheduled, rescheduled or canceled on other plugin instances are picked up as soon as
+// notified through HandleClusterEvent, and otherwise when polling for jobs. Jobs are polled from
+// an index, which Start completes with the jobs scheduled by previous versions of this package.
 func (s *JobOnceScheduler) Start() error {
 	s.startedMu.Lock()
 	defer s.startedMu.Unlock()
@@ -65,17 +143,119 @@ func (s *JobOnceScheduler) Start() error {
 		return errors.Wrap(err, "callback not found; cannot start scheduler")
 	}
 
+	if err := s.indexExistingJobs(); err != nil {
+		return errors.Wrap(err, "could not start JobOnceScheduler due to error")
+	}
+
 	if err := s.scheduleNewJobsFromDB(); err != nil {
 		return errors.Wrap(err, "could not start JobOnceScheduler due to error")
 	}
 
-	go s.pollForNewScheduledJobs()
+	s.stopPolling = make(chan struct{})
+	s.pollingDone = make(chan struct{})
+	go s.pollForNewScheduledJobs(s.pollInterval, s.stopPolling, s.pollingDone)
 
 	s.started = true
 
 	return nil
 }
 
+// Close stops the scheduler on this plugin instance. Scheduled jobs are not canceled, and run
+// once the scheduler is started again on any plugin instance. The context of running callbacks is
+// canceled, and Close waits for them to return. Jobs interrupted this way run again unless their
+// callback returned no error.
+func (s *JobOnceScheduler) Close() error {
+	return s.CloseWithContext(context.Background())
+}
+
+// CloseWithContext stops the scheduler like Close, but gives up waiting for running callbacks to
+// return once the given context is done, returning the context's error.
+func (s *JobOnceScheduler) CloseWithContext(ctx context.Context) error {
+	s.startedMu.Lock()
+	if !s.started {
+		s.startedMu.Unlock()
+		return nil
+	}
+	s.started = false
+	close(s.stopPolling)
+	pollingDone := s.pollingDone
+	s.startedMu.Unlock()
+
+	// Wait for polling to stop before stopping jobs, as it may start new ones.
+	select {
+	case <-pollingDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	s.activeJobs.mu.Lock()
+	jobs := make([]*JobOnce, 0, len(s.activeJobs.jobs))
+	for _, job := range s.activeJobs.jobs {
+		jobs = append(jobs, job)
+	}
+	s.activeJobs.jobs = make(map[string]*JobOnce)
+	s.activeJobs.mu.Unlock()
+
+	for _, job := range jobs {
+		job.stop()
+	}
+
+	for _, job := range jobs {
+		select {
+		case <-job.join:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// SetPollInterval sets how often the scheduler polls for jobs changed on other plugin instances
+// without being notified through HandleClusterEvent. Defaults to five minutes. It takes effect
+// when the scheduler is next started.
+func (s *JobOnceScheduler) SetPollInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return errors.New("poll interval must be positive")
+	}
+
+	s.startedMu.Lock()
+	defer s.startedMu.Unlock()
+
+	s.pollInterval = interval
+	return nil
+}
+
+// HandleClusterEvent picks up jobs scheduled, rescheduled or canceled on other plugin instances.
+// Call it from the plugin's OnPluginClusterEvent hook. It returns false if the event is unrelated
+// to this scheduler, and can be safely called for every scheduler the plugin uses.
+//
+// Changes are only published if the plugin API given to the scheduler implements
+// PublishPluginClusterEvent, as plugin.API does. Otherwise, an error is logged once, and changes
+// are only picked up when polling.
+func (s *JobOnceScheduler) HandleClusterEvent(ev model.PluginClusterEvent) bool {
+	if ev.Id != jobOnceChangeEventID {
+		return false
+	}
+
+	var change jobOnceChange
+	if err := json.Unmarshal(ev.Data, &change); err != nil {
+		s.pluginAPI.LogError("failed to unmarshal job change", "err", err)
+		return true
+	}
+	if change.Namespace != s.namespace {
+		return false
+	}
+
+	s.startedMu.RLock()
+	defer s.startedMu.RUnlock()
+	if s.started {
+		s.refreshJob(change.Key)
+	}
+
+	return true
+}
+
 // SetCallback sets the scheduler's callback. When a job fires, the callback will be called with
 // the job's id.
 func (s *JobOnceScheduler) SetCallback(callback func(string, any)) error {
@@ -83,6 +263,21 @@ func (s *JobOnceScheduler) SetCallback(callback func(string, any)) error {
 		return errors.New("callback cannot be nil")
 	}
 
+	return s.SetCallbackWithContext(func(ctx context.Context, key string, props any) error {
+		callback(key, props)
+		return nil
+	})
+}
+
+// SetCallbackWithContext sets the scheduler's callback, like SetCallback. The context passed to
+// the callback is canceled when the job is canceled, when it exceeds its timeout, when the
+// scheduler is closed, or as soon as the job mutex is lost. Jobs whose callback returns an error
+// are retried according to their retry policy, and fail once no attempts are left.
+func (s *JobOnceScheduler) SetCallbackWithContext(callback func(ctx context.Context, key string, props any) error) error {
+	if callback == nil {
+		return errors.New("callback cannot be nil")
+	}
+
 	s.storedCallback.mu.Lock()
 	defer s.storedCallback.mu.Unlock()
 
@@ -90,61 +285,230 @@ func (s *JobOnceScheduler) SetCallback(callback func(string, any)) error {
 	return nil
 }
 
+// RegisterHandler registers the handler called for jobs of the given type, as scheduled with
+// ScheduleOnceWithOptions. The handler is called with the job's metadata as stored, so its
+// props are decoded from JSON: use JobOnceMetadata.DecodeProps or TypedJobOnceHandler to decode
+// them into the type they were scheduled with.
+//
+// Jobs are run once at a time, whether by a handler or the callback. Jobs whose type has no
+// registered handler are left scheduled for a plugin instance able to handle them.
+func (s *JobOnceScheduler) RegisterHandler(jobType string, handler func(ctx context.Context, metadata JobOnceMetadata) error) error {
+	if jobType == "" {
+		return errors.New("job type cannot be empty")
+	}
+	if handler == nil {
+		return errors.New("handler cannot be nil")
+	}
+
+	s.storedCallback.mu.Lock()
+	defer s.storedCallback.mu.Unlock()
+
+	s.storedCallback.handlers[jobType] = handler
+	return nil
+}
+
 // ListScheduledJobs returns a list of the jobs in the db that have been scheduled. There is no
 // guarantee that list is accurate by the time the caller reads the list. E.g., the jobs in the list
 // may have been run, canceled, or new jobs may have scheduled.
+//
+// Failed jobs are not included, see ListFailedJobs.
 func (s *JobOnceScheduler) ListScheduledJobs() ([]JobOnceMetadata, error) {
+	return s.listJobs(func(metadata JobOnceMetadata) bool {
+		return !metadata.Failed
+	})
+}
+
+// ListFailedJobs returns a list of the jobs in the db whose callback returned an error once no
+// attempts were left, along with the last error. Failed jobs are kept until canceled or requeued.
+func (s *JobOnceScheduler) ListFailedJobs() ([]JobOnceMetadata, error) {
+	return s.listJobs(func(metadata JobOnceMetadata) bool {
+		return metadata.Failed
+	})
+}
+
+// listJobs returns the jobs in the db for which include returns true, pruning the jobs deleted
+// since from the index.
+func (s *JobOnceScheduler) listJobs(include func(metadata JobOnceMetadata) bool) ([]JobOnceMetadata, error) {
+	keys, err := s.index.keys()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list jobs")
+	}
+
 	var ret []JobOnceMetadata
-	for i := 0; ; i++ {
-		keys, err := s.pluginAPI.KVList(i, keysPerPage)
+	for _, key := range keys {
+		metadata, err := readMetadata(s.pluginAPI, s.metadataKey(key))
 		if err != nil {
-			return nil, errors.Wrap(err, "error getting KVList")
+			s.pluginAPI.LogError(errors.Wrap(err, "could not retrieve data from plugin kvstore for key: "+key).Error())
+			continue
 		}
-		for _, k := range keys {
-			if strings.HasPrefix(k, oncePrefix) {
-				metadata, err := readMetadata(s.pluginAPI, k[len(oncePrefix):])
-				if err != nil {
-					s.pluginAPI.LogError(errors.Wrap(err, "could not retrieve data from plugin kvstore for key: "+k).Error())
-					continue
-				}
-				if metadata == nil {
-					continue
-				}
-
-				ret = append(ret, *metadata)
-			}
+		if metadata == nil {
+			s.pruneIndex(key)
+			continue
 		}
-
-		if len(keys) < keysPerPage {
-			break
+		if include(*metadata) {
+			ret = append(ret, *metadata)
 		}
 	}
 
 	return ret, nil
 }
 
+// pruneIndex removes the job with the given key from the index if it no longer exists. Deleted
+// jobs are pruned when listing rather than when deleted, to avoid contention on the index when
+// many jobs complete at once. The job's mutex is held for a job being scheduled not to be pruned,
+// and pruning is left to the next listing if the job is busy.
+func (s *JobOnceScheduler) pruneIndex(key string) {
+	mutex, err := NewMutex(s.pluginAPI, s.mutexKey(key))
+	if err != nil {
+		s.pluginAPI.LogError("failed to create job mutex", "err", err, "key", key)
+		return
+	}
+	if !mutex.TryLock() {
+		return
+	}
+	defer mutex.Unlock()
+
+	metadata, err := readMetadata(s.pluginAPI, s.metadataKey(key))
+	if err != nil || metadata != nil {
+		return
+	}
+
+	if err = s.index.remove(key); err != nil {
+		s.pluginAPI.LogError("failed to remove job from index", "err", err, "key", key)
+	}
+}
+
+// indexExistingJobs adds the jobs found by paging through every key to the index, so that jobs
+// scheduled before the index existed are listed.
+func (s *JobOnceScheduler) indexExistingJobs() error {
+	var keys []string
+	err := forEachKey(s.pluginAPI, s.keyPrefix(), func(k string) bool {
+		keys = append(keys, strings.TrimPrefix(k, s.keyPrefix()))
+		return true
+	})
+	if err != nil {
+		return errors.Wrap(err, "could not read scheduled jobs from db")
+	}
+
+	indexed, err := s.index.keys()
+	if err != nil {
+		return errors.Wrap(err, "could not read job index")
+	}
+	isIndexed := make(map[string]bool, len(indexed))
+	for _, key := range indexed {
+		isIndexed[key] = true
+	}
+
+	for _, key := range keys {
+		if isIndexed[key] {
+			continue
+		}
+
+		if err = s.index.add(key); err != nil {
+			return errors.Wrap(err, "could not index job for key: "+key)
+		}
+	}
+
+	return nil
+}
+
 // ScheduleOnce creates a scheduled job that will run once. When the clock reaches runAt, the
 // callback will be called with key and props as the argument.
 //
-// If the job key already exists in the db, this will return an error. To reschedule a job, first
-// cancel the original then schedule it again.
+// If the job key already exists in the db, this will return an error. To change the time or props
+// of a job, use Reschedule or Upsert.
+//
+// A job whose time was changed in the db, such as by another plugin instance cancelling it and
+// scheduling it again, runs at the new time with the new props. Earlier versions instead treated
+// such a job as completed once its original time came, deleting it without running it.
 func (s *JobOnceScheduler) ScheduleOnce(key string, runAt time.Time, props any) (*JobOnce, error) {
+	return s.ScheduleOnceWithOptions(key, runAt, props, JobOnceOptions{})
+}
+
+// ScheduleOnceWithOptions creates a scheduled job that will run once, like ScheduleOnce, with the
+// given options. Jobs with a type are passed to the handler registered for that type instead of
+// the callback.
+func (s *JobOnceScheduler) ScheduleOnceWithOptions(key string, runAt time.Time, props any, options JobOnceOptions) (*JobOnce, error) {
 	s.startedMu.RLock()
 	defer s.startedMu.RUnlock()
 	if !s.started {
 		return nil, errors.New("start the scheduler before adding jobs")
 	}
 
-	job, err := newJobOnce(s.pluginAPI, key, runAt, s.storedCallback, s.activeJobs, props)
+	job, err := s.newJobOnce(key, runAt, props)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create new job")
 	}
+	job.jobType = options.Type
+	job.timeout = options.Timeout
+	job.retry = options.Retry
 
 	if err = job.saveMetadata(); err != nil {
 		return nil, errors.Wrap(err, "could not save job metadata")
 	}
 
 	s.runAndTrack(job)
+	publishJobOnceChange(s.pluginAPI, s.namespace, key)
+
+	return job, nil
+}
+
+// Reschedule changes the time and props of the job with the given key, keeping its options. The
+// change is atomic: the job is never missing, nor run twice. Rescheduling a job while it runs
+// waits for it to complete, and then fails as the job no longer exists. Rescheduling a failed job,
+// or a job being retried, resets its attempts.
+func (s *JobOnceScheduler) Reschedule(key string, runAt time.Time, props any) (*JobOnce, error) {
+	return s.upsert(key, runAt, props, nil)
+}
+
+// Upsert schedules a job like ScheduleOnce, or reschedules it atomically if the job key already
+// exists in the db.
+func (s *JobOnceScheduler) Upsert(key string, runAt time.Time, props any) (*JobOnce, error) {
+	return s.UpsertWithOptions(key, runAt, props, JobOnceOptions{})
+}
+
+// UpsertWithOptions schedules a job like ScheduleOnceWithOptions, or reschedules it atomically
+// with the given options if the job key already exists in the db.
+func (s *JobOnceScheduler) UpsertWithOptions(key string, runAt time.Time, props any, options JobOnceOptions) (*JobOnce, error) {
+	return s.upsert(key, runAt, props, &options)
+}
+
+// upsert stores the job with the given key while holding its mutex, then tracks it on this
+// plugin instance. Without options, the job must exist and keeps its options.
+func (s *JobOnceScheduler) upsert(key string, runAt time.Time, props any, options *JobOnceOptions) (*JobOnce, error) {
+	s.startedMu.RLock()
+	defer s.startedMu.RUnlock()
+	if !s.started {
+		return nil, errors.New("start the scheduler before adding jobs")
+	}
+
+	job, err := s.newJobOnce(key, runAt, props)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create new job")
+	}
+
+	metadata, err := job.upsertMetadata(options)
+	if err != nil {
+		return nil, err
+	}
+	job.jobType = metadata.Type
+	job.timeout = metadata.Timeout
+	job.retry = metadata.Retry
+	job.revision = metadata.Revision
+
+	defer publishJobOnceChange(s.pluginAPI, s.namespace, key)
+
+	s.activeJobs.mu.Lock()
+	defer s.activeJobs.mu.Unlock()
+
+	// Wake the job if already tracked, so it doesn't wait for its previous time.
+	if active, ok := s.activeJobs.jobs[key]; ok {
+		active.reschedule(runAt, props, metadata.Revision)
+		return active, nil
+	}
+
+	go job.run()
+	s.activeJobs.jobs[key] = job
 
 	return job, nil
 }
@@ -164,21 +528,83 @@ func (s *JobOnceScheduler) Cancel(key string) {
 		// Job wasn't active, so no need to call CancelWhileHoldingMutex (which shuts down the
 		// goroutine). There's a condition where another server in the cluster started the job, and
 		// the current server hasn't polled for it yet. To solve that case, delete it from the db.
-		mutex, err := NewMutex(s.pluginAPI, key)
+		mutex, err := NewMutex(s.pluginAPI, s.mutexKey(key))
 		if err != nil {
 			s.pluginAPI.LogError(errors.Wrap(err, "failed to create job mutex in Cancel for key: "+key).Error())
 		}
 		mutex.Lock()
 		defer mutex.Unlock()
 
-		_ = s.pluginAPI.KVDelete(oncePrefix + key)
+		_ = s.pluginAPI.KVDelete(s.metadataKey(key))
 
 		return nil
 	}()
 
 	if job != nil {
 		job.Cancel()
+		return
+	}
+
+	publishJobOnceChange(s.pluginAPI, s.namespace, key)
+}
+
+// Requeue schedules a failed job to run again as soon as possible, with its attempts reset.
+func (s *JobOnceScheduler) Requeue(key string) error {
+	s.startedMu.RLock()
+	defer s.startedMu.RUnlock()
+	if !s.started {
+		return errors.New("start the scheduler before requeuing jobs")
+	}
+
+	metadata, err := s.resetFailedJob(key)
+	if err != nil {
+		return err
 	}
+
+	job, err := s.newJobOnce(key, metadata.RunAt, metadata.Props)
+	if err != nil {
+		return errors.Wrap(err, "could not create new job")
+	}
+	job.jobType = metadata.Type
+	job.timeout = metadata.Timeout
+	job.retry = metadata.Retry
+	job.revision = metadata.Revision
+
+	s.runAndTrack(job)
+	publishJobOnceChange(s.pluginAPI, s.namespace, key)
+
+	return nil
+}
+
+// resetFailedJob clears the failure of the job with the given key, returning its metadata.
+func (s *JobOnceScheduler) resetFailedJob(key string) (*JobOnceMetadata, error) {
+	mutex, err := NewMutex(s.pluginAPI, s.mutexKey(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create job mutex")
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	metadata, err := readMetadata(s.pluginAPI, s.metadataKey(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read job metadata")
+	}
+	if metadata == nil {
+		return nil, errors.Errorf("job %s not found", key)
+	}
+	if !metadata.Failed {
+		return nil, errors.Errorf("job %s has not failed", key)
+	}
+
+	metadata.Failed = false
+	metadata.Attempts = 0
+	metadata.LastError = ""
+	metadata.NextAttemptAt = time.Time{}
+	if err = updateMetadata(s.pluginAPI, s.metadataKey(key), *metadata); err != nil {
+		return nil, errors.Wrap(err, "could not save job metadata")
+	}
+
+	return metadata, nil
 }
 
 func (s *JobOnceScheduler) scheduleNewJobsFromDB() error {
@@ -188,18 +614,74 @@ func (s *JobOnceScheduler) scheduleNewJobsFromDB() error {
 	}
 
 	for _, m := range scheduled {
-		job, err := newJobOnce(s.pluginAPI, m.Key, m.RunAt, s.storedCallback, s.activeJobs, m.Props)
-		if err != nil {
-			s.pluginAPI.LogError(errors.Wrap(err, "could not create new job for key: "+m.Key).Error())
-			continue
-		}
-
-		s.runAndTrack(job)
+		s.track(m)
 	}
 
 	return nil
 }
 
+// refreshJob picks up a change to the job with the given key made on another plugin instance.
+func (s *JobOnceScheduler) refreshJob(key string) {
+	metadata, err := readMetadata(s.pluginAPI, s.metadataKey(key))
+	if err != nil {
+		s.pluginAPI.LogError("failed to read changed job", "err", err, "key", key)
+		return
+	}
+
+	if metadata != nil && !metadata.Failed {
+		s.track(*metadata)
+		return
+	}
+
+	// Wake the job, if tracked, to stop it.
+	s.activeJobs.mu.RLock()
+	job, ok := s.activeJobs.jobs[key]
+	s.activeJobs.mu.RUnlock()
+	if ok {
+		job.wakeUp()
+	}
+}
+
+// track runs the job with the given metadata on this plugin instance, unless already tracked.
+func (s *JobOnceScheduler) track(m JobOnceMetadata) {
+	if s.wakeIfRescheduled(m) {
+		return
+	}
+
+	job, err := s.newJobOnce(m.Key, m.RunAt, m.Props)
+	if err != nil {
+		s.pluginAPI.LogError(errors.Wrap(err, "could not create new job for key: "+m.Key).Error())
+		return
+	}
+	job.jobType = m.Type
+	job.timeout = m.Timeout
+	job.retry = m.Retry
+	job.revision = m.Revision
+
+	s.runAndTrack(job)
+}
+
+// wakeIfRescheduled wakes the job tracked with the given metadata's key if it was rescheduled on
+// another plugin instance since, returning whether the job is tracked on this plugin instance.
+func (s *JobOnceScheduler) wakeIfRescheduled(metadata JobOnceMetadata) bool {
+	s.activeJobs.mu.RLock()
+	job, ok := s.activeJobs.jobs[metadata.Key]
+	s.activeJobs.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	job.mu.Lock()
+	rescheduled := job.revision != metadata.Revision || !job.runAt.Equal(metadata.RunAt)
+	job.mu.Unlock()
+
+	if rescheduled {
+		job.reschedule(metadata.RunAt, metadata.Props, metadata.Revision)
+	}
+
+	return true
+}
+
 func (s *JobOnceScheduler) runAndTrack(job *JobOnce) {
 	s.activeJobs.mu.Lock()
 	defer s.activeJobs.mu.Unlock()
@@ -214,10 +696,17 @@ func (s *JobOnceScheduler) runAndTrack(job *JobOnce) {
 	s.activeJobs.jobs[job.key] = job
 }
 
-// pollForNewScheduledJobs will only be started once per plugin. It doesn't need to be stopped.
-func (s *JobOnceScheduler) pollForNewScheduledJobs() {
+// pollForNewScheduledJobs polls for new scheduled jobs on the given interval until stopped by
+// Close.
+func (s *JobOnceScheduler) pollForNewScheduledJobs(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
 	for {
-		<-time.After(pollNewJobsInterval + addJitter())
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval + addJitter()):
+		}
 
 		if err := s.scheduleNewJobsFromDB(); err != nil {
 			s.pluginAPI.LogError("pluginAPI scheduleOnce poller encountered an error but is still polling", "error", err)
@@ -229,8 +718,8 @@ func (s *JobOnceScheduler) verifyCallbackExists() error {
 	s.storedCallback.mu.Lock()
 	defer s.storedCallback.mu.Unlock()
 
-	if s.storedCallback.callback == nil {
-		return errors.New("set callback before starting the scheduler")
+	if s.storedCallback.callback == nil && len(s.storedCallback.handlers) == 0 {
+		return errors.New("set callback or register a handler before starting the scheduler")
 	}
 	return nil
 }
@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"encoding/json"
 	"sync"
 	"sync/atomic"
@@ -13,6 +14,16 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// isTracked reports whether the scheduler tracks an active job with the given key. Only the
+// presence of the job is checked, as its fields are used by the goroutine running it.
+func isTracked(s *JobOnceScheduler, key string) bool {
+	s.activeJobs.mu.RLock()
+	defer s.activeJobs.mu.RUnlock()
+
+	_, ok := s.activeJobs.jobs[key]
+	return ok
+}
+
 func TestScheduleOnceParallel(t *testing.T) {
 	makeKey := model.NewId
 
@@ -86,9 +97,7 @@ func TestScheduleOnceParallel(t *testing.T) {
 		time.Sleep(200*time.Millisecond + scheduleOnceJitter)
 
 		assert.Empty(t, getVal(oncePrefix+jobKey1))
-		s.activeJobs.mu.RLock()
-		assert.Empty(t, s.activeJobs.jobs[jobKey1])
-		s.activeJobs.mu.RUnlock()
+		assert.False(t, isTracked(s, jobKey1))
 
 		// It's okay to cancel jobs extra times, even if they're completed.
 		job.Cancel()
@@ -110,9 +119,7 @@ func TestScheduleOnceParallel(t *testing.T) {
 
 		job.Cancel()
 		assert.Empty(t, getVal(oncePrefix+jobKey2))
-		s.activeJobs.mu.RLock()
-		assert.Empty(t, s.activeJobs.jobs[jobKey2])
-		s.activeJobs.mu.RUnlock()
+		assert.False(t, isTracked(s, jobKey2))
 
 		time.Sleep(2 * (waitAfterFail + scheduleOnceJitter))
 
@@ -136,9 +143,7 @@ func TestScheduleOnceParallel(t *testing.T) {
 
 		time.Sleep(200*time.Millisecond + scheduleOnceJitter)
 		assert.Empty(t, getVal(oncePrefix+jobKey3))
-		s.activeJobs.mu.RLock()
-		assert.Empty(t, s.activeJobs.jobs[jobKey3])
-		s.activeJobs.mu.RUnlock()
+		assert.False(t, isTracked(s, jobKey3))
 	})
 
 	t.Run("cancel and restart a job with the same key", func(t *testing.T) {
@@ -151,9 +156,7 @@ func TestScheduleOnceParallel(t *testing.T) {
 
 		job.Cancel()
 		assert.Empty(t, getVal(oncePrefix+jobKey4))
-		s.activeJobs.mu.RLock()
-		assert.Empty(t, s.activeJobs.jobs[jobKey4])
-		s.activeJobs.mu.RUnlock()
+		assert.False(t, isTracked(s, jobKey4))
 
 		job, err2 = s.ScheduleOnce(jobKey4, time.Now().Add(100*time.Millisecond), nil)
 		require.NoError(t, err2)
@@ -163,9 +166,7 @@ func TestScheduleOnceParallel(t *testing.T) {
 		time.Sleep(200*time.Millisecond + scheduleOnceJitter)
 		assert.Equal(t, int32(1), atomic.LoadInt32(count4))
 		assert.Empty(t, getVal(oncePrefix+jobKey4))
-		s.activeJobs.mu.RLock()
-		assert.Empty(t, s.activeJobs.jobs[jobKey4])
-		s.activeJobs.mu.RUnlock()
+		assert.False(t, isTracked(s, jobKey4))
 	})
 
 	t.Run("many scheduled jobs", func(t *testing.T) {
@@ -182,9 +183,7 @@ func TestScheduleOnceParallel(t *testing.T) {
 
 		for k, v := range manyJobs {
 			assert.Empty(t, getVal(oncePrefix+k))
-			s.activeJobs.mu.RLock()
-			assert.Empty(t, s.activeJobs.jobs[k])
-			s.activeJobs.mu.RUnlock()
+			assert.False(t, isTracked(s, k))
 			assert.Equal(t, int32(1), *v)
 		}
 	})
@@ -196,16 +195,12 @@ func TestScheduleOnceParallel(t *testing.T) {
 		require.NoError(t, err2)
 		require.NotNil(t, job)
 		assert.NotEmpty(t, getVal(oncePrefix+jobKey5))
-		s.activeJobs.mu.RLock()
-		assert.NotEmpty(t, s.activeJobs.jobs[jobKey5])
-		s.activeJobs.mu.RUnlock()
+		assert.True(t, isTracked(s, jobKey5))
 
 		s.Cancel(jobKey5)
 
 		assert.Empty(t, getVal(oncePrefix+jobKey5))
-		s.activeJobs.mu.RLock()
-		assert.Empty(t, s.activeJobs.jobs[jobKey5])
-		s.activeJobs.mu.RUnlock()
+		assert.False(t, isTracked(s, jobKey5))
 
 		// cancel it again doesn't do anything:
 		s.Cancel(jobKey5)
@@ -325,7 +320,7 @@ func TestScheduleOnceSequential(t *testing.T) {
 		// add the test paging jobs before starting scheduler
 		for k := range testPagingJobs {
 			assert.Empty(t, getVal(oncePrefix+k))
-			job, err := newJobOnce(s.pluginAPI, k, time.Now().Add(100*time.Millisecond), s.storedCallback, s.activeJobs, nil)
+			job, err := s.newJobOnce(k, time.Now().Add(100*time.Millisecond), nil)
 			require.NoError(t, err)
 			err = job.saveMetadata()
 			require.NoError(t, err)
@@ -344,24 +339,25 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NoError(t, err)
 
 		// wait for the testPagingJobs created in the setup to finish
-		time.Sleep(300 * time.Millisecond)
-
-		numInDB := 0
-		numActive := 0
-		numCountsAtZero := 0
-		for k, v := range testPagingJobs {
-			if getVal(oncePrefix+k) != nil {
-				numInDB++
+		var numInDB, numActive, numCountsAtZero int
+		assert.Eventually(t, func() bool {
+			numInDB, numActive, numCountsAtZero = 0, 0, 0
+			for k, v := range testPagingJobs {
+				if getVal(oncePrefix+k) != nil {
+					numInDB++
+				}
+				s.activeJobs.mu.RLock()
+				if s.activeJobs.jobs[k] != nil {
+					numActive++
+				}
+				s.activeJobs.mu.RUnlock()
+				if atomic.LoadInt32(v) == int32(0) {
+					numCountsAtZero++
+				}
 			}
-			s.activeJobs.mu.RLock()
-			if s.activeJobs.jobs[k] != nil {
-				numActive++
-			}
-			s.activeJobs.mu.RUnlock()
-			if atomic.LoadInt32(v) == int32(0) {
-				numCountsAtZero++
-			}
-		}
+
+			return numInDB == 0 && numActive == 0 && numCountsAtZero == 0
+		}, 10*time.Second, 50*time.Millisecond)
 
 		assert.Equal(t, 0, numInDB)
 		assert.Equal(t, 0, numActive)
@@ -393,7 +389,7 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, job)
 		assert.NotEmpty(t, getVal(oncePrefix+jobKey1))
-		assert.NotEmpty(t, s.activeJobs.jobs[jobKey1])
+		assert.True(t, isTracked(s, jobKey1))
 		s.pluginAPI.(*mockPluginAPI).setFailingWithPrefix(oncePrefix)
 
 		// wait until the metadata has failed to read
@@ -401,7 +397,7 @@ func TestScheduleOnceSequential(t *testing.T) {
 		assert.Equal(t, int32(0), atomic.LoadInt32(count1))
 		assert.Nil(t, getVal(oncePrefix+jobKey1))
 
-		assert.Empty(t, s.activeJobs.jobs[jobKey1])
+		assert.False(t, isTracked(s, jobKey1))
 		assert.Empty(t, getVal(oncePrefix+jobKey1))
 		assert.Equal(t, int32(0), atomic.LoadInt32(count1))
 
@@ -428,7 +424,7 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NoError(t, err)
 
 		for k := range jobKeys {
-			job, err3 := newJobOnce(s.pluginAPI, k, time.Now().Add(100*time.Millisecond), s.storedCallback, s.activeJobs, nil)
+			job, err3 := s.newJobOnce(k, time.Now().Add(100*time.Millisecond), nil)
 			require.NoError(t, err3)
 			err3 = job.saveMetadata()
 			require.NoError(t, err3)
@@ -449,7 +445,7 @@ func TestScheduleOnceSequential(t *testing.T) {
 
 		for k, v := range jobKeys {
 			assert.Empty(t, getVal(oncePrefix+k))
-			assert.Empty(t, s.activeJobs.jobs[k])
+			assert.False(t, isTracked(s, k))
 			assert.Equal(t, int32(1), *v)
 		}
 		jobs, err = s.ListScheduledJobs()
@@ -483,7 +479,8 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NotNil(t, job)
 		assert.NotEmpty(t, getVal(oncePrefix+jobKey))
 		s.activeJobs.mu.Lock()
-		assert.NotEmpty(t, s.activeJobs.jobs[jobKey])
+		_, ok := s.activeJobs.jobs[jobKey]
+		assert.True(t, ok)
 		assert.Len(t, s.activeJobs.jobs, 1)
 		s.activeJobs.mu.Unlock()
 
@@ -496,7 +493,8 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NoError(t, err)
 		assert.NotEmpty(t, getVal(oncePrefix+jobKey))
 		s.activeJobs.mu.Lock()
-		assert.NotEmpty(t, s.activeJobs.jobs[jobKey])
+		_, ok = s.activeJobs.jobs[jobKey]
+		assert.True(t, ok)
 		assert.Len(t, s.activeJobs.jobs, 1)
 		s.activeJobs.mu.Unlock()
 
@@ -534,8 +532,10 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, job)
 		assert.NotEmpty(t, getVal(oncePrefix+jobKey))
-		assert.NotEmpty(t, s.activeJobs.jobs[jobKey])
+		assert.True(t, isTracked(s, jobKey))
+		s.activeJobs.mu.RLock()
 		assert.Len(t, s.activeJobs.jobs, 1)
+		s.activeJobs.mu.RUnlock()
 
 		// a plugin tries to start the same jobKey again:
 		job, err = s.ScheduleOnce(jobKey, time.Now().Add(10000*time.Millisecond), nil)
@@ -546,10 +546,12 @@ func TestScheduleOnceSequential(t *testing.T) {
 		time.Sleep(120*time.Millisecond + scheduleOnceJitter)
 		assert.Equal(t, int32(1), atomic.LoadInt32(count))
 		assert.Empty(t, getVal(oncePrefix+jobKey))
+		s.activeJobs.mu.RLock()
 		assert.Empty(t, s.activeJobs.jobs)
+		s.activeJobs.mu.RUnlock()
 	})
 
-	t.Run("simulate HA: canceling and setting a job with a different time--old one shouldn't fire", func(t *testing.T) {
+	t.Run("simulate HA: rescheduling a job with a different time--old one shouldn't fire", func(t *testing.T) {
 		resetScheduler()
 
 		key := makeKey()
@@ -573,17 +575,17 @@ func TestScheduleOnceSequential(t *testing.T) {
 		require.NoError(t, err)
 
 		originalRunAt := time.Now().Add(100 * time.Millisecond)
-		newRunAt := time.Now().Add(101 * time.Millisecond)
+		newRunAt := time.Now().Add(time.Hour)
 
 		// store original
-		job, err := newJobOnce(s.pluginAPI, key, originalRunAt, s.storedCallback, s.activeJobs, nil)
+		job, err := s.newJobOnce(key, originalRunAt, nil)
 		require.NoError(t, err)
 		err = job.saveMetadata()
 		require.NoError(t, err)
 		assert.NotEmpty(t, getVal(oncePrefix+key))
 
 		// store oringal control
-		job2, err := newJobOnce(s.pluginAPI, control, originalRunAt, s.storedCallback, s.activeJobs, nil)
+		job2, err := s.newJobOnce(control, originalRunAt, nil)
 		require.NoError(t, err)
 		err = job2.saveMetadata()
 		require.NoError(t, err)
@@ -601,10 +603,10 @@ func TestScheduleOnceSequential(t *testing.T) {
 		err = s.scheduleNewJobsFromDB()
 		require.NoError(t, err)
 
-		// Now "cancel" the original and make a new job with the same key but a different time.
-		// However, because we have only one list of synced jobs, we can't make two jobs with the
-		// same key. So we'll simulate this by changing the job metadata in the db. When the original
-		// job fires, it should see that the runAt is different, and it will think it has been canceled.
+		// Now reschedule the original on another plugin instance, with a different time. However,
+		// because we have only one list of synced jobs, we can't make two jobs with the same key.
+		// So we'll simulate this by changing the job metadata in the db. When the original job
+		// fires, it should see that the runAt is different, and wait for the new time.
 		err = setMetadata(key, JobOnceMetadata{
 			Key:   key,
 			RunAt: newRunAt,
@@ -620,19 +622,173 @@ func TestScheduleOnceSequential(t *testing.T) {
 
 		time.Sleep(120*time.Millisecond + scheduleOnceJitter)
 
-		// original job didn't fire the callback:
-		assert.Empty(t, getVal(oncePrefix+key))
-		assert.Empty(t, s.activeJobs.jobs[key])
-		assert.Equal(t, int32(0), *jobKeys[key])
+		// original job didn't fire the callback, and is waiting for the new time:
+		assert.NotEmpty(t, getVal(oncePrefix+key))
+		assert.True(t, isTracked(s, key))
+		assert.Equal(t, int32(0), atomic.LoadInt32(jobKeys[key]))
 
 		// control job did fire the callback:
 		assert.Empty(t, getVal(oncePrefix+control))
-		assert.Empty(t, s.activeJobs.jobs[control])
-		assert.Equal(t, int32(1), *jobKeys[control])
+		assert.False(t, isTracked(s, control))
+		assert.Equal(t, int32(1), atomic.LoadInt32(jobKeys[control]))
 
 		jobs, err = s.ListScheduledJobs()
 		require.NoError(t, err)
-		require.Empty(t, jobs)
+		require.Len(t, jobs, 1)
+		require.True(t, newRunAt.Equal(jobs[0].RunAt))
+
+		s.Cancel(key)
+	})
+
+	t.Run("canceling a running job cancels its context", func(t *testing.T) {
+		resetScheduler()
+
+		jobKey := makeKey()
+		started := make(chan struct{})
+		callback := func(ctx context.Context, key string, _ any) error {
+			if key == jobKey {
+				close(started)
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}
+
+		require.NoError(t, s.SetCallbackWithContext(callback))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		job, err := s.ScheduleOnce(jobKey, time.Now().Add(100*time.Millisecond), nil)
+		require.NoError(t, err)
+
+		requireSignal(t, started, time.Second, "job should have started")
+
+		canceled := make(chan struct{})
+		go func() {
+			defer close(canceled)
+			job.Cancel()
+		}()
+		requireSignal(t, canceled, pollWaitInterval*2, "job should have been canceled")
+		assert.Empty(t, getVal(oncePrefix+jobKey))
+	})
+
+	t.Run("a job exceeding its timeout has its context canceled", func(t *testing.T) {
+		resetScheduler()
+
+		jobKey := makeKey()
+		jobErr := make(chan error, 1)
+		callback := func(ctx context.Context, key string, _ any) error {
+			if key == jobKey {
+				<-ctx.Done()
+				jobErr <- ctx.Err()
+				return ctx.Err()
+			}
+			return nil
+		}
+
+		require.NoError(t, s.SetCallbackWithContext(callback))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		_, err := s.ScheduleOnceWithOptions(jobKey, time.Now().Add(100*time.Millisecond), nil, JobOnceOptions{
+			Timeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		jobs, err := s.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, 100*time.Millisecond, jobs[0].Timeout)
+
+		select {
+		case err = <-jobErr:
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+		case <-time.After(time.Second):
+			require.Fail(t, "job should have timed out")
+		}
+
+		// Without a retry policy, the job fails as soon as it times out.
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListFailedJobs()
+			return listErr == nil && len(jobs) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		jobs, err = s.ListFailedJobs()
+		require.NoError(t, err)
+		assert.Equal(t, context.DeadlineExceeded.Error(), jobs[0].LastError)
+
+		s.Cancel(jobKey)
+		assert.Nil(t, getVal(oncePrefix+jobKey))
+	})
+
+	t.Run("closing the scheduler interrupts jobs without canceling them", func(t *testing.T) {
+		resetScheduler()
+
+		jobKey := makeKey()
+		started := make(chan struct{}, 2)
+		count := new(int32)
+		callback := func(ctx context.Context, key string, _ any) error {
+			if key != jobKey {
+				return nil
+			}
+
+			started <- struct{}{}
+			if atomic.AddInt32(count, 1) == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}
+
+		require.NoError(t, s.SetCallbackWithContext(callback))
+		require.NoError(t, s.Start())
+
+		_, err := s.ScheduleOnce(jobKey, time.Now().Add(100*time.Millisecond), nil)
+		require.NoError(t, err)
+
+		requireSignal(t, started, time.Second, "job should have started")
+		require.NoError(t, s.Close())
+		assert.NotEmpty(t, getVal(oncePrefix+jobKey))
+
+		s.activeJobs.mu.RLock()
+		assert.Empty(t, s.activeJobs.jobs)
+		s.activeJobs.mu.RUnlock()
+
+		// The job runs again once restarted.
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		requireSignal(t, started, time.Second, "job should have started again")
+		require.Eventually(t, func() bool { return getVal(oncePrefix+jobKey) == nil }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("closing the scheduler gives up waiting on context", func(t *testing.T) {
+		resetScheduler()
+
+		jobKey := makeKey()
+		started := make(chan struct{})
+		finish := make(chan struct{})
+		callback := func(ctx context.Context, key string, _ any) error {
+			if key == jobKey {
+				close(started)
+				<-finish
+			}
+			return nil
+		}
+
+		require.NoError(t, s.SetCallbackWithContext(callback))
+		require.NoError(t, s.Start())
+
+		_, err := s.ScheduleOnce(jobKey, time.Now().Add(100*time.Millisecond), nil)
+		require.NoError(t, err)
+
+		requireSignal(t, started, time.Second, "job should have started")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, s.CloseWithContext(ctx), context.DeadlineExceeded)
+
+		close(finish)
 	})
 }
 
@@ -683,3 +839,547 @@ func TestScheduleOnceProps(t *testing.T) {
 		require.Error(t, err)
 	})
 }
+
+func TestNewJobOnceScheduler(t *testing.T) {
+	type reminderProps struct {
+		UserID string
+		Count  int
+	}
+
+	t.Run("invalid namespace", func(t *testing.T) {
+		_, err := NewJobOnceScheduler(newMockPluginAPI(t), "")
+		require.Error(t, err)
+
+		_, err = NewJobOnceScheduler(newMockPluginAPI(t), "a:b")
+		require.Error(t, err)
+	})
+
+	t.Run("requires a callback or handler to start", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+		require.Error(t, s.Start())
+
+		require.Error(t, s.RegisterHandler("", func(ctx context.Context, metadata JobOnceMetadata) error { return nil }))
+		require.Error(t, s.RegisterHandler("reminder", nil))
+	})
+
+	t.Run("handlers receive typed props", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+
+		received := make(chan reminderProps, 1)
+		err = s.RegisterHandler("reminder", TypedJobOnceHandler(func(ctx context.Context, metadata JobOnceMetadata, props reminderProps) error {
+			assert.Equal(t, "key", metadata.Key)
+			assert.Equal(t, "reminder", metadata.Type)
+			received <- props
+			return nil
+		}))
+		require.NoError(t, err)
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		props := reminderProps{UserID: "user", Count: 3}
+		_, err = s.ScheduleOnceWithOptions("key", time.Now().Add(100*time.Millisecond), props, JobOnceOptions{Type: "reminder"})
+		require.NoError(t, err)
+
+		select {
+		case actual := <-received:
+			assert.Equal(t, props, actual)
+		case <-time.After(time.Second):
+			require.Fail(t, "handler should have been called")
+		}
+
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListScheduledJobs()
+			return listErr == nil && len(jobs) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("namespaces are independent", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+
+		var calls1, calls2 int32
+		s1, err := NewJobOnceScheduler(mockPluginAPI, "ns1")
+		require.NoError(t, err)
+		require.NoError(t, s1.SetCallback(func(string, any) { atomic.AddInt32(&calls1, 1) }))
+		require.NoError(t, s1.Start())
+		defer s1.Close()
+
+		s2, err := NewJobOnceScheduler(mockPluginAPI, "ns2")
+		require.NoError(t, err)
+		require.NoError(t, s2.SetCallback(func(string, any) { atomic.AddInt32(&calls2, 1) }))
+		require.NoError(t, s2.Start())
+		defer s2.Close()
+
+		// The same key may be used in both namespaces.
+		_, err = s1.ScheduleOnce("key", time.Now().Add(time.Hour), nil)
+		require.NoError(t, err)
+		_, err = s2.ScheduleOnce("key", time.Now().Add(100*time.Millisecond), nil)
+		require.NoError(t, err)
+
+		jobs, err := s1.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+
+		require.Eventually(t, func() bool { return atomic.LoadInt32(&calls2) == 1 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&calls1))
+
+		jobs, err = s1.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+
+		s1.Cancel("key")
+		jobs, err = s1.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Empty(t, jobs)
+	})
+
+	t.Run("jobs without a handler are left scheduled", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+		require.NoError(t, s.RegisterHandler("other", func(ctx context.Context, metadata JobOnceMetadata) error { return nil }))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		_, err = s.ScheduleOnceWithOptions("key", time.Now().Add(10*time.Millisecond), nil, JobOnceOptions{Type: "unknown"})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			s.activeJobs.mu.RLock()
+			defer s.activeJobs.mu.RUnlock()
+			return len(s.activeJobs.jobs) == 0
+		}, time.Second, 10*time.Millisecond)
+
+		jobs, err := s.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "unknown", jobs[0].Type)
+	})
+}
+
+func TestJobOnceRetry(t *testing.T) {
+	t.Run("failing jobs are retried, then fail", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+
+		var calls int32
+		require.NoError(t, s.SetCallbackWithContext(func(ctx context.Context, key string, props any) error {
+			return errors.Errorf("attempt %d failed", atomic.AddInt32(&calls, 1))
+		}))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		retry := &JobOnceRetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond}
+		_, err = s.ScheduleOnceWithOptions("key", time.Now().Add(10*time.Millisecond), nil, JobOnceOptions{Retry: retry})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListFailedJobs()
+			return listErr == nil && len(jobs) == 1
+		}, 3*time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+		jobs, err := s.ListFailedJobs()
+		require.NoError(t, err)
+		assert.Equal(t, 3, jobs[0].Attempts)
+		assert.Equal(t, "attempt 3 failed", jobs[0].LastError)
+		assert.Equal(t, retry, jobs[0].Retry)
+
+		jobs, err = s.ListScheduledJobs()
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+
+		// Failed jobs are not run again when polling.
+		require.NoError(t, s.scheduleNewJobsFromDB())
+		time.Sleep(200 * time.Millisecond)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("jobs succeeding on retry are removed", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+
+		var calls int32
+		require.NoError(t, s.SetCallbackWithContext(func(ctx context.Context, key string, props any) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return errors.New("failed")
+			}
+			return nil
+		}))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		retry := &JobOnceRetryPolicy{MaxAttempts: 3, InitialBackoff: 100 * time.Millisecond}
+		_, err = s.ScheduleOnceWithOptions("key", time.Now().Add(10*time.Millisecond), nil, JobOnceOptions{Retry: retry})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListScheduledJobs()
+			return listErr == nil && len(jobs) == 0
+		}, 2*time.Second, 10*time.Millisecond)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+		jobs, err := s.ListFailedJobs()
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+
+	t.Run("panicking jobs fail without a retry policy", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+		require.NoError(t, s.SetCallback(func(string, any) { panic("boom") }))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		_, err = s.ScheduleOnce("key", time.Now().Add(10*time.Millisecond), nil)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListFailedJobs()
+			return listErr == nil && len(jobs) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		jobs, err := s.ListFailedJobs()
+		require.NoError(t, err)
+		assert.Equal(t, 1, jobs[0].Attempts)
+		assert.Equal(t, "job panicked: boom", jobs[0].LastError)
+	})
+
+	t.Run("requeue a failed job", func(t *testing.T) {
+		s, err := NewJobOnceScheduler(newMockPluginAPI(t), "ns")
+		require.NoError(t, err)
+
+		var calls int32
+		require.NoError(t, s.SetCallbackWithContext(func(ctx context.Context, key string, props any) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return errors.New("failed")
+			}
+			return nil
+		}))
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		require.Error(t, s.Requeue("key"), "missing jobs cannot be requeued")
+
+		_, err = s.ScheduleOnce("key", time.Now().Add(10*time.Millisecond), nil)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListFailedJobs()
+			return listErr == nil && len(jobs) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		require.NoError(t, s.Requeue("key"))
+		require.Error(t, s.Requeue("key"), "only failed jobs can be requeued")
+
+		// Requeue holds the job's mutex, so the requeued job may wait for the next lock attempt.
+		require.Eventually(t, func() bool {
+			return atomic.LoadInt32(&calls) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListScheduledJobs()
+			return listErr == nil && len(jobs) == 0
+		}, 5*time.Second, 10*time.Millisecond)
+
+		jobs, err := s.ListFailedJobs()
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+
+	t.Run("backoff", func(t *testing.T) {
+		policy := JobOnceRetryPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 3}
+		assert.Equal(t, time.Second, policy.backoff(1))
+		assert.Equal(t, 3*time.Second, policy.backoff(2))
+		assert.Equal(t, 9*time.Second, policy.backoff(3))
+		assert.Equal(t, 10*time.Second, policy.backoff(4))
+
+		policy = JobOnceRetryPolicy{}
+		assert.Equal(t, time.Minute, policy.backoff(1))
+		assert.Equal(t, 2*time.Minute, policy.backoff(2))
+		assert.Equal(t, time.Hour, policy.backoff(10))
+	})
+}
+
+func TestJobOnceReschedule(t *testing.T) {
+	type reminderProps struct {
+		Count int
+	}
+
+	newScheduler := func(t *testing.T, mockPluginAPI *mockPluginAPI, called chan<- reminderProps) *JobOnceScheduler {
+		s, err := NewJobOnceScheduler(mockPluginAPI, "ns")
+		require.NoError(t, err)
+		require.NoError(t, s.SetCallbackWithContext(func(ctx context.Context, key string, props any) error {
+			// Props are decoded from JSON on other plugin instances.
+			var decoded reminderProps
+			if err := (JobOnceMetadata{Props: props}).DecodeProps(&decoded); err != nil {
+				return err
+			}
+			called <- decoded
+			return nil
+		}))
+		require.NoError(t, s.RegisterHandler("reminder", TypedJobOnceHandler(func(ctx context.Context, metadata JobOnceMetadata, props reminderProps) error {
+			called <- props
+			return nil
+		})))
+		require.NoError(t, s.Start())
+
+		return s
+	}
+
+	t.Run("rescheduling a missing job fails", func(t *testing.T) {
+		s := newScheduler(t, newMockPluginAPI(t), make(chan reminderProps, 1))
+		defer s.Close()
+
+		_, err := s.Reschedule("key", time.Now(), reminderProps{})
+		require.Error(t, err)
+
+		jobs, err := s.ListScheduledJobs()
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+	})
+
+	t.Run("rescheduling earlier wakes the job", func(t *testing.T) {
+		called := make(chan reminderProps, 1)
+		s := newScheduler(t, newMockPluginAPI(t), called)
+		defer s.Close()
+
+		job, err := s.ScheduleOnce("key", time.Now().Add(time.Hour), reminderProps{Count: 1})
+		require.NoError(t, err)
+
+		rescheduled, err := s.Reschedule("key", time.Now().Add(100*time.Millisecond), reminderProps{Count: 2})
+		require.NoError(t, err)
+		assert.Same(t, job, rescheduled)
+
+		select {
+		case props := <-called:
+			assert.Equal(t, reminderProps{Count: 2}, props)
+		case <-time.After(time.Second):
+			require.Fail(t, "job should have run")
+		}
+
+		require.Eventually(t, func() bool {
+			jobs, listErr := s.ListScheduledJobs()
+			return listErr == nil && len(jobs) == 0
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("rescheduling later keeps the job's options", func(t *testing.T) {
+		called := make(chan reminderProps, 1)
+		s := newScheduler(t, newMockPluginAPI(t), called)
+		defer s.Close()
+
+		_, err := s.ScheduleOnceWithOptions("key", time.Now().Add(100*time.Millisecond), reminderProps{Count: 1}, JobOnceOptions{
+			Type:    "reminder",
+			Timeout: time.Minute,
+		})
+		require.NoError(t, err)
+
+		newRunAt := time.Now().Add(time.Hour)
+		_, err = s.Reschedule("key", newRunAt, reminderProps{Count: 2})
+		require.NoError(t, err)
+
+		time.Sleep(300 * time.Millisecond)
+		assert.Empty(t, called, "job should not have run")
+
+		jobs, err := s.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.True(t, newRunAt.Equal(jobs[0].RunAt))
+		assert.Equal(t, "reminder", jobs[0].Type)
+		assert.Equal(t, time.Minute, jobs[0].Timeout)
+		assert.Equal(t, int64(1), jobs[0].Revision)
+
+		var props reminderProps
+		require.NoError(t, jobs[0].DecodeProps(&props))
+		assert.Equal(t, reminderProps{Count: 2}, props)
+	})
+
+	t.Run("upsert schedules or reschedules", func(t *testing.T) {
+		called := make(chan reminderProps, 1)
+		s := newScheduler(t, newMockPluginAPI(t), called)
+		defer s.Close()
+
+		_, err := s.Upsert("key", time.Now().Add(time.Hour), reminderProps{Count: 1})
+		require.NoError(t, err)
+
+		jobs, err := s.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, int64(0), jobs[0].Revision)
+
+		_, err = s.UpsertWithOptions("key", time.Now().Add(100*time.Millisecond), reminderProps{Count: 2}, JobOnceOptions{Type: "reminder"})
+		require.NoError(t, err)
+
+		select {
+		case props := <-called:
+			assert.Equal(t, reminderProps{Count: 2}, props)
+		case <-time.After(time.Second):
+			require.Fail(t, "job should have run")
+		}
+	})
+
+	t.Run("other plugin instances pick up the change", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+
+		called1 := make(chan reminderProps, 1)
+		s1 := newScheduler(t, mockPluginAPI, called1)
+
+		_, err := s1.ScheduleOnce("key", time.Now().Add(time.Hour), reminderProps{Count: 1})
+		require.NoError(t, err)
+
+		called2 := make(chan reminderProps, 1)
+		s2 := newScheduler(t, mockPluginAPI, called2)
+		defer s2.Close()
+
+		_, err = s1.Reschedule("key", time.Now().Add(200*time.Millisecond), reminderProps{Count: 2})
+		require.NoError(t, err)
+		require.NoError(t, s1.Close())
+
+		// Simulate polling for new jobs.
+		require.NoError(t, s2.scheduleNewJobsFromDB())
+
+		select {
+		case props := <-called2:
+			assert.Equal(t, reminderProps{Count: 2}, props)
+		case <-time.After(time.Second):
+			require.Fail(t, "job should have run")
+		}
+		assert.Empty(t, called1, "job should have run once")
+	})
+}
+
+func TestJobOnceClusterEvents(t *testing.T) {
+	newScheduler := func(t *testing.T, mockPluginAPI *mockPluginAPI, called chan<- string) *JobOnceScheduler {
+		s, err := NewJobOnceScheduler(mockPluginAPI, "ns")
+		require.NoError(t, err)
+		require.NoError(t, s.SetCallback(func(key string, _ any) {
+			called <- key
+		}))
+
+		return s
+	}
+
+	t.Run("unrelated events are ignored", func(t *testing.T) {
+		s := newScheduler(t, newMockPluginAPI(t), make(chan string, 1))
+
+		data, err := json.Marshal(jobOnceChange{Namespace: "other", Key: "key"})
+		require.NoError(t, err)
+		assert.False(t, s.HandleClusterEvent(model.PluginClusterEvent{Id: "other", Data: data}))
+		assert.False(t, s.HandleClusterEvent(model.PluginClusterEvent{Id: jobOnceChangeEventID, Data: data}))
+
+		data, err = json.Marshal(jobOnceChange{Namespace: "ns", Key: "key"})
+		require.NoError(t, err)
+		assert.True(t, s.HandleClusterEvent(model.PluginClusterEvent{Id: jobOnceChangeEventID, Data: data}))
+	})
+
+	t.Run("jobs scheduled and canceled on other plugin instances are picked up", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+
+		called1 := make(chan string, 1)
+		s1 := newScheduler(t, mockPluginAPI, called1)
+		require.NoError(t, s1.Start())
+		defer s1.Close()
+
+		called2 := make(chan string, 1)
+		s2 := newScheduler(t, mockPluginAPI, called2)
+		require.NoError(t, s2.Start())
+		defer s2.Close()
+		mockPluginAPI.onClusterEvent(func(ev model.PluginClusterEvent) {
+			assert.True(t, s2.HandleClusterEvent(ev))
+		})
+
+		_, err := s1.ScheduleOnce("canceled", time.Now().Add(time.Hour), nil)
+		require.NoError(t, err)
+		assert.True(t, isTracked(s2, "canceled"))
+
+		s1.Cancel("canceled")
+		require.Eventually(t, func() bool { return !isTracked(s2, "canceled") }, time.Second, 10*time.Millisecond)
+
+		_, err = s1.ScheduleOnce("key", time.Now().Add(200*time.Millisecond), nil)
+		require.NoError(t, err)
+		assert.True(t, isTracked(s2, "key"))
+
+		// Leave the job to the other plugin instance.
+		require.NoError(t, s1.Close())
+
+		select {
+		case key := <-called2:
+			assert.Equal(t, "key", key)
+		case <-time.After(time.Second):
+			require.Fail(t, "job should have run")
+		}
+		assert.Empty(t, called1)
+	})
+
+	t.Run("jobs are polled on the configured interval", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+
+		s1 := newScheduler(t, mockPluginAPI, make(chan string, 1))
+		require.NoError(t, s1.Start())
+
+		called2 := make(chan string, 1)
+		s2 := newScheduler(t, mockPluginAPI, called2)
+		require.Error(t, s2.SetPollInterval(0))
+		require.NoError(t, s2.SetPollInterval(100*time.Millisecond))
+		require.NoError(t, s2.Start())
+		defer s2.Close()
+
+		_, err := s1.ScheduleOnce("key", time.Now().Add(100*time.Millisecond), nil)
+		require.NoError(t, err)
+		require.NoError(t, s1.Close())
+
+		select {
+		case key := <-called2:
+			assert.Equal(t, "key", key)
+		case <-time.After(time.Second):
+			require.Fail(t, "job should have run")
+		}
+	})
+
+	t.Run("jobs scheduled before the index existed are indexed when started", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		s := newScheduler(t, mockPluginAPI, make(chan string, 1))
+
+		data, err := json.Marshal(JobOnceMetadata{Key: "key", RunAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		_, appErr := mockPluginAPI.KVSetWithOptions(s.metadataKey("key"), data, model.PluginKVSetOptions{})
+		require.Nil(t, appErr)
+
+		jobs, err := s.ListScheduledJobs()
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+
+		require.NoError(t, s.Start())
+		defer s.Close()
+
+		jobs, err = s.ListScheduledJobs()
+		require.NoError(t, err)
+		require.Len(t, jobs, 1)
+		assert.Equal(t, "key", jobs[0].Key)
+
+		// Canceled jobs are pruned from the index when listing.
+		s.Cancel("key")
+		jobs, err = s.ListScheduledJobs()
+		require.NoError(t, err)
+		assert.Empty(t, jobs)
+
+		keys, err := s.index.keys()
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	})
+}
+
+func TestJobOnceMetadataDecodeProps(t *testing.T) {
+	metadata := JobOnceMetadata{Props: map[string]interface{}{"UserID": "user", "Count": float64(3)}}
+
+	var props struct {
+		UserID string
+		Count  int
+	}
+	require.NoError(t, metadata.DecodeProps(&props))
+	assert.Equal(t, "user", props.UserID)
+	assert.Equal(t, 3, props.Count)
+
+	var invalid []string
+	require.Error(t, metadata.DecodeProps(&invalid))
+}
@@ -1,6 +1,10 @@
 package cluster
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -410,3 +414,243 @@ func TestSchedule(t *testing.T) {
 		assert.Greater(t, *countB, int32(5))
 	})
 }
+
+func TestScheduleWithOptions(t *testing.T) {
+	t.Parallel()
+
+	makeKey := model.NewId
+
+	t.Run("records history", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := makeKey()
+
+		count := new(int32)
+		callback := func(ctx context.Context) error {
+			if atomic.AddInt32(count, 1)%2 == 0 {
+				return errors.New("even run")
+			}
+			return nil
+		}
+
+		job, err := ScheduleWithOptions(mockPluginAPI, key, MakeWaitForInterval(100*time.Millisecond), callback, JobOptions{
+			HistorySize: 3,
+		})
+		require.NoError(t, err)
+
+		time.Sleep(1 * time.Second)
+		require.NoError(t, job.Close())
+
+		status, err := InspectJob(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.False(t, status.Running)
+		assert.Nil(t, status.Current)
+		require.Len(t, status.History, 3)
+		assert.True(t, status.NextRun.After(status.LastFinished))
+
+		hostname, _ := os.Hostname()
+		for i, run := range status.History {
+			assert.Equal(t, hostname, run.NodeID)
+			assert.InDelta(t, run.Finished.Sub(run.Started), run.Duration, float64(time.Millisecond))
+			if i > 0 {
+				assert.True(t, run.Finished.Before(status.History[i-1].Started) || run.Finished.Equal(status.History[i-1].Started))
+			}
+		}
+		assert.Equal(t, status.History[0].Finished, status.LastFinished)
+
+		// Runs alternate between succeeding and failing.
+		if status.History[0].Error != "" {
+			assert.Equal(t, "even run", status.History[0].Error)
+			assert.Equal(t, 1, status.ConsecutiveFailures)
+			assert.Empty(t, status.History[1].Error)
+		} else {
+			assert.Equal(t, 0, status.ConsecutiveFailures)
+			assert.Equal(t, "even run", status.History[1].Error)
+		}
+	})
+
+	t.Run("backs off after failures", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := makeKey()
+
+		count := new(int32)
+		callback := func(ctx context.Context) error {
+			atomic.AddInt32(count, 1)
+			return errors.New("failed")
+		}
+
+		job, err := ScheduleWithOptions(mockPluginAPI, key, MakeWaitForInterval(100*time.Millisecond), callback, JobOptions{
+			FailureBackoff: func(failures int) time.Duration {
+				return time.Hour
+			},
+		})
+		require.NoError(t, err)
+
+		time.Sleep(1 * time.Second)
+		require.NoError(t, job.Close())
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(count))
+
+		status, err := InspectJob(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.Equal(t, 1, status.ConsecutiveFailures)
+		assert.WithinDuration(t, status.LastFinished.Add(time.Hour), status.NextRun, time.Second)
+	})
+
+	t.Run("reports running job", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := makeKey()
+
+		started := make(chan struct{})
+		finish := make(chan struct{})
+		callback := func(ctx context.Context) error {
+			close(started)
+			<-finish
+			return nil
+		}
+
+		job, err := ScheduleWithOptions(mockPluginAPI, key, MakeWaitForInterval(time.Hour), callback, JobOptions{})
+		require.NoError(t, err)
+
+		requireSignal(t, started, time.Second, "job should have started")
+
+		status, err := InspectJob(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.True(t, status.Running)
+		require.NotNil(t, status.Current)
+		assert.False(t, status.Current.Started.IsZero())
+		assert.True(t, status.Current.Finished.IsZero())
+
+		close(finish)
+		require.NoError(t, job.Close())
+
+		status, err = InspectJob(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.False(t, status.Running)
+		assert.Len(t, status.History, 1)
+	})
+
+	t.Run("never scheduled", func(t *testing.T) {
+		t.Parallel()
+
+		status, err := InspectJob(newMockPluginAPI(t), makeKey())
+		require.NoError(t, err)
+		assert.Equal(t, &JobStatus{}, status)
+	})
+}
+
+func TestJobClose(t *testing.T) {
+	t.Parallel()
+
+	makeKey := model.NewId
+
+	t.Run("cancels running callback", func(t *testing.T) {
+		t.Parallel()
+
+		started := make(chan struct{})
+		callback := func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := makeKey()
+		job, err := ScheduleWithOptions(mockPluginAPI, key, MakeWaitForInterval(time.Hour), callback, JobOptions{})
+		require.NoError(t, err)
+
+		requireSignal(t, started, time.Second, "job should have started")
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			assert.NoError(t, job.Close())
+		}()
+		requireSignal(t, closed, time.Second, "job should have closed")
+
+		// The interrupted run is not recorded as failed.
+		status, err := InspectJob(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.Empty(t, status.History)
+		assert.Zero(t, status.ConsecutiveFailures)
+		assert.NotNil(t, status.Current)
+		assert.False(t, status.Running)
+	})
+
+	t.Run("clears stale current run", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := makeKey()
+
+		data, err := json.Marshal(JobMetadata{
+			LastFinished: time.Now(),
+			Current:      &JobRun{Started: time.Now().Add(-time.Hour)},
+		})
+		require.NoError(t, err)
+		mockPluginAPI.keyValues[cronPrefix+key] = data
+
+		job, err := ScheduleWithOptions(mockPluginAPI, key, MakeWaitForInterval(time.Hour), func(ctx context.Context) error {
+			return nil
+		}, JobOptions{})
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			status, inspectErr := InspectJob(mockPluginAPI, key)
+			return inspectErr == nil && status.Current == nil
+		}, time.Second, 10*time.Millisecond)
+		require.NoError(t, job.Close())
+	})
+
+	t.Run("gives up waiting on context", func(t *testing.T) {
+		t.Parallel()
+
+		started := make(chan struct{})
+		finish := make(chan struct{})
+		defer close(finish)
+		callback := func(ctx context.Context) error {
+			close(started)
+			<-finish
+			return nil
+		}
+
+		job, err := ScheduleWithOptions(newMockPluginAPI(t), makeKey(), MakeWaitForInterval(time.Hour), callback, JobOptions{})
+		require.NoError(t, err)
+
+		requireSignal(t, started, time.Second, "job should have started")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, job.CloseWithContext(ctx), context.DeadlineExceeded)
+	})
+
+	t.Run("times out callback", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := makeKey()
+
+		callback := func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		job, err := ScheduleWithOptions(mockPluginAPI, key, MakeWaitForInterval(time.Hour), callback, JobOptions{
+			Timeout: 100 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		time.Sleep(500 * time.Millisecond)
+		require.NoError(t, job.Close())
+
+		status, err := InspectJob(mockPluginAPI, key)
+		require.NoError(t, err)
+		require.Len(t, status.History, 1)
+		assert.Equal(t, context.DeadlineExceeded.Error(), status.History[0].Error)
+	})
+}
@@ -0,0 +1,137 @@
+package cluster
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// keyIndexMaxAttempts is the number of times an index shard is read and written before giving up
+// on concurrent updates.
+const keyIndexMaxAttempts = 5
+
+// keyIndex lists a set of keys, so that listing them doesn't require paging through every key of
+// the plugin. The keys are spread across a fixed number of key values by hashing them, bounding
+// the size of each key value and the contention when updating it.
+type keyIndex struct {
+	pluginAPI JobPluginAPI
+	prefix    string
+	shards    int
+}
+
+// shardKey returns the key value holding the given key.
+func (i *keyIndex) shardKey(key string) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+
+	return i.prefix + strconv.Itoa(int(h.Sum32()%uint32(i.shards)))
+}
+
+// readShard returns the keys held by the given key value, along with its raw data.
+func (i *keyIndex) readShard(shardKey string) (map[string]struct{}, []byte, error) {
+	data, appErr := i.pluginAPI.KVGet(shardKey)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(normalizeAppErr(appErr), "failed to get index")
+	}
+
+	keys := map[string]struct{}{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, nil, errors.Wrap(err, "failed to decode index")
+		}
+	}
+
+	return keys, data, nil
+}
+
+// update atomically applies f to the keys of the shard holding the given key, retrying on
+// conflict after a wait. The shard is written only if f returns true.
+func (i *keyIndex) update(key string, f func(keys map[string]struct{}) bool) error {
+	shardKey := i.shardKey(key)
+
+	var wait time.Duration
+	for attempt := 0; attempt < keyIndexMaxAttempts; attempt++ {
+		if attempt > 0 {
+			wait = nextWaitInterval(wait, nil)
+			time.Sleep(wait)
+		}
+
+		keys, oldData, err := i.readShard(shardKey)
+		if err != nil {
+			return err
+		}
+
+		if !f(keys) {
+			return nil
+		}
+
+		var data []byte
+		if len(keys) > 0 {
+			data, err = json.Marshal(keys)
+			if err != nil {
+				return errors.Wrap(err, "failed to encode index")
+			}
+		}
+
+		ok, appErr := i.pluginAPI.KVSetWithOptions(shardKey, data, model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: oldData,
+		})
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "failed to set index")
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("failed to update index due to concurrent updates")
+}
+
+// add adds the given key to the index.
+func (i *keyIndex) add(key string) error {
+	return i.update(key, func(keys map[string]struct{}) bool {
+		if _, ok := keys[key]; ok {
+			return false
+		}
+
+		keys[key] = struct{}{}
+		return true
+	})
+}
+
+// remove removes the given key from the index.
+func (i *keyIndex) remove(key string) error {
+	return i.update(key, func(keys map[string]struct{}) bool {
+		if _, ok := keys[key]; !ok {
+			return false
+		}
+
+		delete(keys, key)
+		return true
+	})
+}
+
+// keys returns the keys in the index, sorted.
+func (i *keyIndex) keys() ([]string, error) {
+	var ret []string
+	for shard := 0; shard < i.shards; shard++ {
+		keys, _, err := i.readShard(i.prefix + strconv.Itoa(shard))
+		if err != nil {
+			return nil, err
+		}
+
+		for key := range keys {
+			ret = append(ret, key)
+		}
+	}
+
+	sort.Strings(ret)
+
+	return ret, nil
+}
@@ -0,0 +1,38 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// keyLister is the plugin API interface required to walk the keys of the kv store.
+type keyLister interface {
+	KVList(page, count int) ([]string, *model.AppError)
+}
+
+// forEachKey walks every page of the kv store, calling f with each key starting with the given
+// prefix. The walk stops early if f returns false.
+func forEachKey(pluginAPI keyLister, prefix string, f func(key string) bool) error {
+	for page := 0; ; page++ {
+		keys, appErr := pluginAPI.KVList(page, keysPerPage)
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "error getting KVList")
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+
+			if !f(key) {
+				return nil
+			}
+		}
+
+		if len(keys) < keysPerPage {
+			return nil
+		}
+	}
+}
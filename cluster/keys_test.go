@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachKey(t *testing.T) {
+	mockPluginAPI := newMockPluginAPI(t)
+	for i := 0; i < keysPerPage+10; i++ {
+		mockPluginAPI.keyValues["a_"+strconv.Itoa(i)] = []byte{1}
+	}
+	for i := 0; i < keysPerPage; i++ {
+		mockPluginAPI.keyValues["b_"+strconv.Itoa(i)] = []byte{1}
+	}
+
+	t.Run("visits every key with the prefix", func(t *testing.T) {
+		count := 0
+		err := forEachKey(mockPluginAPI, "b_", func(key string) bool {
+			count++
+			return true
+		})
+		require.NoError(t, err)
+		assert.Equal(t, keysPerPage, count)
+	})
+
+	t.Run("stops early", func(t *testing.T) {
+		count := 0
+		err := forEachKey(mockPluginAPI, "a_", func(key string) bool {
+			count++
+			return count < 5
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 5, count)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mockPluginAPI.setFailing(true)
+		defer mockPluginAPI.setFailing(false)
+
+		err := forEachKey(mockPluginAPI, "a_", func(key string) bool {
+			return true
+		})
+		require.Error(t, err)
+	})
+}
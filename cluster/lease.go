@@ -0,0 +1,285 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxLeaseHolders is the maximum number of concurrent holders of a lease, bounding the size of
+	// its key value.
+	maxLeaseHolders = 1000
+)
+
+// leasePluginAPI is the plugin API interface required to manage leases.
+type leasePluginAPI interface {
+	MutexReaderPluginAPI
+}
+
+// leaseHolder is a single holder of a lease.
+type leaseHolder struct {
+	// Exclusive is set for holders requiring the lease to themselves.
+	Exclusive bool `json:"x,omitempty"`
+
+	// Expires is the time in unix milliseconds after which the holder is pruned, unless refreshed.
+	Expires int64 `json:"e"`
+}
+
+// leaseState is the state of a lease, stored as a single key value.
+type leaseState struct {
+	Holders map[string]leaseHolder `json:"h"`
+}
+
+// leases manage a lock that may be held by multiple holders at once, such as the readers of an
+// RWMutex or the holders of a Semaphore.
+//
+// Unlike a Mutex, a single key value tracks all the holders, each with its own expiry refreshed on
+// the same interval as a Mutex. Holders that died without releasing the lease are pruned once
+// expired, which assumes the clocks of the plugin instances roughly agree. The key value itself
+// expires once every holder would have.
+type leases struct {
+	pluginAPI leasePluginAPI
+	key       string
+}
+
+// lease is a held lease, refreshed until released.
+type lease struct {
+	leases *leases
+	id     string
+	stop   chan struct{}
+	done   chan struct{}
+
+	// lost is closed if refreshing the lease fails, after which it may be acquired by others.
+	lost chan struct{}
+}
+
+// update atomically applies f to the state of the leases, retrying on conflict. Expired holders
+// are pruned before calling f, and the state is written only if f returns true or holders were
+// pruned.
+func (l *leases) update(f func(state *leaseState, now time.Time) bool) error {
+	for {
+		oldData, appErr := l.pluginAPI.KVGet(l.key)
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "failed to get lease kv")
+		}
+
+		state := leaseState{Holders: map[string]leaseHolder{}}
+		if len(oldData) > 0 {
+			if err := json.Unmarshal(oldData, &state); err != nil {
+				return errors.Wrap(err, "failed to decode lease kv")
+			}
+			if state.Holders == nil {
+				state.Holders = map[string]leaseHolder{}
+			}
+		}
+
+		now := time.Now()
+		changed := false
+		for id, holder := range state.Holders {
+			if holder.Expires <= now.UnixMilli() {
+				delete(state.Holders, id)
+				changed = true
+			}
+		}
+
+		if !f(&state, now) && !changed {
+			return nil
+		}
+
+		var data []byte
+		if len(state.Holders) > 0 {
+			var err error
+			data, err = json.Marshal(state)
+			if err != nil {
+				return errors.Wrap(err, "failed to encode lease kv")
+			}
+		}
+
+		ok, appErr := l.pluginAPI.KVSetWithOptions(l.key, data, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        oldData,
+			ExpireInSeconds: int64(ttl / time.Second),
+		})
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "failed to set lease kv")
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// tryAcquire makes a single attempt to acquire a lease for the given holder, returning true only
+// if successful. Shared holders are limited to the given number, and exclude exclusive ones.
+//
+// An exclusive holder claims the lease even while shared holders remain, preventing new shared
+// holders from starving it, and acquires it once they are gone. Each attempt refreshes that claim.
+func (l *leases) tryAcquire(id string, exclusive bool, limit int) (bool, error) {
+	acquired := false
+	err := l.update(func(state *leaseState, now time.Time) bool {
+		expires := now.Add(ttl).UnixMilli()
+		if exclusive {
+			if holder, ok := state.Holders[id]; !ok || !holder.Exclusive {
+				for _, holder := range state.Holders {
+					if holder.Exclusive {
+						return false
+					}
+				}
+			}
+
+			state.Holders[id] = leaseHolder{Exclusive: true, Expires: expires}
+			acquired = len(state.Holders) == 1
+			return true
+		}
+
+		if len(state.Holders) >= limit {
+			return false
+		}
+		for _, holder := range state.Holders {
+			if holder.Exclusive {
+				return false
+			}
+		}
+
+		state.Holders[id] = leaseHolder{Expires: expires}
+		acquired = true
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return acquired, nil
+}
+
+// refresh extends the expiry of the given holder, failing if it no longer holds the lease.
+func (l *leases) refresh(id string) error {
+	held := false
+	err := l.update(func(state *leaseState, now time.Time) bool {
+		holder, ok := state.Holders[id]
+		if !ok {
+			return false
+		}
+
+		holder.Expires = now.Add(ttl).UnixMilli()
+		state.Holders[id] = holder
+		held = true
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !held {
+		return errors.New("unexpectedly failed to refresh lease, holder expired")
+	}
+
+	return nil
+}
+
+// release removes the given holder, if present.
+func (l *leases) release(id string) error {
+	return l.update(func(state *leaseState, now time.Time) bool {
+		if _, ok := state.Holders[id]; !ok {
+			return false
+		}
+
+		delete(state.Holders, id)
+		return true
+	})
+}
+
+// acquire acquires a lease, blocking until successful or the context is canceled.
+func (l *leases) acquire(ctx context.Context, exclusive bool, limit int) (*lease, error) {
+	id := model.NewId()
+
+	var waitInterval time.Duration
+	for {
+		select {
+		case <-ctx.Done():
+			// Withdraw any claim made by an exclusive holder. It will otherwise expire.
+			if exclusive {
+				_ = l.release(id)
+			}
+			return nil, ctx.Err()
+		case <-time.After(waitInterval):
+		}
+
+		acquired, err := l.tryAcquire(id, exclusive, limit)
+		if err != nil {
+			l.pluginAPI.LogError("failed to acquire lease", "err", err, "lock_key", l.key)
+			waitInterval = nextWaitInterval(waitInterval, err)
+			continue
+		} else if !acquired {
+			waitInterval = nextWaitInterval(waitInterval, err)
+			if exclusive && waitInterval > refreshInterval {
+				// Keep the claim from expiring while waiting.
+				waitInterval = refreshInterval
+			}
+			continue
+		}
+
+		return l.startRefreshing(id), nil
+	}
+}
+
+// startRefreshing refreshes the lease held by the given holder until released.
+func (l *leases) startRefreshing(id string) *lease {
+	held := &lease{
+		leases: l,
+		id:     id,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(held.done)
+		t := time.NewTicker(refreshInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if err := l.refresh(id); err != nil {
+					l.pluginAPI.LogError("failed to refresh lease", "err", err, "lock_key", l.key)
+					close(held.lost)
+					return
+				}
+			case <-held.stop:
+				return
+			}
+		}
+	}()
+
+	return held
+}
+
+// heldContext returns a context derived from ctx, canceled once the lease is released or as soon
+// as refreshing it fails.
+func (held *lease) heldContext(ctx context.Context) context.Context {
+	heldCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-held.lost:
+		case <-held.done:
+		case <-heldCtx.Done():
+		}
+	}()
+
+	return heldCtx
+}
+
+// release stops refreshing the lease and releases it.
+func (held *lease) release() {
+	close(held.stop)
+	<-held.done
+
+	// If an error occurs releasing, the holder will still expire, allowing later retry.
+	if err := held.leases.release(held.id); err != nil {
+		held.leases.pluginAPI.LogError("failed to release lease", "err", err, "lock_key", held.leases.key)
+	}
+}
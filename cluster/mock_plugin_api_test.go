@@ -17,6 +17,8 @@ type mockPluginAPI struct {
 	keyValues         map[string][]byte
 	failing           bool
 	failingWithPrefix string
+
+	clusterEventHandlers []func(ev model.PluginClusterEvent)
 }
 
 func newMockPluginAPI(t *testing.T) *mockPluginAPI {
@@ -136,6 +138,27 @@ func (pluginAPI *mockPluginAPI) KVSetWithOptions(key string, value []byte, optio
 	return true, nil
 }
 
+// onClusterEvent registers a handler for the cluster events published, as if by another plugin
+// instance.
+func (pluginAPI *mockPluginAPI) onClusterEvent(f func(ev model.PluginClusterEvent)) {
+	pluginAPI.lock.Lock()
+	defer pluginAPI.lock.Unlock()
+
+	pluginAPI.clusterEventHandlers = append(pluginAPI.clusterEventHandlers, f)
+}
+
+func (pluginAPI *mockPluginAPI) PublishPluginClusterEvent(ev model.PluginClusterEvent, opts model.PluginClusterEventSendOptions) error {
+	pluginAPI.lock.Lock()
+	handlers := append([]func(ev model.PluginClusterEvent){}, pluginAPI.clusterEventHandlers...)
+	pluginAPI.lock.Unlock()
+
+	for _, f := range handlers {
+		f(ev)
+	}
+
+	return nil
+}
+
 func (pluginAPI *mockPluginAPI) LogError(msg string, keyValuePairs ...interface{}) {
 	if pluginAPI.t == nil {
 		return
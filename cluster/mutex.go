@@ -2,6 +2,10 @@ package cluster
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,13 +17,17 @@ const (
 	// mutexPrefix is used to namespace key values created for a mutex from other key values
 	// created by a plugin.
 	mutexPrefix = "mutex_"
+
+	// fencingTokenPrefix is used to namespace the key values holding the last fencing token
+	// issued for a mutex.
+	fencingTokenPrefix = "mutexfence_"
 )
 
 const (
-	// ttl is the interval after which a locked mutex will expire unless refreshed
+	// ttl is the default interval after which a locked mutex will expire unless refreshed
 	ttl = time.Second * 15
 
-	// refreshInterval is the interval on which the mutex will be refreshed when locked
+	// refreshInterval is the default interval on which the mutex will be refreshed when locked
 	refreshInterval = ttl / 2
 )
 
@@ -29,6 +37,51 @@ type MutexPluginAPI interface {
 	LogError(msg string, keyValuePairs ...interface{})
 }
 
+// MutexReaderPluginAPI is the plugin API interface required to inspect mutexes, or to manage
+// mutexes issuing fencing tokens.
+type MutexReaderPluginAPI interface {
+	MutexPluginAPI
+	KVGet(key string) ([]byte, *model.AppError)
+}
+
+// instanceID identifies the current plugin instance as the owner of a mutex.
+var instanceID = model.NewId()
+
+// MutexOptions configures a mutex.
+type MutexOptions struct {
+	// TTL is the interval after which a locked mutex expires unless refreshed, as happens when
+	// the plugin instance holding it dies. The mutex is refreshed every TTL/2 while locked.
+	// Defaults to 15 seconds, and is rounded up to whole seconds.
+	TTL time.Duration
+
+	// Label describes the caller holding the mutex, as reported by InspectMutex.
+	Label string
+
+	// FencingTokens assigns each lock of the mutex a token greater than that of any earlier
+	// lock, allowing downstream writes to reject holders whose lock expired. The last token
+	// issued is kept in a separate key value that is never deleted. Requires the plugin API to
+	// implement MutexReaderPluginAPI.
+	FencingTokens bool
+}
+
+// MutexOwner describes the holder of a locked mutex.
+type MutexOwner struct {
+	// NodeID is the host name of the server running the plugin instance holding the mutex.
+	NodeID string
+
+	// InstanceID identifies the plugin instance holding the mutex.
+	InstanceID string
+
+	// AcquiredAt is when the mutex was locked.
+	AcquiredAt time.Time
+
+	// Label describes the caller holding the mutex, as given in MutexOptions.
+	Label string `json:",omitempty"`
+
+	// FencingToken is the token assigned to the lock, if enabled in MutexOptions.
+	FencingToken int64 `json:",omitempty"`
+}
+
 // Mutex is similar to sync.Mutex, except usable by multiple plugin instances across a cluster.
 //
 // Internally, a mutex relies on an atomic key-value set operation as exposed by the Mattermost
@@ -41,26 +94,60 @@ type MutexPluginAPI interface {
 type Mutex struct {
 	pluginAPI MutexPluginAPI
 	key       string
+	ttl       time.Duration
+	options   MutexOptions
 
-	// lock guards the variables used to manage the refresh task, and is not itself related to
-	// the cluster-wide lock.
+	// readerAPI is the plugin API used to issue fencing tokens, set only if enabled.
+	readerAPI MutexReaderPluginAPI
+
+	// lock guards the variables used to manage the refresh task and the current owner, and is
+	// not itself related to the cluster-wide lock.
 	lock        sync.Mutex
 	stopRefresh chan bool
 	refreshDone chan bool
+	value       []byte
+	token       int64
 }
 
 // NewMutex creates a mutex with the given key name.
 //
 // Panics if key is empty.
 func NewMutex(pluginAPI MutexPluginAPI, key string) (*Mutex, error) {
+	return NewMutexWithOptions(pluginAPI, key, MutexOptions{})
+}
+
+// NewMutexWithOptions creates a mutex with the given key name and options.
+func NewMutexWithOptions(pluginAPI MutexPluginAPI, key string, options MutexOptions) (*Mutex, error) {
 	key, err := makeLockKey(key)
 	if err != nil {
 		return nil, err
 	}
 
+	mutexTTL := ttl
+	if options.TTL != 0 {
+		if options.TTL < time.Second {
+			return nil, errors.New("mutex ttl must be at least one second")
+		}
+		mutexTTL = options.TTL.Truncate(time.Second)
+		if mutexTTL < options.TTL {
+			mutexTTL += time.Second
+		}
+	}
+
+	var readerAPI MutexReaderPluginAPI
+	if options.FencingTokens {
+		var ok bool
+		if readerAPI, ok = pluginAPI.(MutexReaderPluginAPI); !ok {
+			return nil, errors.New("fencing tokens require a plugin API implementing KVGet")
+		}
+	}
+
 	return &Mutex{
 		pluginAPI: pluginAPI,
 		key:       key,
+		ttl:       mutexTTL,
+		options:   options,
+		readerAPI: readerAPI,
 	}, nil
 }
 
@@ -73,26 +160,143 @@ func makeLockKey(key string) (string, error) {
 	return mutexPrefix + key, nil
 }
 
-// lock makes a single attempt to atomically lock the mutex, returning true only if successful.
-func (m *Mutex) tryLock() (bool, error) {
-	ok, err := m.pluginAPI.KVSetWithOptions(m.key, []byte{1}, model.PluginKVSetOptions{
+// InspectMutex returns the owner of the mutex with the given key name, or nil if it is unlocked.
+//
+// Mutexes locked by older versions of this package report an empty owner.
+func InspectMutex(pluginAPI MutexReaderPluginAPI, key string) (*MutexOwner, error) {
+	key, err := makeLockKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value, appErr := pluginAPI.KVGet(key)
+	if appErr != nil {
+		return nil, errors.Wrap(normalizeAppErr(appErr), "failed to get mutex kv")
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	var owner MutexOwner
+	if !json.Valid(value) {
+		return &owner, nil
+	}
+	if err := json.Unmarshal(value, &owner); err != nil {
+		return nil, errors.Wrap(err, "failed to decode mutex owner")
+	}
+
+	return &owner, nil
+}
+
+// expireInSeconds returns the expiry of the lock key value.
+func (m *Mutex) expireInSeconds() int64 {
+	return int64(m.ttl / time.Second)
+}
+
+// refreshInterval returns the interval on which the mutex is refreshed when locked.
+func (m *Mutex) refreshInterval() time.Duration {
+	return m.ttl / 2
+}
+
+// tryLock makes a single attempt to atomically lock the mutex, returning the lock key value
+// and fencing token only if successful.
+func (m *Mutex) tryLock() ([]byte, int64, error) {
+	hostname, _ := os.Hostname()
+	owner := MutexOwner{
+		NodeID:     hostname,
+		InstanceID: instanceID,
+		AcquiredAt: time.Now(),
+		Label:      m.options.Label,
+	}
+
+	value, err := json.Marshal(owner)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to encode mutex owner")
+	}
+
+	ok, appErr := m.pluginAPI.KVSetWithOptions(m.key, value, model.PluginKVSetOptions{
 		Atomic:          true,
 		OldValue:        nil, // No existing key value.
-		ExpireInSeconds: int64(ttl / time.Second),
+		ExpireInSeconds: m.expireInSeconds(),
 	})
-	if err != nil {
-		return false, errors.Wrap(err, "failed to set mutex kv")
+	if appErr != nil {
+		return nil, 0, errors.Wrap(appErr, "failed to set mutex kv")
+	} else if !ok {
+		return nil, 0, nil
+	}
+
+	if !m.options.FencingTokens {
+		return value, 0, nil
+	}
+
+	// Tokens are only issued while holding the lock, so each is greater than any earlier one.
+	owner.FencingToken, err = m.nextFencingToken()
+	if err == nil {
+		var fencedValue []byte
+		fencedValue, err = json.Marshal(owner)
+		if err == nil {
+			ok, appErr = m.pluginAPI.KVSetWithOptions(m.key, fencedValue, model.PluginKVSetOptions{
+				Atomic:          true,
+				OldValue:        value,
+				ExpireInSeconds: m.expireInSeconds(),
+			})
+			if appErr != nil {
+				err = errors.Wrap(appErr, "failed to set mutex kv")
+			} else if !ok {
+				err = errors.New("unexpectedly failed to set mutex kv")
+			} else {
+				return fencedValue, owner.FencingToken, nil
+			}
+		}
 	}
 
-	return ok, nil
+	// If an error occurs deleting, the mutex kv will still expire, allowing later retry.
+	_, _ = m.pluginAPI.KVSetWithOptions(m.key, nil, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: value,
+	})
+
+	return nil, 0, errors.Wrap(err, "failed to assign fencing token")
+}
+
+// nextFencingToken atomically increments the last fencing token issued for the mutex.
+func (m *Mutex) nextFencingToken() (int64, error) {
+	key := fencingTokenPrefix + strings.TrimPrefix(m.key, mutexPrefix)
+
+	for {
+		oldValue, appErr := m.readerAPI.KVGet(key)
+		if appErr != nil {
+			return 0, errors.Wrap(normalizeAppErr(appErr), "failed to get fencing token kv")
+		}
+
+		var token int64
+		if oldValue != nil {
+			var err error
+			token, err = strconv.ParseInt(string(oldValue), 10, 64)
+			if err != nil {
+				return 0, errors.Wrap(err, "failed to parse fencing token")
+			}
+		}
+		token++
+
+		ok, appErr := m.pluginAPI.KVSetWithOptions(key, []byte(strconv.FormatInt(token, 10)), model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: oldValue,
+		})
+		if appErr != nil {
+			return 0, errors.Wrap(appErr, "failed to set fencing token kv")
+		} else if ok {
+			return token, nil
+		}
+	}
 }
 
 // refreshLock rewrites the lock key value with a new expiry, returning true only if successful.
-func (m *Mutex) refreshLock() error {
-	ok, err := m.pluginAPI.KVSetWithOptions(m.key, []byte{1}, model.PluginKVSetOptions{
+func (m *Mutex) refreshLock(value []byte) error {
+	ok, err := m.pluginAPI.KVSetWithOptions(m.key, value, model.PluginKVSetOptions{
 		Atomic:          true,
-		OldValue:        []byte{1},
-		ExpireInSeconds: int64(ttl / time.Second),
+		OldValue:        value,
+		ExpireInSeconds: m.expireInSeconds(),
 	})
 	if err != nil {
 		return errors.Wrap(err, "failed to refresh mutex kv")
@@ -103,6 +307,21 @@ func (m *Mutex) refreshLock() error {
 	return nil
 }
 
+// TryLock tries to lock m without blocking, reporting whether it succeeded.
+func (m *Mutex) TryLock() bool {
+	value, token, err := m.tryLock()
+	if err != nil {
+		m.pluginAPI.LogError("failed to lock mutex", "err", err, "lock_key", m.key)
+		return false
+	} else if value == nil {
+		return false
+	}
+
+	m.startRefreshing(value, token)
+
+	return true
+}
+
 // Lock locks m. If the mutex is already locked by any plugin instance, including the current one,
 // the calling goroutine blocks until the mutex can be locked.
 func (m *Mutex) Lock() {
@@ -115,51 +334,106 @@ func (m *Mutex) Lock() {
 //
 // The mutex is locked only if a nil error is returned.
 func (m *Mutex) LockWithContext(ctx context.Context) error {
+	_, err := m.lockWithContext(ctx)
+	return err
+}
+
+// LockWithHeldContext locks m unless the context is canceled, just like LockWithContext. The
+// returned context is derived from ctx, and is canceled once m is unlocked or as soon as
+// refreshing the lock fails, after which the lock may be acquired by another plugin instance.
+//
+// The mutex is locked only if a nil error is returned.
+func (m *Mutex) LockWithHeldContext(ctx context.Context) (context.Context, error) {
+	lost, err := m.lockWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	done := m.refreshDone
+	m.lock.Unlock()
+
+	heldCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		defer cancel()
+		select {
+		case <-lost:
+		case <-done:
+		case <-heldCtx.Done():
+		}
+	}()
+
+	return heldCtx, nil
+}
+
+// FencingToken returns the fencing token assigned to the current lock of m, if enabled in
+// MutexOptions. Downstream writes can reject tokens lower than the highest one they have seen.
+func (m *Mutex) FencingToken() int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.token
+}
+
+// lockWithContext implements LockWithContext, returning a channel closed if refreshing the lock
+// fails, after which the lock may be acquired by another plugin instance.
+func (m *Mutex) lockWithContext(ctx context.Context) (<-chan struct{}, error) {
 	var waitInterval time.Duration
 
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return nil, ctx.Err()
 		case <-time.After(waitInterval):
 		}
 
-		locked, err := m.tryLock()
+		value, token, err := m.tryLock()
 		if err != nil {
 			m.pluginAPI.LogError("failed to lock mutex", "err", err, "lock_key", m.key)
 			waitInterval = nextWaitInterval(waitInterval, err)
 			continue
-		} else if !locked {
+		} else if value == nil {
 			waitInterval = nextWaitInterval(waitInterval, err)
 			continue
 		}
 
-		stop := make(chan bool)
-		done := make(chan bool)
-		go func() {
-			defer close(done)
-			t := time.NewTicker(refreshInterval)
-			for {
-				select {
-				case <-t.C:
-					err := m.refreshLock()
-					if err != nil {
-						m.pluginAPI.LogError("failed to refresh mutex", "err", err, "lock_key", m.key)
-						return
-					}
-				case <-stop:
+		return m.startRefreshing(value, token), nil
+	}
+}
+
+// startRefreshing refreshes the lock with the given key value until stopped, returning a channel
+// closed if refreshing fails.
+func (m *Mutex) startRefreshing(value []byte, token int64) <-chan struct{} {
+	stop := make(chan bool)
+	done := make(chan bool)
+	lost := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(m.refreshInterval())
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				err := m.refreshLock(value)
+				if err != nil {
+					m.pluginAPI.LogError("failed to refresh mutex", "err", err, "lock_key", m.key)
+					close(lost)
 					return
 				}
+			case <-stop:
+				return
 			}
-		}()
+		}
+	}()
 
-		m.lock.Lock()
-		m.stopRefresh = stop
-		m.refreshDone = done
-		m.lock.Unlock()
+	m.lock.Lock()
+	m.stopRefresh = stop
+	m.refreshDone = done
+	m.value = value
+	m.token = token
+	m.lock.Unlock()
 
-		return nil
-	}
+	return lost
 }
 
 // Unlock unlocks m. It is a run-time error if m is not locked on entry to Unlock.
@@ -169,17 +443,34 @@ func (m *Mutex) LockWithContext(ctx context.Context) error {
 // for another goroutine or plugin instance to unlock it. In practice, ownership of the lock should
 // remain within a single plugin instance.
 func (m *Mutex) Unlock() {
+	value := m.stopRefreshing()
+
+	// Only delete the lock if still held, as it may have expired and been locked by another
+	// plugin instance. If an error occurs deleting, the mutex kv will still expire, allowing
+	// later retry.
+	_, _ = m.pluginAPI.KVSetWithOptions(m.key, nil, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: value,
+	})
+}
+
+// stopRefreshing stops refreshing the lock, without releasing it, returning the lock key value.
+// It is a run-time error if m is not locked.
+func (m *Mutex) stopRefreshing() []byte {
 	m.lock.Lock()
+	defer m.lock.Unlock()
+
 	if m.stopRefresh == nil {
-		m.lock.Unlock()
 		panic("mutex has not been acquired")
 	}
 
 	close(m.stopRefresh)
 	m.stopRefresh = nil
 	<-m.refreshDone
-	m.lock.Unlock()
 
-	// If an error occurs deleting, the mutex kv will still expire, allowing later retry.
-	_, _ = m.pluginAPI.KVSetWithOptions(m.key, nil, model.PluginKVSetOptions{})
+	value := m.value
+	m.value = nil
+	m.token = 0
+
+	return value
 }
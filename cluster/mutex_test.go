@@ -273,3 +273,179 @@ func TestMutex(t *testing.T) {
 		}
 	})
 }
+
+func TestNewMutexWithOptions(t *testing.T) {
+	t.Run("default ttl", func(t *testing.T) {
+		m, err := NewMutexWithOptions(newMockPluginAPI(t), "key", MutexOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, ttl, m.ttl)
+	})
+
+	t.Run("ttl rounded up", func(t *testing.T) {
+		m, err := NewMutexWithOptions(newMockPluginAPI(t), "key", MutexOptions{TTL: 2500 * time.Millisecond})
+		require.NoError(t, err)
+		assert.Equal(t, 3*time.Second, m.ttl)
+		assert.EqualValues(t, 3, m.expireInSeconds())
+	})
+
+	t.Run("ttl too short", func(t *testing.T) {
+		_, err := NewMutexWithOptions(newMockPluginAPI(t), "key", MutexOptions{TTL: time.Millisecond})
+		require.Error(t, err)
+	})
+}
+
+func TestMutexTryLock(t *testing.T) {
+	mockPluginAPI := newMockPluginAPI(t)
+	key := model.NewId()
+
+	m1 := mustNewMutex(mockPluginAPI, key)
+	m2 := mustNewMutex(mockPluginAPI, key)
+
+	require.True(t, m1.TryLock())
+	require.False(t, m1.TryLock())
+	require.False(t, m2.TryLock())
+
+	m1.Unlock()
+	require.True(t, m2.TryLock())
+	m2.Unlock()
+
+	mockPluginAPI.setFailing(true)
+	require.False(t, m1.TryLock())
+}
+
+func TestInspectMutex(t *testing.T) {
+	mockPluginAPI := newMockPluginAPI(t)
+	key := model.NewId()
+
+	owner, err := InspectMutex(mockPluginAPI, key)
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+
+	m, err := NewMutexWithOptions(mockPluginAPI, key, MutexOptions{Label: "export"})
+	require.NoError(t, err)
+	m.Lock()
+
+	owner, err = InspectMutex(mockPluginAPI, key)
+	require.NoError(t, err)
+	require.NotNil(t, owner)
+	assert.Equal(t, instanceID, owner.InstanceID)
+	assert.Equal(t, "export", owner.Label)
+	assert.WithinDuration(t, time.Now(), owner.AcquiredAt, time.Minute)
+	assert.Zero(t, owner.FencingToken)
+
+	m.Unlock()
+
+	owner, err = InspectMutex(mockPluginAPI, key)
+	require.NoError(t, err)
+	assert.Nil(t, owner)
+
+	t.Run("legacy value", func(t *testing.T) {
+		mockPluginAPI.keyValues[mutexPrefix+key] = []byte{1}
+
+		owner, err := InspectMutex(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.Equal(t, &MutexOwner{}, owner)
+	})
+
+	t.Run("empty key", func(t *testing.T) {
+		_, err := InspectMutex(mockPluginAPI, "")
+		require.Error(t, err)
+	})
+}
+
+func TestMutexFencingTokens(t *testing.T) {
+	mockPluginAPI := newMockPluginAPI(t)
+	key := model.NewId()
+
+	m1, err := NewMutexWithOptions(mockPluginAPI, key, MutexOptions{FencingTokens: true})
+	require.NoError(t, err)
+	m2, err := NewMutexWithOptions(mockPluginAPI, key, MutexOptions{FencingTokens: true})
+	require.NoError(t, err)
+
+	var last int64
+	for i := 0; i < 3; i++ {
+		for _, m := range []*Mutex{m1, m2} {
+			require.True(t, m.TryLock())
+
+			token := m.FencingToken()
+			assert.Greater(t, token, last)
+			last = token
+
+			owner, err := InspectMutex(mockPluginAPI, key)
+			require.NoError(t, err)
+			assert.Equal(t, token, owner.FencingToken)
+
+			m.Unlock()
+			assert.Zero(t, m.FencingToken())
+		}
+	}
+
+	t.Run("failure to assign token releases lock", func(t *testing.T) {
+		mockPluginAPI.setFailingWithPrefix(fencingTokenPrefix)
+		require.False(t, m1.TryLock())
+		mockPluginAPI.setFailingWithPrefix("")
+
+		owner, err := InspectMutex(mockPluginAPI, key)
+		require.NoError(t, err)
+		assert.Nil(t, owner)
+	})
+
+	t.Run("plugin API without KVGet", func(t *testing.T) {
+		pluginAPI := struct{ MutexPluginAPI }{mockPluginAPI}
+
+		_, err := NewMutexWithOptions(pluginAPI, key, MutexOptions{FencingTokens: true})
+		require.Error(t, err)
+
+		_, err = NewMutexWithOptions(pluginAPI, key, MutexOptions{})
+		require.NoError(t, err)
+	})
+}
+
+func TestMutexLockWithHeldContext(t *testing.T) {
+	t.Run("canceled on unlock", func(t *testing.T) {
+		m := mustNewMutex(newMockPluginAPI(t), model.NewId())
+
+		ctx, err := m.LockWithHeldContext(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, ctx.Err())
+
+		m.Unlock()
+		requireSignal(t, ctx.Done(), time.Second, "context should have been canceled")
+	})
+
+	t.Run("canceled when lost", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		m, err := NewMutexWithOptions(mockPluginAPI, key, MutexOptions{TTL: 2 * time.Second})
+		require.NoError(t, err)
+
+		ctx, err := m.LockWithHeldContext(context.Background())
+		require.NoError(t, err)
+
+		// Simulate the lock expiring and being acquired by another plugin instance.
+		_, appErr := mockPluginAPI.KVSetWithOptions(mutexPrefix+key, []byte("other"), model.PluginKVSetOptions{})
+		require.Nil(t, appErr)
+
+		requireSignal(t, ctx.Done(), 2*time.Second, "context should have been canceled")
+
+		// Unlocking leaves the other plugin instance's lock be.
+		m.Unlock()
+		value, appErr := mockPluginAPI.KVGet(mutexPrefix + key)
+		require.Nil(t, appErr)
+		assert.Equal(t, []byte("other"), value)
+	})
+
+	t.Run("failed lock", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		m := mustNewMutex(mockPluginAPI, model.NewId())
+		require.True(t, m.TryLock())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		heldCtx, err := m.LockWithHeldContext(ctx)
+		require.Error(t, err)
+		assert.Nil(t, heldCtx)
+	})
+}
@@ -0,0 +1,537 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// queueMessagePrefix is used to namespace the key values holding the messages of a queue.
+	queueMessagePrefix = "queue_m_"
+
+	// queueDeadLetterPrefix is used to namespace the key values holding the messages of a queue
+	// that failed too many times.
+	queueDeadLetterPrefix = "queue_d_"
+
+	// queueIndexPrefix is used to namespace the key values indexing the messages of a queue.
+	queueIndexPrefix = "queue_i_"
+
+	// queueDeadLetterIndexPrefix is used to namespace the key values indexing the dead letters
+	// of a queue.
+	queueDeadLetterIndexPrefix = "queue_di_"
+
+	// queueIndexShards is the number of key values the indexes of a queue are spread across.
+	queueIndexShards = 8
+
+	// queueIndexPruneAge is how long after being enqueued a message missing from the key-value
+	// store is pruned from the index. Messages are indexed just before being stored, so younger
+	// messages may still be being enqueued.
+	queueIndexPruneAge = time.Minute
+)
+
+// QueueConfig defines the configuration of a queue.
+type QueueConfig struct {
+	// Workers is the number of messages handled concurrently by each call to Consume. Defaults
+	// to 1.
+	Workers int
+
+	// VisibilityTimeout is how long a message is hidden from other consumers once received. If
+	// the message is not acknowledged by then, it is delivered again. Handlers are given a
+	// context cancelled when the visibility timeout expires. Defaults to one minute.
+	VisibilityTimeout time.Duration
+
+	// MaxAttempts is the number of times a message is delivered before being moved to the dead
+	// letters of the queue. Defaults to 5.
+	MaxAttempts int
+
+	// Backoff returns how long to wait before delivering a message again after the given number
+	// of failed attempts. Defaults to waiting a second, doubling after every attempt up to five
+	// minutes.
+	Backoff func(attempts int) time.Duration
+
+	// PollInterval is how long to wait before looking for new messages once the queue is empty.
+	// Defaults to five seconds.
+	PollInterval time.Duration
+}
+
+// QueueMessage is a message stored in a queue.
+type QueueMessage struct {
+	// ID identifies the message within the queue. IDs of messages enqueued later sort after
+	// those of messages enqueued earlier.
+	ID string
+
+	// Payload is the JSON representation of the enqueued payload.
+	Payload json.RawMessage
+
+	// EnqueuedAt is when the message was enqueued.
+	EnqueuedAt time.Time
+
+	// Attempts is the number of times the message was delivered.
+	Attempts int
+
+	// VisibleAt is when the message can next be delivered.
+	VisibleAt time.Time
+
+	// LastError is the error returned by the handler the last time the message was delivered,
+	// if any.
+	LastError string `json:",omitempty"`
+}
+
+// QueueStats reports the number of messages in a queue.
+type QueueStats struct {
+	// Ready is the number of messages waiting to be delivered.
+	Ready int
+
+	// InFlight is the number of messages delivered and being handled.
+	InFlight int
+
+	// Delayed is the number of messages waiting to be retried after failing.
+	Delayed int
+
+	// DeadLetters is the number of messages that failed too many times.
+	DeadLetters int
+}
+
+// Queue is a durable work queue shared by every plugin instance. Messages are delivered at least
+// once: a message is only removed once its handler succeeds, and is delivered again if its
+// handler fails or the plugin instance handling it stops.
+type Queue struct {
+	pluginAPI JobPluginAPI
+	name      string
+	config    QueueConfig
+
+	// messages and deadLetters list the IDs of the messages of the queue, so that consumers
+	// don't page through every key of the plugin. Messages are indexed before being stored, and
+	// removed from the index once deleted.
+	messages    *keyIndex
+	deadLetters *keyIndex
+}
+
+// NewQueue creates a queue with the given name.
+func NewQueue(pluginAPI JobPluginAPI, name string, config QueueConfig) (*Queue, error) {
+	if name == "" || strings.Contains(name, ":") {
+		return nil, errors.New("must specify valid queue name")
+	}
+
+	if config.Workers <= 0 {
+		config.Workers = 1
+	}
+	if config.VisibilityTimeout <= 0 {
+		config.VisibilityTimeout = time.Minute
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Backoff == nil {
+		config.Backoff = defaultQueueBackoff
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 5 * time.Second
+	}
+
+	return &Queue{
+		pluginAPI: pluginAPI,
+		name:      name,
+		config:    config,
+		messages: &keyIndex{
+			pluginAPI: pluginAPI,
+			prefix:    queueIndexPrefix + name + ":",
+			shards:    queueIndexShards,
+		},
+		deadLetters: &keyIndex{
+			pluginAPI: pluginAPI,
+			prefix:    queueDeadLetterIndexPrefix + name + ":",
+			shards:    queueIndexShards,
+		},
+	}, nil
+}
+
+func defaultQueueBackoff(attempts int) time.Duration {
+	wait := time.Second
+	for i := 1; i < attempts && wait < maxWaitInterval; i++ {
+		wait *= 2
+	}
+	if wait > maxWaitInterval {
+		wait = maxWaitInterval
+	}
+
+	return wait
+}
+
+func (q *Queue) messageKey(id string) string {
+	return queueMessagePrefix + q.name + ":" + id
+}
+
+func (q *Queue) deadLetterKey(id string) string {
+	return queueDeadLetterPrefix + q.name + ":" + id
+}
+
+// Enqueue adds a message with the given payload, which must be serializable to JSON, returning
+// the message ID.
+func (q *Queue) Enqueue(payload any) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal payload")
+	}
+
+	now := time.Now()
+	message := QueueMessage{
+		ID:         fmt.Sprintf("%016x%s", now.UnixNano(), model.NewId()[:8]),
+		Payload:    data,
+		EnqueuedAt: now,
+		VisibleAt:  now,
+	}
+
+	data, err = json.Marshal(message)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal message")
+	}
+
+	if err = q.messages.add(message.ID); err != nil {
+		return "", errors.Wrap(err, "failed to index message")
+	}
+
+	ok, appErr := q.pluginAPI.KVSetWithOptions(q.messageKey(message.ID), data, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: nil,
+	})
+	if appErr != nil {
+		return "", normalizeAppErr(appErr)
+	}
+	if !ok {
+		return "", errors.New("failed to set message")
+	}
+
+	return message.ID, nil
+}
+
+// Consume delivers messages to the given handler until the context is cancelled, then waits for
+// the handlers running to return. Messages are delivered roughly in the order they were
+// enqueued, to up to Workers handlers at a time.
+//
+// A message is acknowledged, and removed from the queue, when its handler returns nil.
+// Otherwise, it is delivered again after a backoff, until it fails MaxAttempts times and is
+// moved to the dead letters of the queue.
+func (q *Queue) Consume(ctx context.Context, handler func(ctx context.Context, message QueueMessage) error) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	workers := make(chan struct{}, q.config.Workers)
+	waitInterval := time.Duration(0)
+
+	// inFlight holds the IDs of the messages being handled, which are not visible until handled.
+	var inFlightMu sync.Mutex
+	inFlight := make(map[string]bool)
+	isInFlight := func(id string) bool {
+		inFlightMu.Lock()
+		defer inFlightMu.Unlock()
+		return inFlight[id]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(waitInterval):
+		}
+
+		ids, err := q.messages.keys()
+		if err != nil {
+			q.pluginAPI.LogError("failed to list queue messages", "err", err, "queue", q.name)
+			waitInterval = nextWaitInterval(waitInterval, err)
+			continue
+		}
+
+		delivered := 0
+		for _, id := range ids {
+			if isInFlight(id) {
+				continue
+			}
+
+			// Wait for a free worker before receiving the message, so that its visibility timeout
+			// is not spent waiting.
+			select {
+			case workers <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			message, data, err := q.receive(id)
+			if err != nil {
+				q.pluginAPI.LogError("failed to receive queue message", "err", err, "queue", q.name, "id", id)
+			}
+			if message == nil {
+				<-workers
+				continue
+			}
+
+			inFlightMu.Lock()
+			inFlight[id] = true
+			inFlightMu.Unlock()
+
+			delivered++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-workers }()
+				defer func() {
+					inFlightMu.Lock()
+					delete(inFlight, message.ID)
+					inFlightMu.Unlock()
+				}()
+
+				q.handle(ctx, handler, *message, data)
+			}()
+		}
+
+		// Look for more messages right away after delivering some, as more may be ready.
+		waitInterval = 0
+		if delivered == 0 {
+			waitInterval = q.config.PollInterval
+		}
+	}
+}
+
+// receive hides the message with the given ID from other consumers, returning nil if the message
+// is not visible or was received by another consumer. The returned data is the message as
+// stored, to acknowledge it atomically.
+func (q *Queue) receive(id string) (*QueueMessage, []byte, error) {
+	key := q.messageKey(id)
+	data, appErr := q.pluginAPI.KVGet(key)
+	if appErr != nil {
+		return nil, nil, errors.Wrap(normalizeAppErr(appErr), "failed to read message")
+	}
+	if data == nil {
+		return nil, nil, q.pruneMessage(id)
+	}
+
+	var message QueueMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode message")
+	}
+
+	now := time.Now()
+	if message.VisibleAt.After(now) {
+		return nil, nil, nil
+	}
+
+	// The last attempt was neither acknowledged nor failed before its visibility timeout.
+	if message.Attempts >= q.config.MaxAttempts {
+		message.LastError = "visibility timeout expired"
+		return nil, nil, q.deadLetter(key, data, message)
+	}
+
+	message.Attempts++
+	message.VisibleAt = now.Add(q.config.VisibilityTimeout)
+	message.LastError = ""
+
+	received, err := q.compareAndSet(key, data, &message)
+	if err != nil || received == nil {
+		return nil, nil, err
+	}
+
+	return &message, received, nil
+}
+
+// handle runs the handler, then acknowledges the message or schedules it to be retried.
+func (q *Queue) handle(ctx context.Context, handler func(ctx context.Context, message QueueMessage) error, message QueueMessage, data []byte) {
+	handlerCtx, cancel := context.WithDeadline(ctx, message.VisibleAt)
+	defer cancel()
+
+	key := q.messageKey(message.ID)
+
+	handlerErr := handler(handlerCtx, message)
+	if handlerErr == nil {
+		// If the message was received again after its visibility timeout, leave it be.
+		if err := q.delete(key, data, message.ID); err != nil {
+			q.pluginAPI.LogError("failed to acknowledge queue message", "err", err, "key", key)
+		}
+		return
+	}
+
+	message.LastError = handlerErr.Error()
+
+	if message.Attempts >= q.config.MaxAttempts {
+		if err := q.deadLetter(key, data, message); err != nil {
+			q.pluginAPI.LogError("failed to move queue message to dead letters", "err", err, "key", key)
+		}
+		return
+	}
+
+	message.VisibleAt = time.Now().Add(q.config.Backoff(message.Attempts))
+	if _, err := q.compareAndSet(key, data, &message); err != nil {
+		q.pluginAPI.LogError("failed to retry queue message", "err", err, "key", key)
+	}
+}
+
+// deadLetter moves a message that failed too many times out of the queue.
+func (q *Queue) deadLetter(key string, data []byte, message QueueMessage) error {
+	deadData, err := json.Marshal(message)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal message")
+	}
+
+	if err = q.deadLetters.add(message.ID); err != nil {
+		return errors.Wrap(err, "failed to index dead letter")
+	}
+
+	// Store the dead letter first, so that the message is never lost.
+	_, appErr := q.pluginAPI.KVSetWithOptions(q.deadLetterKey(message.ID), deadData, model.PluginKVSetOptions{})
+	if appErr != nil {
+		return normalizeAppErr(appErr)
+	}
+
+	return q.delete(key, data, message.ID)
+}
+
+// delete deletes the message stored at the given key if unchanged, removing it from the index.
+func (q *Queue) delete(key string, data []byte, id string) error {
+	deleted, err := q.compareAndSet(key, data, nil)
+	if err != nil || deleted == nil {
+		return err
+	}
+
+	return errors.Wrap(q.messages.remove(id), "failed to remove message from index")
+}
+
+// pruneMessage removes the message with the given ID, missing from the key-value store, from the
+// index, typically because the plugin instance deleting it stopped before removing it.
+func (q *Queue) pruneMessage(id string) error {
+	// IDs start with the time the message was enqueued, in hexadecimal nanoseconds.
+	if len(id) >= 16 {
+		enqueuedAt, err := strconv.ParseInt(id[:16], 16, 64)
+		if err == nil && time.Since(time.Unix(0, enqueuedAt)) < queueIndexPruneAge {
+			return nil
+		}
+	}
+
+	return errors.Wrap(q.messages.remove(id), "failed to remove message from index")
+}
+
+// compareAndSet replaces the message stored at the given key if unchanged, deleting it if
+// message is nil. It returns the data stored, or nil if the message had changed.
+func (q *Queue) compareAndSet(key string, oldData []byte, message *QueueMessage) ([]byte, error) {
+	var data []byte
+	if message != nil {
+		var err error
+		data, err = json.Marshal(message)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal message")
+		}
+	}
+
+	ok, appErr := q.pluginAPI.KVSetWithOptions(key, data, model.PluginKVSetOptions{
+		Atomic:   true,
+		OldValue: oldData,
+	})
+	if appErr != nil {
+		return nil, normalizeAppErr(appErr)
+	}
+	if !ok {
+		return nil, nil
+	}
+	if data == nil {
+		// Deleted messages have no stored data, but were still set.
+		return []byte{}, nil
+	}
+
+	return data, nil
+}
+
+// Stats returns the number of messages in the queue, by state.
+func (q *Queue) Stats() (QueueStats, error) {
+	var stats QueueStats
+
+	now := time.Now()
+	err := q.forEachMessage(q.messages, q.messageKey, func(message QueueMessage) {
+		switch {
+		case !message.VisibleAt.After(now):
+			stats.Ready++
+		case message.LastError != "":
+			stats.Delayed++
+		default:
+			stats.InFlight++
+		}
+	})
+	if err != nil {
+		return QueueStats{}, err
+	}
+
+	err = q.forEachMessage(q.deadLetters, q.deadLetterKey, func(QueueMessage) {
+		stats.DeadLetters++
+	})
+	if err != nil {
+		return QueueStats{}, err
+	}
+
+	return stats, nil
+}
+
+// Stuck returns the messages that were delivered but neither acknowledged nor failed before
+// their visibility timeout expired, typically because the plugin instance handling them stopped
+// or their handler took too long. They are delivered again as usual.
+func (q *Queue) Stuck() ([]QueueMessage, error) {
+	var messages []QueueMessage
+
+	now := time.Now()
+	err := q.forEachMessage(q.messages, q.messageKey, func(message QueueMessage) {
+		if message.Attempts > 0 && message.LastError == "" && !message.VisibleAt.After(now) {
+			messages = append(messages, message)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// DeadLetters returns the messages that failed MaxAttempts times, with the error they last
+// failed with.
+func (q *Queue) DeadLetters() ([]QueueMessage, error) {
+	var messages []QueueMessage
+	err := q.forEachMessage(q.deadLetters, q.deadLetterKey, func(message QueueMessage) {
+		messages = append(messages, message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// forEachMessage calls f with each message listed by the given index, stored at the key returned
+// by keyFunc for its ID.
+func (q *Queue) forEachMessage(index *keyIndex, keyFunc func(id string) string, f func(message QueueMessage)) error {
+	ids, err := index.keys()
+	if err != nil {
+		return errors.Wrap(err, "failed to list messages")
+	}
+
+	for _, id := range ids {
+		data, appErr := q.pluginAPI.KVGet(keyFunc(id))
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "failed to read message")
+		}
+		if data == nil {
+			continue
+		}
+
+		var message QueueMessage
+		if err = json.Unmarshal(data, &message); err != nil {
+			return errors.Wrap(err, "failed to decode message")
+		}
+
+		f(message)
+	}
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+func ExampleQueue() {
+	// Use p.API from your plugin instead.
+	pluginAPI := plugin.API(nil)
+
+	queue, err := NewQueue(pluginAPI, "notifications", QueueConfig{
+		Workers:           4,
+		VisibilityTimeout: time.Minute,
+	})
+	if err != nil {
+		panic("failed to create queue")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go queue.Consume(ctx, func(ctx context.Context, message QueueMessage) error {
+		var userID string
+		if err := json.Unmarshal(message.Payload, &userID); err != nil {
+			return err
+		}
+
+		// send the notification
+		return nil
+	})
+
+	// main thread
+
+	if _, err := queue.Enqueue("user_id"); err != nil {
+		panic("failed to enqueue message")
+	}
+
+	defer cancel()
+}
@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	t.Run("invalid name", func(t *testing.T) {
+		_, err := NewQueue(newMockPluginAPI(t), "", QueueConfig{})
+		require.Error(t, err)
+
+		_, err = NewQueue(newMockPluginAPI(t), "a:b", QueueConfig{})
+		require.Error(t, err)
+	})
+
+	t.Run("delivers messages in order and acknowledges them", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		q, err := NewQueue(mockPluginAPI, "notifications", QueueConfig{PollInterval: 10 * time.Millisecond})
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			_, err = q.Enqueue(i)
+			require.NoError(t, err)
+		}
+
+		stats, err := q.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, QueueStats{Ready: 5}, stats)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var lock sync.Mutex
+		var received []int
+		go q.Consume(ctx, func(ctx context.Context, message QueueMessage) error {
+			var payload int
+			require.NoError(t, json.Unmarshal(message.Payload, &payload))
+			assert.Equal(t, 1, message.Attempts)
+
+			lock.Lock()
+			received = append(received, payload)
+			lock.Unlock()
+			return nil
+		})
+
+		require.Eventually(t, func() bool {
+			stats, err = q.Stats()
+			return err == nil && stats == QueueStats{}
+		}, time.Second, 10*time.Millisecond)
+
+		lock.Lock()
+		assert.Equal(t, []int{0, 1, 2, 3, 4}, received)
+		lock.Unlock()
+
+		ids, err := q.messages.keys()
+		require.NoError(t, err)
+		assert.Empty(t, ids)
+	})
+
+	t.Run("prunes deleted messages from the index", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		q, err := NewQueue(mockPluginAPI, "exports", QueueConfig{})
+		require.NoError(t, err)
+
+		id, err := q.Enqueue("payload")
+		require.NoError(t, err)
+		appErr := mockPluginAPI.KVDelete(q.messageKey(id))
+		require.Nil(t, appErr)
+
+		// Recently enqueued messages may still be being stored.
+		message, _, err := q.receive(id)
+		require.NoError(t, err)
+		assert.Nil(t, message)
+		ids, err := q.messages.keys()
+		require.NoError(t, err)
+		assert.Equal(t, []string{id}, ids)
+
+		old := "0000000000000001" + id[16:]
+		require.NoError(t, q.messages.add(old))
+		message, _, err = q.receive(old)
+		require.NoError(t, err)
+		assert.Nil(t, message)
+		ids, err = q.messages.keys()
+		require.NoError(t, err)
+		assert.Equal(t, []string{id}, ids)
+	})
+
+	t.Run("spreads messages across consumers", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		config := QueueConfig{Workers: 4, PollInterval: 10 * time.Millisecond}
+
+		var lock sync.Mutex
+		received := make(map[string]int)
+		handler := func(ctx context.Context, message QueueMessage) error {
+			time.Sleep(time.Millisecond)
+			lock.Lock()
+			received[message.ID]++
+			lock.Unlock()
+			return nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			q, err := NewQueue(mockPluginAPI, "sync", config)
+			require.NoError(t, err)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				q.Consume(ctx, handler)
+			}()
+		}
+
+		q, err := NewQueue(mockPluginAPI, "sync", config)
+		require.NoError(t, err)
+		for i := 0; i < 50; i++ {
+			_, err = q.Enqueue(i)
+			require.NoError(t, err)
+		}
+
+		require.Eventually(t, func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(received) == 50
+		}, 5*time.Second, 10*time.Millisecond)
+
+		cancel()
+		wg.Wait()
+
+		for _, count := range received {
+			assert.Equal(t, 1, count)
+		}
+	})
+
+	t.Run("retries failed messages, then moves them to dead letters", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		q, err := NewQueue(mockPluginAPI, "webhooks", QueueConfig{
+			MaxAttempts:  3,
+			PollInterval: 10 * time.Millisecond,
+			Backoff: func(attempts int) time.Duration {
+				return time.Duration(attempts) * 10 * time.Millisecond
+			},
+		})
+		require.NoError(t, err)
+
+		id, err := q.Enqueue("payload")
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var lock sync.Mutex
+		var attempts []int
+		go q.Consume(ctx, func(ctx context.Context, message QueueMessage) error {
+			lock.Lock()
+			attempts = append(attempts, message.Attempts)
+			lock.Unlock()
+			return errors.New("unavailable")
+		})
+
+		require.Eventually(t, func() bool {
+			stats, statsErr := q.Stats()
+			return statsErr == nil && stats == QueueStats{DeadLetters: 1}
+		}, time.Second, 10*time.Millisecond)
+
+		lock.Lock()
+		assert.Equal(t, []int{1, 2, 3}, attempts)
+		lock.Unlock()
+
+		deadLetters, err := q.DeadLetters()
+		require.NoError(t, err)
+		require.Len(t, deadLetters, 1)
+		assert.Equal(t, id, deadLetters[0].ID)
+		assert.Equal(t, 3, deadLetters[0].Attempts)
+		assert.Equal(t, "unavailable", deadLetters[0].LastError)
+		assert.Equal(t, json.RawMessage(`"payload"`), deadLetters[0].Payload)
+	})
+
+	t.Run("redelivers stuck messages", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		q, err := NewQueue(mockPluginAPI, "exports", QueueConfig{
+			VisibilityTimeout: 50 * time.Millisecond,
+			PollInterval:      10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		id, err := q.Enqueue("payload")
+		require.NoError(t, err)
+
+		// Receive the message as if by a plugin instance that stops before handling it.
+		message, _, err := q.receive(id)
+		require.NoError(t, err)
+		require.NotNil(t, message)
+
+		stats, err := q.Stats()
+		require.NoError(t, err)
+		assert.Equal(t, QueueStats{InFlight: 1}, stats)
+
+		stuck, err := q.Stuck()
+		require.NoError(t, err)
+		assert.Empty(t, stuck)
+
+		time.Sleep(60 * time.Millisecond)
+
+		stuck, err = q.Stuck()
+		require.NoError(t, err)
+		require.Len(t, stuck, 1)
+		assert.Equal(t, id, stuck[0].ID)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		delivered := make(chan QueueMessage, 1)
+		go q.Consume(ctx, func(ctx context.Context, message QueueMessage) error {
+			delivered <- message
+			return nil
+		})
+
+		select {
+		case message := <-delivered:
+			assert.Equal(t, 2, message.Attempts)
+		case <-time.After(time.Second):
+			require.Fail(t, "message not redelivered")
+		}
+	})
+}
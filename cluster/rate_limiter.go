@@ -0,0 +1,478 @@
+package cluster
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// rateLimitPrefix is used to namespace key values created for a rate limiter.
+	rateLimitPrefix = "ratelimit_"
+
+	// rateLimitMaxAttempts is the number of times a rate limiter retries updating its state when
+	// it is modified concurrently.
+	rateLimitMaxAttempts = 10
+
+	// rateLimitRetryJitter is the range of the wait between attempts to update a rate limiter's
+	// state.
+	rateLimitRetryJitter = 10 * time.Millisecond
+)
+
+// RateLimiterPluginAPI is the plugin API interface required to rate limit.
+type RateLimiterPluginAPI interface {
+	KVGet(key string) ([]byte, *model.AppError)
+	KVSetWithOptions(key string, value []byte, options model.PluginKVSetOptions) (bool, *model.AppError)
+}
+
+// RateLimitAlgorithm determines how a rate limiter counts events.
+type RateLimitAlgorithm int
+
+const (
+	// TokenBucket allows bursts of up to Burst events, refilling Limit tokens every Period.
+	TokenBucket RateLimitAlgorithm = iota
+
+	// SlidingWindow allows at most Limit events in any window of length Period. The time of
+	// every event in the last Period is stored, so it is best suited to small limits.
+	SlidingWindow
+)
+
+// RateLimiterConfig defines the configuration of a rate limiter.
+type RateLimiterConfig struct {
+	// Algorithm determines how events are counted. Defaults to TokenBucket.
+	Algorithm RateLimitAlgorithm
+
+	// Limit is the number of events allowed every Period.
+	Limit int
+
+	// Period is the length of time over which Limit events are allowed.
+	Period time.Duration
+
+	// Burst is the maximum number of events allowed at once by a TokenBucket. Defaults to Limit.
+	Burst int
+
+	// Prefetch is the number of events Allow reserves at once, keeping the events not yet used
+	// in memory to save reading and writing the key-value store for every event. Events
+	// prefetched but not used within PrefetchTTL are lost, so prefetching lowers the effective
+	// limit when events are spread across many plugin instances. Defaults to 1, disabling
+	// prefetching.
+	Prefetch int
+
+	// PrefetchTTL is how long prefetched events can be used. Defaults to one second.
+	PrefetchTTL time.Duration
+}
+
+// RateLimiter limits the rate of events across every plugin instance, such as the calls to a
+// third-party API or the slash commands run by each user. Events are counted separately for each
+// key passed to its methods.
+type RateLimiter struct {
+	pluginAPI RateLimiterPluginAPI
+	name      string
+	config    RateLimiterConfig
+
+	// prefetchedLock guards the prefetched map and the users of its entries. Each entry has its
+	// own lock, held while prefetching, so that keys are prefetched independently.
+	prefetchedLock sync.Mutex
+	prefetched     map[string]*prefetchedEvents
+}
+
+type prefetchedEvents struct {
+	lock      sync.Mutex
+	count     int
+	expiresAt time.Time
+
+	// users is the number of calls using the entry, guarded by the rate limiter's
+	// prefetchedLock. The entry is removed once unused and no longer holding events.
+	users int
+}
+
+// rateLimitState is the state of a rate limiter for a key, as stored in the kv store.
+type rateLimitState struct {
+	// Tokens is the number of tokens in the bucket as of Updated. It is negative if tokens were
+	// reserved ahead of time.
+	Tokens  float64   `json:",omitempty"`
+	Updated time.Time `json:",omitempty"`
+
+	// Events are the times in unix nanoseconds of the events in the sliding window, sorted. They
+	// may be in the future if reserved ahead of time.
+	Events []int64 `json:",omitempty"`
+}
+
+// Reservation is a number of events reserved by a rate limiter, which may take place only after
+// the reservation's delay.
+type Reservation struct {
+	limiter *RateLimiter
+	key     string
+	delay   time.Duration
+
+	// events are the reserved event times of a SlidingWindow, or nil for a TokenBucket.
+	events []int64
+	count  int
+}
+
+// Delay returns how long to wait from the time of the reservation until the events may take
+// place.
+func (r *Reservation) Delay() time.Duration {
+	return r.delay
+}
+
+// Cancel releases the reserved events, allowing other events to take place instead.
+func (r *Reservation) Cancel() error {
+	return r.limiter.update(r.key, func(state *rateLimitState, now time.Time) bool {
+		if r.events == nil {
+			state.Tokens = math.Min(state.Tokens+float64(r.count), float64(r.limiter.config.Burst))
+			return true
+		}
+
+		for _, event := range r.events {
+			for i, existing := range state.Events {
+				if existing == event {
+					state.Events = append(state.Events[:i], state.Events[i+1:]...)
+					break
+				}
+			}
+		}
+		return true
+	})
+}
+
+// NewRateLimiter creates a rate limiter with the given name.
+func NewRateLimiter(pluginAPI RateLimiterPluginAPI, name string, config RateLimiterConfig) (*RateLimiter, error) {
+	if name == "" || strings.Contains(name, ":") {
+		return nil, errors.New("must specify valid rate limiter name")
+	}
+	if config.Limit <= 0 || config.Period <= 0 {
+		return nil, errors.New("must specify a positive limit and period")
+	}
+
+	if config.Burst <= 0 {
+		config.Burst = config.Limit
+	}
+	if config.Prefetch <= 0 {
+		config.Prefetch = 1
+	}
+	if config.PrefetchTTL <= 0 {
+		config.PrefetchTTL = time.Second
+	}
+
+	return &RateLimiter{
+		pluginAPI:  pluginAPI,
+		name:       name,
+		config:     config,
+		prefetched: make(map[string]*prefetchedEvents),
+	}, nil
+}
+
+// Allow reports whether an event may take place now, consuming it if so.
+func (r *RateLimiter) Allow(key string) (bool, error) {
+	if r.config.Prefetch > 1 {
+		return r.allowPrefetched(key)
+	}
+
+	reservation, err := r.reserve(key, 1, 0)
+	if err != nil {
+		return false, err
+	}
+
+	return reservation != nil, nil
+}
+
+// allowPrefetched allows an event from those prefetched, prefetching more if needed.
+func (r *RateLimiter) allowPrefetched(key string) (bool, error) {
+	prefetched := r.acquirePrefetched(key)
+	defer r.releasePrefetched(key, prefetched)
+
+	prefetched.lock.Lock()
+	defer prefetched.lock.Unlock()
+
+	now := time.Now()
+	if prefetched.count > 0 && now.Before(prefetched.expiresAt) {
+		prefetched.count--
+		return true, nil
+	}
+	prefetched.count = 0
+
+	// If fewer events than prefetched are allowed, fall back to a single event.
+	for _, count := range []int{r.config.Prefetch, 1} {
+		reservation, err := r.reserve(key, count, 0)
+		if err != nil {
+			return false, err
+		}
+		if reservation != nil {
+			prefetched.count = count - 1
+			prefetched.expiresAt = now.Add(r.config.PrefetchTTL)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// acquirePrefetched returns the prefetched events of the given key, creating them if needed.
+// Callers must release them once done.
+func (r *RateLimiter) acquirePrefetched(key string) *prefetchedEvents {
+	r.prefetchedLock.Lock()
+	defer r.prefetchedLock.Unlock()
+
+	prefetched := r.prefetched[key]
+	if prefetched == nil {
+		prefetched = &prefetchedEvents{}
+		r.prefetched[key] = prefetched
+	}
+	prefetched.users++
+
+	return prefetched
+}
+
+// releasePrefetched releases the prefetched events of the given key, removing them if unused
+// and no longer holding events.
+func (r *RateLimiter) releasePrefetched(key string, prefetched *prefetchedEvents) {
+	r.prefetchedLock.Lock()
+	defer r.prefetchedLock.Unlock()
+
+	prefetched.users--
+	if prefetched.users == 0 && (prefetched.count == 0 || !time.Now().Before(prefetched.expiresAt)) {
+		delete(r.prefetched, key)
+	}
+}
+
+// Reserve reserves an event, returning a reservation whose delay is how long to wait before the
+// event may take place. Reserving always succeeds, so callers not willing to wait must cancel
+// the reservation.
+func (r *RateLimiter) Reserve(key string) (*Reservation, error) {
+	return r.reserve(key, 1, time.Duration(math.MaxInt64))
+}
+
+// Wait blocks until an event may take place, or the context is done. If the context would be
+// done before the event may take place, Wait returns an error immediately.
+func (r *RateLimiter) Wait(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	maxDelay := time.Duration(math.MaxInt64)
+	if deadline, ok := ctx.Deadline(); ok {
+		maxDelay = time.Until(deadline)
+	}
+
+	reservation, err := r.reserve(key, 1, maxDelay)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return errors.New("rate limit would exceed context deadline")
+	}
+	if reservation.delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(reservation.delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		if err := reservation.Cancel(); err != nil {
+			return errors.Wrap(err, "failed to cancel reservation")
+		}
+		return ctx.Err()
+	}
+}
+
+// reserve reserves the given number of events, unless they could not take place within
+// maxDelay, in which case it returns nil.
+func (r *RateLimiter) reserve(key string, count int, maxDelay time.Duration) (*Reservation, error) {
+	var reservation *Reservation
+
+	err := r.update(key, func(state *rateLimitState, now time.Time) bool {
+		reservation = nil
+
+		var delay time.Duration
+		var events []int64
+		switch r.config.Algorithm {
+		case SlidingWindow:
+			delay, events = r.reserveWindow(state, now, count)
+		default:
+			delay = r.reserveTokens(state, count)
+		}
+		if delay > maxDelay {
+			return false
+		}
+
+		reservation = &Reservation{
+			limiter: r,
+			key:     key,
+			delay:   delay,
+			events:  events,
+			count:   count,
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// reserveTokens takes the given number of tokens from the bucket, returning how long until they
+// are refilled if the bucket did not hold enough.
+func (r *RateLimiter) reserveTokens(state *rateLimitState, count int) time.Duration {
+	state.Tokens -= float64(count)
+	if state.Tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-state.Tokens / r.tokensPerNanosecond())
+}
+
+// reserveWindow adds the given number of events to the sliding window, each at the earliest
+// time no more than Limit events take place in any window, returning how long until the last
+// one may take place.
+func (r *RateLimiter) reserveWindow(state *rateLimitState, now time.Time, count int) (time.Duration, []int64) {
+	events := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		at := now.UnixNano()
+		if n := len(state.Events); n >= r.config.Limit {
+			if earliest := state.Events[n-r.config.Limit] + int64(r.config.Period); earliest > at {
+				at = earliest
+			}
+		}
+
+		// Reserved events may remain after earlier ones were cancelled, and the clocks of plugin
+		// instances may differ, so the event is inserted in order rather than appended.
+		j := sort.Search(len(state.Events), func(j int) bool {
+			return state.Events[j] > at
+		})
+		state.Events = append(state.Events, 0)
+		copy(state.Events[j+1:], state.Events[j:])
+		state.Events[j] = at
+		events = append(events, at)
+	}
+
+	return time.Duration(events[len(events)-1] - now.UnixNano()), events
+}
+
+func (r *RateLimiter) tokensPerNanosecond() float64 {
+	return float64(r.config.Limit) / float64(r.config.Period)
+}
+
+// update applies f to the state of the given key, retrying if the state is modified
+// concurrently. f returns false to leave the state unchanged.
+func (r *RateLimiter) update(key string, f func(state *rateLimitState, now time.Time) bool) error {
+	kvKey := r.stateKey(key)
+
+	for attempt := 0; attempt < rateLimitMaxAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(rand.Int63n(int64(rateLimitRetryJitter))))
+		}
+
+		data, appErr := r.pluginAPI.KVGet(kvKey)
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "failed to read rate limit")
+		}
+
+		var state rateLimitState
+		if data != nil {
+			if err := json.Unmarshal(data, &state); err != nil {
+				return errors.Wrap(err, "failed to decode rate limit")
+			}
+		}
+
+		now := time.Now()
+		r.refresh(&state, now)
+		if !f(&state, now) {
+			return nil
+		}
+		expiry := r.expiry(&state, now)
+
+		newData, err := json.Marshal(state)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal rate limit")
+		}
+
+		ok, appErr := r.pluginAPI.KVSetWithOptions(kvKey, newData, model.PluginKVSetOptions{
+			Atomic:          true,
+			OldValue:        data,
+			ExpireInSeconds: int64(math.Ceil(expiry.Seconds())),
+		})
+		if appErr != nil {
+			return errors.Wrap(normalizeAppErr(appErr), "failed to write rate limit")
+		}
+		if ok {
+			return nil
+		}
+	}
+
+	return errors.New("failed to update rate limit due to concurrent updates")
+}
+
+// refresh brings the state up to date as of now, refilling tokens or dropping events that left
+// the sliding window.
+func (r *RateLimiter) refresh(state *rateLimitState, now time.Time) {
+	switch r.config.Algorithm {
+	case SlidingWindow:
+		windowStart := now.Add(-r.config.Period).UnixNano()
+		i := sort.Search(len(state.Events), func(i int) bool {
+			return state.Events[i] > windowStart
+		})
+		state.Events = state.Events[i:]
+	default:
+		if state.Updated.IsZero() {
+			state.Tokens = float64(r.config.Burst)
+		} else if elapsed := now.Sub(state.Updated); elapsed > 0 {
+			state.Tokens += float64(elapsed) * r.tokensPerNanosecond()
+		}
+		if state.Tokens > float64(r.config.Burst) {
+			state.Tokens = float64(r.config.Burst)
+		}
+		state.Updated = now
+	}
+}
+
+// expiry returns how long until the state is equivalent to no state at all, so that it can be
+// expired from the kv store.
+func (r *RateLimiter) expiry(state *rateLimitState, now time.Time) time.Duration {
+	var expiry time.Duration
+	switch r.config.Algorithm {
+	case SlidingWindow:
+		if n := len(state.Events); n > 0 {
+			expiry = time.Duration(state.Events[n-1]-now.UnixNano()) + r.config.Period
+		}
+	default:
+		if missing := float64(r.config.Burst) - state.Tokens; missing > 0 {
+			expiry = time.Duration(missing / r.tokensPerNanosecond())
+		}
+	}
+
+	// Never expire immediately, as zero disables expiry.
+	if expiry < time.Second {
+		expiry = time.Second
+	}
+
+	return expiry
+}
+
+// stateKey returns the kv store key holding the state of the given key, hashing keys too long to
+// be stored.
+func (r *RateLimiter) stateKey(key string) string {
+	kvKey := rateLimitPrefix + r.name + ":" + key
+	if utf8.RuneCountInString(kvKey) <= model.KeyValueKeyMaxRunes {
+		return kvKey
+	}
+
+	hash := sha256.Sum256([]byte(key))
+	return rateLimitPrefix + r.name + ":" + hex.EncodeToString(hash[:])
+}
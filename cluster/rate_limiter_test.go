@@ -0,0 +1,246 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := NewRateLimiter(newMockPluginAPI(t), "", RateLimiterConfig{Limit: 1, Period: time.Second})
+		require.Error(t, err)
+
+		_, err = NewRateLimiter(newMockPluginAPI(t), "api", RateLimiterConfig{Period: time.Second})
+		require.Error(t, err)
+	})
+
+	t.Run("token bucket", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		config := RateLimiterConfig{Limit: 10, Period: time.Second}
+
+		// Limiters with the same name share their limits, as if on different plugin instances.
+		limiter1, err := NewRateLimiter(mockPluginAPI, "api", config)
+		require.NoError(t, err)
+		limiter2, err := NewRateLimiter(mockPluginAPI, "api", config)
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			allowed, allowErr := limiter1.Allow("user1")
+			require.NoError(t, allowErr)
+			assert.True(t, allowed)
+
+			allowed, allowErr = limiter2.Allow("user1")
+			require.NoError(t, allowErr)
+			assert.True(t, allowed)
+		}
+
+		allowed, err := limiter1.Allow("user1")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+
+		allowed, err = limiter1.Allow("user2")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+
+		reservation, err := limiter1.Reserve("user1")
+		require.NoError(t, err)
+		assert.Greater(t, reservation.Delay(), 50*time.Millisecond)
+		assert.LessOrEqual(t, reservation.Delay(), 100*time.Millisecond)
+
+		// The next token is reserved, so waiting for one takes twice as long.
+		reservation2, err := limiter1.Reserve("user1")
+		require.NoError(t, err)
+		assert.Greater(t, reservation2.Delay(), 150*time.Millisecond)
+
+		require.NoError(t, reservation.Cancel())
+		require.NoError(t, reservation2.Cancel())
+
+		start := time.Now()
+		require.NoError(t, limiter2.Wait(context.Background(), "user1"))
+		assert.Greater(t, time.Since(start), 20*time.Millisecond)
+	})
+
+	t.Run("sliding window", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		limiter, err := NewRateLimiter(mockPluginAPI, "commands", RateLimiterConfig{
+			Algorithm: SlidingWindow,
+			Limit:     3,
+			Period:    200 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			allowed, allowErr := limiter.Allow("user1")
+			require.NoError(t, allowErr)
+			assert.True(t, allowed)
+		}
+
+		allowed, err := limiter.Allow("user1")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err = limiter.Wait(ctx, "user1")
+		require.Error(t, err)
+		assert.Less(t, time.Since(start), 50*time.Millisecond)
+
+		require.NoError(t, limiter.Wait(context.Background(), "user1"))
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+		// Only the events in the last window are stored.
+		time.Sleep(100 * time.Millisecond)
+		allowed, err = limiter.Allow("user1")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+
+		var state rateLimitState
+		data, _ := mockPluginAPI.KVGet(limiter.stateKey("user1"))
+		require.NoError(t, json.Unmarshal(data, &state))
+		assert.Len(t, state.Events, 2)
+	})
+
+	t.Run("sliding window events stay sorted after cancelling", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		limiter, err := NewRateLimiter(mockPluginAPI, "commands", RateLimiterConfig{
+			Algorithm: SlidingWindow,
+			Limit:     2,
+			Period:    time.Hour,
+		})
+		require.NoError(t, err)
+
+		var reservations []*Reservation
+		for i := 0; i < 5; i++ {
+			reservation, reserveErr := limiter.Reserve("user1")
+			require.NoError(t, reserveErr)
+			reservations = append(reservations, reservation)
+		}
+		assert.Greater(t, reservations[4].Delay(), 119*time.Minute)
+
+		require.NoError(t, reservations[2].Cancel())
+		require.NoError(t, reservations[3].Cancel())
+
+		// The freed window is reserved before the event reserved last.
+		reservation, err := limiter.Reserve("user1")
+		require.NoError(t, err)
+		assert.Greater(t, reservation.Delay(), 59*time.Minute)
+		assert.LessOrEqual(t, reservation.Delay(), time.Hour)
+
+		var state rateLimitState
+		data, _ := mockPluginAPI.KVGet(limiter.stateKey("user1"))
+		require.NoError(t, json.Unmarshal(data, &state))
+		require.Len(t, state.Events, 4)
+		assert.IsNonDecreasing(t, state.Events)
+	})
+
+	t.Run("wait is cancelled with its context", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		limiter, err := NewRateLimiter(mockPluginAPI, "api", RateLimiterConfig{Limit: 1, Period: time.Second})
+		require.NoError(t, err)
+
+		allowed, err := limiter.Allow("key")
+		require.NoError(t, err)
+		require.True(t, allowed)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+		err = limiter.Wait(ctx, "key")
+		require.Equal(t, context.Canceled, err)
+
+		// The cancelled reservation was released.
+		var state rateLimitState
+		data, _ := mockPluginAPI.KVGet(limiter.stateKey("key"))
+		require.NoError(t, json.Unmarshal(data, &state))
+		assert.Greater(t, state.Tokens, -0.5)
+	})
+
+	t.Run("prefetching", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		limiter, err := NewRateLimiter(mockPluginAPI, "api", RateLimiterConfig{
+			Limit:    7,
+			Period:   time.Hour,
+			Prefetch: 5,
+		})
+		require.NoError(t, err)
+
+		stateTokens := func() float64 {
+			var state rateLimitState
+			data, _ := mockPluginAPI.KVGet(limiter.stateKey("key"))
+			require.NoError(t, json.Unmarshal(data, &state))
+			return state.Tokens
+		}
+
+		allowed, err := limiter.Allow("key")
+		require.NoError(t, err)
+		assert.True(t, allowed)
+		assert.InDelta(t, 2, stateTokens(), 0.01)
+
+		for i := 0; i < 4; i++ {
+			allowed, err = limiter.Allow("key")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		}
+		assert.InDelta(t, 2, stateTokens(), 0.01)
+
+		// Fewer events than prefetched remain, so they are taken one at a time.
+		for i := 0; i < 2; i++ {
+			allowed, err = limiter.Allow("key")
+			require.NoError(t, err)
+			assert.True(t, allowed)
+		}
+		allowed, err = limiter.Allow("key")
+		require.NoError(t, err)
+		assert.False(t, allowed)
+	})
+
+	t.Run("prefetching concurrently", func(t *testing.T) {
+		mockPluginAPI := newMockPluginAPI(t)
+		limiter, err := NewRateLimiter(mockPluginAPI, "api", RateLimiterConfig{
+			Limit:    7,
+			Period:   time.Hour,
+			Prefetch: 5,
+		})
+		require.NoError(t, err)
+
+		keys := []string{"key1", "key2", "key3"}
+		var allowedLock sync.Mutex
+		allowed := make(map[string]int)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			for _, key := range keys {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+					ok, err := limiter.Allow(key)
+					require.NoError(t, err)
+					if ok {
+						allowedLock.Lock()
+						allowed[key]++
+						allowedLock.Unlock()
+					}
+				}(key)
+			}
+		}
+		wg.Wait()
+
+		for _, key := range keys {
+			assert.Equal(t, 7, allowed[key], key)
+		}
+
+		// Keys without prefetched events left are not kept in memory.
+		limiter.prefetchedLock.Lock()
+		defer limiter.prefetchedLock.Unlock()
+		assert.Empty(t, limiter.prefetched)
+	})
+}
@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// rwMutexPrefix is used to namespace key values created for a read/write mutex from other key
+	// values created by a plugin.
+	rwMutexPrefix = "rwmutex_"
+)
+
+// RWMutexPluginAPI is the plugin API interface required to manage read/write mutexes.
+type RWMutexPluginAPI interface {
+	MutexReaderPluginAPI
+}
+
+// RWMutex is similar to sync.RWMutex, except usable by multiple plugin instances across a
+// cluster. The lock can be held by an arbitrary number of readers or a single writer.
+//
+// A writer waiting for the lock prevents new readers from acquiring it, so that a steady stream
+// of readers cannot starve writers. Readers or writers whose plugin instance died without
+// unlocking expire just like a locked Mutex.
+//
+// RWMutexes with different names are unrelated, including to Mutexes with the same name. Pick a
+// unique name for each read/write mutex your plugin requires.
+//
+// An RWMutex must not be copied after first use.
+type RWMutex struct {
+	leases leases
+
+	// lock guards the held leases, and is not itself related to the cluster-wide lock.
+	lock    sync.Mutex
+	writer  *lease
+	readers []*lease
+}
+
+// NewRWMutex creates a read/write mutex with the given key name.
+func NewRWMutex(pluginAPI RWMutexPluginAPI, key string) (*RWMutex, error) {
+	if key == "" {
+		return nil, errors.New("must specify valid mutex key")
+	}
+
+	return &RWMutex{
+		leases: leases{
+			pluginAPI: pluginAPI,
+			key:       rwMutexPrefix + key,
+		},
+	}, nil
+}
+
+// Lock locks rw for writing. If the lock is already locked for reading or writing by any plugin
+// instance, including the current one, the calling goroutine blocks until the lock can be locked.
+func (rw *RWMutex) Lock() {
+	_ = rw.LockWithContext(context.Background())
+}
+
+// LockWithContext locks rw for writing unless the context is canceled. If the lock is already
+// locked for reading or writing by any plugin instance, including the current one, the calling
+// goroutine blocks until the lock can be locked, or the context is canceled.
+//
+// The lock is locked only if a nil error is returned.
+func (rw *RWMutex) LockWithContext(ctx context.Context) error {
+	_, err := rw.lockWithContext(ctx)
+	return err
+}
+
+// LockWithHeldContext locks rw for writing unless the context is canceled, just like
+// LockWithContext. The returned context is derived from ctx, and is canceled once rw is unlocked
+// or as soon as refreshing the lock fails, after which the lock may be acquired by others.
+//
+// The lock is locked only if a nil error is returned.
+func (rw *RWMutex) LockWithHeldContext(ctx context.Context) (context.Context, error) {
+	held, err := rw.lockWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return held.heldContext(ctx), nil
+}
+
+func (rw *RWMutex) lockWithContext(ctx context.Context) (*lease, error) {
+	held, err := rw.leases.acquire(ctx, true, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	rw.lock.Lock()
+	rw.writer = held
+	rw.lock.Unlock()
+
+	return held, nil
+}
+
+// Unlock unlocks rw for writing. It is a run-time error if rw is not locked for writing on entry
+// to Unlock.
+func (rw *RWMutex) Unlock() {
+	rw.lock.Lock()
+	held := rw.writer
+	rw.writer = nil
+	rw.lock.Unlock()
+
+	if held == nil {
+		panic("rwmutex has not been locked for writing")
+	}
+
+	held.release()
+}
+
+// RLock locks rw for reading. If the lock is already locked for writing, or a writer is waiting
+// to lock it, the calling goroutine blocks until the lock can be locked for reading.
+func (rw *RWMutex) RLock() {
+	_ = rw.RLockWithContext(context.Background())
+}
+
+// RLockWithContext locks rw for reading unless the context is canceled. If the lock is already
+// locked for writing, or a writer is waiting to lock it, the calling goroutine blocks until the
+// lock can be locked for reading, or the context is canceled.
+//
+// The lock is locked only if a nil error is returned.
+func (rw *RWMutex) RLockWithContext(ctx context.Context) error {
+	_, err := rw.rLockWithContext(ctx)
+	return err
+}
+
+// RLockWithHeldContext locks rw for reading unless the context is canceled, just like
+// RLockWithContext. The returned context is derived from ctx, and is canceled once RUnlock
+// releases this read lock or as soon as refreshing it fails, after which a writer may acquire
+// the lock.
+//
+// The lock is locked only if a nil error is returned.
+func (rw *RWMutex) RLockWithHeldContext(ctx context.Context) (context.Context, error) {
+	held, err := rw.rLockWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return held.heldContext(ctx), nil
+}
+
+func (rw *RWMutex) rLockWithContext(ctx context.Context) (*lease, error) {
+	held, err := rw.leases.acquire(ctx, false, maxLeaseHolders)
+	if err != nil {
+		return nil, err
+	}
+
+	rw.lock.Lock()
+	rw.readers = append(rw.readers, held)
+	rw.lock.Unlock()
+
+	return held, nil
+}
+
+// RUnlock undoes a single RLock call. It is a run-time error if rw is not locked for reading on
+// entry to RUnlock.
+func (rw *RWMutex) RUnlock() {
+	rw.lock.Lock()
+	if len(rw.readers) == 0 {
+		rw.lock.Unlock()
+		panic("rwmutex has not been locked for reading")
+	}
+	held := rw.readers[len(rw.readers)-1]
+	rw.readers = rw.readers[:len(rw.readers)-1]
+	rw.lock.Unlock()
+
+	held.release()
+}
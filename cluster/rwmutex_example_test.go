@@ -0,0 +1,25 @@
+package cluster_test
+
+import (
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+func ExampleRWMutex() {
+	// Use p.API from your plugin instead.
+	pluginAPI := plugin.API(nil)
+
+	rw, err := cluster.NewRWMutex(pluginAPI, "key")
+	if err != nil {
+		panic(err)
+	}
+
+	rw.RLock()
+	// read
+	rw.RUnlock()
+
+	rw.Lock()
+	// write
+	rw.Unlock()
+}
@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustNewRWMutex(pluginAPI RWMutexPluginAPI, key string) *RWMutex {
+	rw, err := NewRWMutex(pluginAPI, key)
+	if err != nil {
+		panic(err)
+	}
+
+	return rw
+}
+
+func TestRWMutex(t *testing.T) {
+	t.Parallel()
+
+	t.Run("empty key", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := NewRWMutex(newMockPluginAPI(t), "")
+		assert.Error(t, err)
+	})
+
+	t.Run("unlock when not locked", func(t *testing.T) {
+		t.Parallel()
+
+		rw := mustNewRWMutex(newMockPluginAPI(t), model.NewId())
+		assert.Panics(t, rw.Unlock)
+		assert.Panics(t, rw.RUnlock)
+	})
+
+	t.Run("multiple readers", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		rw1 := mustNewRWMutex(mockPluginAPI, key)
+		rw2 := mustNewRWMutex(mockPluginAPI, key)
+
+		requireSignal(t, acquireAsync(rw1.RLock), time.Second, "failed to lock first reader")
+		requireSignal(t, acquireAsync(rw2.RLock), time.Second, "failed to lock second reader")
+		requireSignal(t, acquireAsync(rw2.RLock), time.Second, "failed to lock third reader")
+
+		rw1.RUnlock()
+		rw2.RUnlock()
+		rw2.RUnlock()
+		assert.Empty(t, mockPluginAPI.keyValues)
+	})
+
+	t.Run("writer excludes readers and writers", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		rw1 := mustNewRWMutex(mockPluginAPI, key)
+		rw2 := mustNewRWMutex(mockPluginAPI, key)
+
+		requireSignal(t, acquireAsync(rw1.Lock), time.Second, "failed to lock writer")
+
+		reader := acquireAsync(rw2.RLock)
+		writer := acquireAsync(rw2.Lock)
+		requireNoSignal(t, reader, time.Second, "reader should not have locked")
+		requireNoSignal(t, writer, 0, "writer should not have locked")
+
+		rw1.Unlock()
+
+		select {
+		case <-reader:
+			rw2.RUnlock()
+			requireSignal(t, writer, pollWaitInterval*3, "writer should have locked")
+			rw2.Unlock()
+		case <-writer:
+			rw2.Unlock()
+			requireSignal(t, reader, pollWaitInterval*3, "reader should have locked")
+			rw2.RUnlock()
+		case <-time.After(pollWaitInterval * 3):
+			require.Fail(t, "reader or writer should have locked")
+		}
+	})
+
+	t.Run("waiting writer blocks new readers", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		rw1 := mustNewRWMutex(mockPluginAPI, key)
+		rw2 := mustNewRWMutex(mockPluginAPI, key)
+		rw3 := mustNewRWMutex(mockPluginAPI, key)
+
+		requireSignal(t, acquireAsync(rw1.RLock), time.Second, "failed to lock reader")
+
+		writer := acquireAsync(rw2.Lock)
+		requireNoSignal(t, writer, time.Second, "writer should not have locked")
+
+		reader := acquireAsync(rw3.RLock)
+		requireNoSignal(t, reader, time.Second, "reader should not have locked")
+
+		rw1.RUnlock()
+		requireSignal(t, writer, pollWaitInterval*2, "writer should have locked")
+		requireNoSignal(t, reader, 0, "reader should not have locked")
+
+		rw2.Unlock()
+		requireSignal(t, reader, pollWaitInterval*2, "reader should have locked")
+		rw3.RUnlock()
+	})
+
+	t.Run("cancelled writer withdraws", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		rw1 := mustNewRWMutex(mockPluginAPI, key)
+		rw2 := mustNewRWMutex(mockPluginAPI, key)
+
+		require.NoError(t, rw1.RLockWithContext(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		require.ErrorIs(t, rw2.LockWithContext(ctx), context.DeadlineExceeded)
+
+		requireSignal(t, acquireAsync(rw2.RLock), time.Second, "reader should have locked")
+		rw1.RUnlock()
+		rw2.RUnlock()
+	})
+
+	t.Run("held contexts canceled on unlock", func(t *testing.T) {
+		t.Parallel()
+
+		rw := mustNewRWMutex(newMockPluginAPI(t), model.NewId())
+
+		ctx, err := rw.RLockWithHeldContext(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, ctx.Err())
+
+		rw.RUnlock()
+		requireSignal(t, ctx.Done(), time.Second, "read context should have been canceled")
+
+		ctx, err = rw.LockWithHeldContext(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, ctx.Err())
+
+		rw.Unlock()
+		requireSignal(t, ctx.Done(), time.Second, "write context should have been canceled")
+	})
+}
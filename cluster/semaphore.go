@@ -0,0 +1,116 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// semaphorePrefix is used to namespace key values created for a semaphore from other key
+	// values created by a plugin.
+	semaphorePrefix = "semaphore_"
+)
+
+// SemaphorePluginAPI is the plugin API interface required to manage semaphores.
+type SemaphorePluginAPI interface {
+	MutexReaderPluginAPI
+}
+
+// Semaphore limits the number of concurrent holders across all plugin instances in a cluster,
+// such as to run at most a few expensive operations at once.
+//
+// Holders whose plugin instance died without releasing the semaphore expire just like a locked
+// Mutex.
+//
+// Semaphores with different names are unrelated. All plugin instances must use the same size for
+// a given name. Pick a unique name for each semaphore your plugin requires.
+//
+// A Semaphore must not be copied after first use.
+type Semaphore struct {
+	leases leases
+	size   int
+
+	// lock guards the held leases, and is not itself related to the cluster-wide semaphore.
+	lock sync.Mutex
+	held []*lease
+}
+
+// NewSemaphore creates a semaphore with the given key name, allowing up to size concurrent
+// holders.
+func NewSemaphore(pluginAPI SemaphorePluginAPI, key string, size int) (*Semaphore, error) {
+	if key == "" {
+		return nil, errors.New("must specify valid semaphore key")
+	}
+	if size <= 0 || size > maxLeaseHolders {
+		return nil, errors.Errorf("semaphore size must be between 1 and %d", maxLeaseHolders)
+	}
+
+	return &Semaphore{
+		leases: leases{
+			pluginAPI: pluginAPI,
+			key:       semaphorePrefix + key,
+		},
+		size: size,
+	}, nil
+}
+
+// Acquire acquires s. If s already has as many holders as its size across all plugin instances,
+// including the current one, the calling goroutine blocks until one of them releases it.
+func (s *Semaphore) Acquire() {
+	_ = s.AcquireWithContext(context.Background())
+}
+
+// AcquireWithContext acquires s unless the context is canceled. If s already has as many holders
+// as its size across all plugin instances, including the current one, the calling goroutine
+// blocks until one of them releases it, or the context is canceled.
+//
+// The semaphore is acquired only if a nil error is returned.
+func (s *Semaphore) AcquireWithContext(ctx context.Context) error {
+	_, err := s.acquireWithContext(ctx)
+	return err
+}
+
+// AcquireWithHeldContext acquires s unless the context is canceled, just like
+// AcquireWithContext. The returned context is derived from ctx, and is canceled once the
+// acquisition is released or as soon as refreshing it fails, after which another holder may take
+// its place.
+//
+// The semaphore is acquired only if a nil error is returned.
+func (s *Semaphore) AcquireWithHeldContext(ctx context.Context) (context.Context, error) {
+	held, err := s.acquireWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return held.heldContext(ctx), nil
+}
+
+func (s *Semaphore) acquireWithContext(ctx context.Context) (*lease, error) {
+	held, err := s.leases.acquire(ctx, false, s.size)
+	if err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	s.held = append(s.held, held)
+	s.lock.Unlock()
+
+	return held, nil
+}
+
+// Release undoes a single Acquire call. It is a run-time error if s has not been acquired on
+// entry to Release.
+func (s *Semaphore) Release() {
+	s.lock.Lock()
+	if len(s.held) == 0 {
+		s.lock.Unlock()
+		panic("semaphore has not been acquired")
+	}
+	held := s.held[len(s.held)-1]
+	s.held = s.held[:len(s.held)-1]
+	s.lock.Unlock()
+
+	held.release()
+}
@@ -0,0 +1,21 @@
+package cluster_test
+
+import (
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+
+	"github.com/mattermost/mattermost-server/v6/plugin"
+)
+
+func ExampleSemaphore() {
+	// Use p.API from your plugin instead.
+	pluginAPI := plugin.API(nil)
+
+	// Allow at most 3 concurrent exports across the cluster.
+	s, err := cluster.NewSemaphore(pluginAPI, "exports", 3)
+	if err != nil {
+		panic(err)
+	}
+	s.Acquire()
+	// export
+	s.Release()
+}
@@ -0,0 +1,166 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustNewSemaphore(pluginAPI SemaphorePluginAPI, key string, size int) *Semaphore {
+	s, err := NewSemaphore(pluginAPI, key, size)
+	if err != nil {
+		panic(err)
+	}
+
+	return s
+}
+
+// acquireAsync calls f in a goroutine, returning a channel closed once it returns.
+func acquireAsync(f func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	return done
+}
+
+func TestNewSemaphore(t *testing.T) {
+	_, err := NewSemaphore(newMockPluginAPI(t), "", 1)
+	assert.Error(t, err)
+
+	_, err = NewSemaphore(newMockPluginAPI(t), "key", 0)
+	assert.Error(t, err)
+
+	_, err = NewSemaphore(newMockPluginAPI(t), "key", maxLeaseHolders+1)
+	assert.Error(t, err)
+}
+
+func TestSemaphore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("limits concurrent holders", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		s1 := mustNewSemaphore(mockPluginAPI, key, 2)
+		s2 := mustNewSemaphore(mockPluginAPI, key, 2)
+
+		requireSignal(t, acquireAsync(s1.Acquire), time.Second, "failed to acquire first holder")
+		requireSignal(t, acquireAsync(s2.Acquire), time.Second, "failed to acquire second holder")
+
+		done := acquireAsync(s2.Acquire)
+		requireNoSignal(t, done, time.Second, "third holder should not have acquired")
+
+		s1.Release()
+		requireSignal(t, done, pollWaitInterval*2, "third holder should have acquired")
+
+		s2.Release()
+		s2.Release()
+
+		assert.Empty(t, mockPluginAPI.keyValues)
+	})
+
+	t.Run("release when not acquired", func(t *testing.T) {
+		t.Parallel()
+
+		s := mustNewSemaphore(newMockPluginAPI(t), model.NewId(), 1)
+		assert.Panics(t, s.Release)
+	})
+
+	t.Run("acquire with context", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		s := mustNewSemaphore(mockPluginAPI, model.NewId(), 1)
+
+		require.NoError(t, s.AcquireWithContext(context.Background()))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		require.ErrorIs(t, s.AcquireWithContext(ctx), context.DeadlineExceeded)
+
+		s.Release()
+		require.NoError(t, s.AcquireWithContext(context.Background()))
+		s.Release()
+	})
+
+	t.Run("expires dead holders", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+
+		// The dead holder expired, unlike the live one.
+		data, err := json.Marshal(leaseState{Holders: map[string]leaseHolder{
+			"dead": {Expires: time.Now().Add(-time.Second).UnixMilli()},
+			"live": {Expires: time.Now().Add(ttl).UnixMilli()},
+		}})
+		require.NoError(t, err)
+		mockPluginAPI.keyValues[semaphorePrefix+key] = data
+
+		s := mustNewSemaphore(mockPluginAPI, key, 2)
+		requireSignal(t, acquireAsync(s.Acquire), time.Second, "failed to acquire over expired holder")
+
+		var state leaseState
+		require.NoError(t, json.Unmarshal(mockPluginAPI.keyValues[semaphorePrefix+key], &state))
+		assert.Len(t, state.Holders, 2)
+		assert.Contains(t, state.Holders, "live")
+
+		s.Release()
+	})
+
+	t.Run("held context canceled on release", func(t *testing.T) {
+		t.Parallel()
+
+		s := mustNewSemaphore(newMockPluginAPI(t), model.NewId(), 1)
+
+		ctx, err := s.AcquireWithHeldContext(context.Background())
+		require.NoError(t, err)
+		require.NoError(t, ctx.Err())
+
+		s.Release()
+		requireSignal(t, ctx.Done(), time.Second, "context should have been canceled")
+	})
+
+	t.Run("held context canceled when lost", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		key := model.NewId()
+		s := mustNewSemaphore(mockPluginAPI, key, 1)
+
+		ctx, err := s.AcquireWithHeldContext(context.Background())
+		require.NoError(t, err)
+
+		// Simulate the holder expiring.
+		appErr := mockPluginAPI.KVDelete(semaphorePrefix + key)
+		require.Nil(t, appErr)
+
+		requireSignal(t, ctx.Done(), refreshInterval+2*time.Second, "context should have been canceled")
+		s.Release()
+	})
+
+	t.Run("failed acquire", func(t *testing.T) {
+		t.Parallel()
+
+		mockPluginAPI := newMockPluginAPI(t)
+		s := mustNewSemaphore(mockPluginAPI, model.NewId(), 1)
+
+		mockPluginAPI.setFailing(true)
+		done := acquireAsync(s.Acquire)
+		requireNoSignal(t, done, 2*time.Second, "should not have acquired")
+
+		mockPluginAPI.setFailing(false)
+		requireSignal(t, done, 5*time.Second, "should have acquired")
+		s.Release()
+	})
+}
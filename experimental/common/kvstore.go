@@ -19,3 +19,9 @@ type KVStore interface {
 	DeleteAll() error
 	ListKeys(page, count int, options ...pluginapi.ListKeysOption) ([]string, error)
 }
+
+// KVService, its namespaces and KVCache implement KVStore.
+var (
+	_ KVStore = (*pluginapi.KVService)(nil)
+	_ KVStore = (*pluginapi.KVCache)(nil)
+)
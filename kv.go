@@ -1,30 +1,136 @@
 package pluginapi
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/plugin"
 	"github.com/pkg/errors"
 )
 
-// numRetries is the number of times the setAtomicWithRetries will retry before returning an error.
-const numRetries = 5
+const (
+	// hashedKeyPrefix namespaces the keys of values whose namespaced key was too long to be
+	// stored as is.
+	hashedKeyPrefix = internalKeyPrefix + "h_"
+
+	// originalKeyPrefix namespaces the keys mapping a hashed key back to the original key.
+	originalKeyPrefix = internalKeyPrefix + "k_"
+
+	// namespaceSeparator separates the name of a namespace from the keys it holds.
+	namespaceSeparator = "_"
+)
 
 // KVService exposes methods to read and write key-value pairs for the active plugin.
 //
 // This service cannot be used to read or write key-value pairs for other plugins.
 type KVService struct {
 	api plugin.API
+
+	// namespace is prepended to every key read or written, and is empty unless the service was
+	// created by Namespace.
+	namespace string
+
+	// encoding configures how values are stored, and defaults to JSON.
+	encoding kvEncoding
+
+	// encryption encrypts values at rest, if configured by WithEncryption.
+	encryption *kvEncryption
+
+	// batchConcurrency bounds the number of concurrent requests of batch operations, and defaults
+	// to defaultBatchConcurrency.
+	batchConcurrency int
+
+	// onChange, if set by a KVWatcher, is called with the namespaced key after every write.
+	onChange func(key string, op KVOp)
+}
+
+// Namespace returns a KVService whose keys are scoped to the given namespace, allowing
+// independent parts of a plugin to share the key-value store without key collisions.
+// Namespaces may be nested.
+//
+// Keys are prefixed with the namespace and an underscore before being stored, so keys set by
+// the parent KVService with that same prefix also belong to the namespace. Keys that would then
+// exceed the maximum key length accepted by the server are stored under a hash of the key
+// instead, with ListKeys and Scan still returning the original key. DeleteAll only removes the
+// keys of the namespace.
+//
+// Namespace panics if the name is empty or contains an underscore, as the namespaces of such
+// names would overlap.
+func (k *KVService) Namespace(name string) *KVService {
+	if name == "" || strings.Contains(name, namespaceSeparator) {
+		panic("invalid namespace name " + strconv.Quote(name))
+	}
+
+	kv := *k
+	kv.namespace += name + namespaceSeparator
+
+	return &kv
+}
+
+// storeKey returns the key under which the value for the given key is stored. If the key had to
+// be hashed, mappingKey is the key under which the original key is stored.
+func (k *KVService) storeKey(key string) (storeKey, mappingKey string) {
+	if k.namespace == "" {
+		return key, ""
+	}
+
+	storeKey = k.namespace + key
+	if utf8.RuneCountInString(storeKey) <= model.KeyValueKeyMaxRunes {
+		return storeKey, ""
+	}
+
+	hash := hashKey(key)
+	return k.namespace + hashedKeyPrefix + hash, k.namespace + originalKeyPrefix + hash
+}
+
+// originalKey maps a key as returned by the server back to the key given by the caller,
+// returning false if the key does not belong to the namespace, or is used internally.
+func (k *KVService) originalKey(storeKey string) (string, bool, error) {
+	if k.namespace == "" {
+		return storeKey, true, nil
+	}
+
+	if !strings.HasPrefix(storeKey, k.namespace) {
+		return "", false, nil
+	}
+	key := storeKey[len(k.namespace):]
+
+	if strings.HasPrefix(key, hashedKeyPrefix) {
+		data, appErr := k.api.KVGet(k.namespace + originalKeyPrefix + key[len(hashedKeyPrefix):])
+		if appErr != nil {
+			return "", false, errors.Wrapf(normalizeAppErr(appErr), "failed to get original key for %s", storeKey)
+		}
+		if len(data) == 0 {
+			return "", false, nil
+		}
+
+		return string(data), true, nil
+	}
+
+	if strings.HasPrefix(key, internalKeyPrefix) {
+		return "", false, nil
+	}
+
+	return key, true, nil
+}
+
+// hashKey returns a fixed length representation of the given key.
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
 }
 
 // TODO: Should this be un exported?
 type KVSetOptions struct {
 	model.PluginKVSetOptions
 	oldValue interface{}
+	encoding kvEncoding
 }
 
 // KVSetOption is an option passed to Set() operation.
@@ -60,23 +166,16 @@ func (k *KVService) Set(key string, value interface{}, options ...KVSetOption) (
 		return false, errors.New("'mmi_' prefix is not allowed for keys")
 	}
 
-	opts := KVSetOptions{}
+	opts := KVSetOptions{
+		encoding: k.encoding,
+	}
 	for _, o := range options {
 		o(&opts)
 	}
 
-	var valueBytes []byte
-	if value != nil {
-		// Assume JSON encoding, unless explicitly given a byte slice.
-		var isValueInBytes bool
-		valueBytes, isValueInBytes = value.([]byte)
-		if !isValueInBytes {
-			var err error
-			valueBytes, err = json.Marshal(value)
-			if err != nil {
-				return false, errors.Wrapf(err, "failed to marshal value %v", value)
-			}
-		}
+	valueBytes, err := k.encodeValue(value, opts.encoding)
+	if err != nil {
+		return false, err
 	}
 
 	downstreamOpts := model.PluginKVSetOptions{
@@ -85,21 +184,62 @@ func (k *KVService) Set(key string, value interface{}, options ...KVSetOption) (
 	}
 
 	if opts.oldValue != nil {
-		oldValueBytes, isOldValueInBytes := opts.oldValue.([]byte)
-		if isOldValueInBytes {
-			downstreamOpts.OldValue = oldValueBytes
-		} else {
-			data, err := json.Marshal(opts.oldValue)
+		downstreamOpts.OldValue, err = k.encodeValue(opts.oldValue, opts.encoding)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	storeKey, mappingKey := k.storeKey(key)
+
+	if k.encryption != nil {
+		if valueBytes != nil {
+			valueBytes, err = k.encryption.encrypt(storeKey, valueBytes)
+			if err != nil {
+				return false, errors.Wrapf(err, "failed to encrypt value for key %s", key)
+			}
+		}
+
+		if downstreamOpts.Atomic {
+			var matches bool
+			downstreamOpts.OldValue, matches, err = k.encryptedOldValue(storeKey, downstreamOpts.OldValue)
 			if err != nil {
-				return false, errors.Wrapf(err, "failed to marshal value %v", opts.oldValue)
+				return false, errors.Wrapf(err, "failed to read old value for key %s", key)
+			} else if !matches {
+				return false, nil
 			}
+		}
+	}
 
-			downstreamOpts.OldValue = data
+	if mappingKey != "" && valueBytes != nil {
+		// Record the original key first, so that the value is never listed without it.
+		_, appErr := k.api.KVSetWithOptions(mappingKey, []byte(key), model.PluginKVSetOptions{
+			ExpireInSeconds: opts.ExpireInSeconds,
+		})
+		if appErr != nil {
+			return false, errors.Wrapf(normalizeAppErr(appErr), "failed to set original key for %s", key)
 		}
 	}
 
-	written, appErr := k.api.KVSetWithOptions(key, valueBytes, downstreamOpts)
-	return written, normalizeAppErr(appErr)
+	written, appErr := k.api.KVSetWithOptions(storeKey, valueBytes, downstreamOpts)
+	if appErr != nil {
+		return false, normalizeAppErr(appErr)
+	}
+
+	if mappingKey != "" && valueBytes == nil && written {
+		// If an error occurs deleting, the original key is ignored until the key is set again.
+		_, _ = k.api.KVSetWithOptions(mappingKey, nil, model.PluginKVSetOptions{})
+	}
+
+	if written && k.onChange != nil {
+		op := KVOpSet
+		if valueBytes == nil {
+			op = KVOpDelete
+		}
+		k.onChange(k.namespace+key, op)
+	}
+
+	return written, nil
 }
 
 // SetWithExpiry sets a key-value pair with the given expiration duration relative to now.
@@ -154,37 +294,84 @@ func (k *KVService) CompareAndDelete(key string, oldValue interface{}) (bool, er
 //	                   oldValue, it will need to use the oldValue as a []byte, or convert
 //	                   oldValue into the expected type (e.g., by parsing it, or marshaling it
 //	                   into the expected struct). It should then return the newValue as the type
-//	                   expected to be stored.
+//	                   expected to be stored. If the value was stored using a codec or
+//	                   compression, oldValue is given decompressed and encoded by that codec.
 //
 // Returns:
 //
 //	Returns err if the key could not be retrieved (DB error), valueFunc returned an error,
-//	if the key could not be set (DB error), or if the key could not be set (after retries).
-//	Returns nil if the value was set.
+//	if the key could not be set (DB error), or if the key could not be set (after retries), in
+//	which case err wraps ErrConflict. Returns nil if the value was set.
+//
+// Use SetAtomicWithRetriesContext to configure the number of retries and the wait between them.
 //
 // Minimum server version: 5.18
 func (k *KVService) SetAtomicWithRetries(key string, valueFunc func(oldValue []byte) (newValue interface{}, err error)) error {
-	for i := 0; i < numRetries; i++ {
+	_, err := k.setAtomicWithRetries(context.Background(), key, k.unwrappedValueFunc(key, valueFunc), defaultBackoff)
+	return err
+}
+
+// unwrappedValueFunc adapts a valueFunc expecting the payload of a stored value, without any
+// header added by the service's codec, to setAtomicWithRetries.
+func (k *KVService) unwrappedValueFunc(
+	key string,
+	valueFunc func(oldValue []byte) (newValue interface{}, err error),
+) func(storedValue []byte) (interface{}, error) {
+	return func(storedValue []byte) (interface{}, error) {
+		_, oldValue, err := k.unwrapValue(storedValue)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode value for key %s", key)
+		}
+
+		return valueFunc(oldValue)
+	}
+}
+
+// setAtomicWithRetries implements SetAtomicWithRetries, passing valueFunc the value exactly as
+// stored, without removing any header added by the service's codec. It returns the number of
+// attempts made.
+func (k *KVService) setAtomicWithRetries(
+	ctx context.Context,
+	key string,
+	valueFunc func(storedValue []byte) (newValue interface{}, err error),
+	backoff KVBackoff,
+) (int, error) {
+	start := time.Now()
+
+	for attempts := 1; ; attempts++ {
+		if err := ctx.Err(); err != nil {
+			return attempts - 1, errors.Wrapf(err, "failed to set value for key %s", key)
+		}
+
 		var oldVal []byte
 		if err := k.Get(key, &oldVal); err != nil {
-			return errors.Wrapf(err, "failed to get value for key %s", key)
+			return attempts, errors.Wrapf(err, "failed to get value for key %s", key)
 		}
 
 		newVal, err := valueFunc(oldVal)
 		if err != nil {
-			return errors.Wrap(err, "valueFunc failed")
+			return attempts, errors.Wrap(err, "valueFunc failed")
 		}
 
 		if saved, err := k.Set(key, newVal, SetAtomic(oldVal)); err != nil {
-			return errors.Wrapf(err, "DB failed to set value for key %s", key)
+			return attempts, errors.Wrapf(err, "DB failed to set value for key %s", key)
 		} else if saved {
-			return nil
+			return attempts, nil
+		}
+
+		wait, retry := backoff.NextWait(attempts, time.Since(start))
+		if !retry {
+			return attempts, errors.Wrapf(ErrConflict, "failed to set value after %d retries", attempts)
 		}
 
-		// small delay to allow cooperative scheduling to do its thing
-		time.Sleep(10 * time.Millisecond)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempts, errors.Wrapf(ctx.Err(), "failed to set value for key %s", key)
+		}
 	}
-	return fmt.Errorf("failed to set value after %d retries", numRetries)
 }
 
 // Get gets the value for the given key into the given interface.
@@ -194,7 +381,8 @@ func (k *KVService) SetAtomicWithRetries(key string, valueFunc func(oldValue []b
 //
 // Minimum server version: 5.2
 func (k *KVService) Get(key string, o interface{}) error {
-	data, appErr := k.api.KVGet(key)
+	storeKey, _ := k.storeKey(key)
+	data, appErr := k.api.KVGet(storeKey)
 	if appErr != nil {
 		return normalizeAppErr(appErr)
 	}
@@ -203,12 +391,12 @@ func (k *KVService) Get(key string, o interface{}) error {
 		return nil
 	}
 
-	if bytesOut, ok := o.(*[]byte); ok {
-		*bytesOut = data
-		return nil
+	data, err := k.decryptValue(storeKey, data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to decrypt value for key %s", key)
 	}
 
-	if err := json.Unmarshal(data, o); err != nil {
+	if err = k.decodeValue(data, o); err != nil {
 		return errors.Wrapf(err, "failed to unmarshal value for key %s", key)
 	}
 
@@ -226,11 +414,64 @@ func (k *KVService) Delete(key string) error {
 	return err
 }
 
-// DeleteAll removes all key-value pairs.
+// DeleteAll removes all key-value pairs. If the service was created by Namespace, only the
+// key-value pairs of the namespace are removed.
 //
 // Minimum server version: 5.6
 func (k *KVService) DeleteAll() error {
-	return normalizeAppErr(k.api.KVDeleteAll())
+	if k.namespace == "" && k.onChange == nil {
+		return normalizeAppErr(k.api.KVDeleteAll())
+	}
+
+	// Collect the keys before deleting any, as deleting shifts the pages being walked.
+	var storeKeys, deletedKeys []string
+	for page := 0; ; page++ {
+		keys, appErr := k.api.KVList(page, scanPageSize)
+		if appErr != nil {
+			return normalizeAppErr(appErr)
+		}
+
+		for _, storeKey := range keys {
+			if !strings.HasPrefix(storeKey, k.namespace) {
+				continue
+			}
+			storeKeys = append(storeKeys, storeKey)
+
+			if k.onChange == nil {
+				continue
+			}
+
+			key, ok, err := k.originalKey(storeKey)
+			if err != nil {
+				return err
+			}
+			if ok && !strings.HasPrefix(key, internalKeyPrefix) {
+				deletedKeys = append(deletedKeys, k.namespace+key)
+			}
+		}
+
+		if len(keys) < scanPageSize {
+			break
+		}
+	}
+
+	if k.namespace == "" {
+		if appErr := k.api.KVDeleteAll(); appErr != nil {
+			return normalizeAppErr(appErr)
+		}
+	} else {
+		for _, key := range storeKeys {
+			if _, appErr := k.api.KVSetWithOptions(key, nil, model.PluginKVSetOptions{}); appErr != nil {
+				return errors.Wrapf(normalizeAppErr(appErr), "failed to delete key %s", key)
+			}
+		}
+	}
+
+	for _, key := range deletedKeys {
+		k.onChange(key, KVOpDelete)
+	}
+
+	return nil
 }
 
 // ListKeysOption used to configure a ListKeys() operation.
@@ -292,7 +533,7 @@ func (k *KVService) ListKeys(page, count int, options ...ListKeysOption) ([]stri
 		return nil, normalizeAppErr(appErr)
 	}
 
-	if len(args.checkers) == 0 {
+	if len(args.checkers) == 0 && k.namespace == "" {
 		// no checkers, just return the keys
 		return keys, nil
 	}
@@ -300,7 +541,16 @@ func (k *KVService) ListKeys(page, count int, options ...ListKeysOption) ([]stri
 	ret := make([]string, 0)
 	// we have a filter, so check each key, all checkers must say key
 	// for us to keep a key
-	for _, key := range keys {
+	for _, storeKey := range keys {
+		key, ok, err := k.originalKey(storeKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
 		keep, err := args.checkAll(key)
 		if err != nil {
 			return nil, err
@@ -316,3 +566,134 @@ func (k *KVService) ListKeys(page, count int, options ...ListKeysOption) ([]stri
 
 	return ret, nil
 }
+
+// scanPageSize is the number of keys requested from the server per page while scanning.
+const scanPageSize = 1000
+
+// KVIterator walks every key matching the options given to Scan, requesting further pages from
+// the server as needed.
+//
+// A KVIterator is not safe for concurrent use.
+type KVIterator struct {
+	kv   *KVService
+	args *listKeysOptions
+
+	page     int
+	keys     []string
+	lastPage bool
+
+	key      string
+	storeKey string
+	err      error
+}
+
+// Scan returns an iterator over all keys that match the given options. If no options are
+// provided then all keys are returned.
+//
+// Unlike ListKeys, which filters the keys of a single page, Scan keeps requesting pages until
+// the key-value store is exhausted, so every matching key is visited exactly once. Stop calling
+// Next to terminate the scan early.
+//
+// Keys written or deleted while a scan is in progress may shift the underlying pages, in which
+// case some keys may be skipped or visited twice.
+//
+//	it := client.KV.Scan(pluginapi.WithPrefix("user_"))
+//	for it.Next() {
+//		var u User
+//		if err := it.Value(&u); err != nil {
+//			return err
+//		}
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+//
+// Minimum server version: 5.6
+func (k *KVService) Scan(options ...ListKeysOption) *KVIterator {
+	args := &listKeysOptions{
+		checkers: nil,
+	}
+	for _, opt := range options {
+		opt(args)
+	}
+
+	return &KVIterator{
+		kv:   k,
+		args: args,
+	}
+}
+
+// Next advances the iterator to the next matching key, returning false once all keys have been
+// visited or an error occurred. Check Err after Next returns false.
+func (it *KVIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	for {
+		for len(it.keys) > 0 {
+			storeKey := it.keys[0]
+			it.keys = it.keys[1:]
+
+			key, ok, err := it.kv.originalKey(storeKey)
+			if err != nil {
+				it.err = err
+				it.key, it.storeKey = "", ""
+				return false
+			}
+
+			if !ok {
+				continue
+			}
+
+			keep, err := it.args.checkAll(key)
+			if err != nil {
+				it.err = err
+				it.key, it.storeKey = "", ""
+				return false
+			}
+
+			if keep {
+				it.key = key
+				it.storeKey = storeKey
+				return true
+			}
+		}
+
+		if it.lastPage {
+			it.key, it.storeKey = "", ""
+			return false
+		}
+
+		keys, appErr := it.kv.api.KVList(it.page, scanPageSize)
+		if appErr != nil {
+			it.err = normalizeAppErr(appErr)
+			it.key, it.storeKey = "", ""
+			return false
+		}
+
+		it.page++
+		it.keys = keys
+		it.lastPage = len(keys) < scanPageSize
+	}
+}
+
+// Key returns the key the iterator is currently positioned at.
+func (it *KVIterator) Key() string {
+	return it.key
+}
+
+// Value gets the value of the current key into the given interface, following the semantics
+// of Get.
+func (it *KVIterator) Value(o interface{}) error {
+	if it.key == "" {
+		return errors.New("iterator is not positioned at a key")
+	}
+
+	return it.kv.Get(it.key, o)
+}
+
+// Err returns the error, if any, that halted the iteration.
+func (it *KVIterator) Err() error {
+	return it.err
+}
@@ -1,17 +1,21 @@
 package pluginapi_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/mattermost/mattermost-server/v6/model"
 	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	pluginapi "github.com/mattermost/mattermost-plugin-api"
@@ -679,3 +683,323 @@ func getKeys(count int) []string {
 	}
 	return ret
 }
+
+func TestScan(t *testing.T) {
+	t.Run("no keys", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		api.On("KVList", 0, 1000).Return(nil, nil)
+
+		it := client.KV.Scan()
+		assert.False(t, it.Next())
+		assert.NoError(t, it.Err())
+		assert.Empty(t, it.Key())
+	})
+
+	t.Run("walks every page", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		allKeys := getKeys(2500)
+		api.On("KVList", 0, 1000).Return(allKeys[:1000], nil)
+		api.On("KVList", 1, 1000).Return(allKeys[1000:2000], nil)
+		api.On("KVList", 2, 1000).Return(allKeys[2000:], nil)
+
+		var keys []string
+		it := client.KV.Scan()
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, allKeys, keys)
+	})
+
+	t.Run("filters across pages", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		allKeys := getKeys(1500)
+		api.On("KVList", 0, 1000).Return(allKeys[:1000], nil)
+		api.On("KVList", 1, 1000).Return(allKeys[1000:], nil)
+
+		check := func(key string) (bool, error) {
+			return key != "key1499", nil
+		}
+
+		var keys []string
+		it := client.KV.Scan(pluginapi.WithPrefix("key149"), pluginapi.WithChecker(check))
+		for it.Next() {
+			keys = append(keys, it.Key())
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, []string{"key149", "key1490", "key1491", "key1492", "key1493", "key1494", "key1495", "key1496", "key1497", "key1498"}, keys)
+	})
+
+	t.Run("early termination does not fetch further pages", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		api.On("KVList", 0, 1000).Return(getKeys(1000), nil).Once()
+
+		it := client.KV.Scan(pluginapi.WithPrefix("key5"))
+		require.True(t, it.Next())
+		assert.Equal(t, "key5", it.Key())
+	})
+
+	t.Run("loads values", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		api.On("KVList", 0, 1000).Return([]string{"a", "b"}, nil)
+		api.On("KVGet", "a").Return([]byte(`"1"`), nil)
+		api.On("KVGet", "b").Return([]byte(`"2"`), nil)
+
+		values := map[string]string{}
+		it := client.KV.Scan()
+		for it.Next() {
+			var value string
+			require.NoError(t, it.Value(&value))
+			values[it.Key()] = value
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, map[string]string{"a": "1", "b": "2"}, values)
+
+		var value string
+		assert.Error(t, it.Value(&value))
+	})
+
+	t.Run("KVList error", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		api.On("KVList", 0, 1000).Return(getKeys(1000), nil)
+		api.On("KVList", 1, 1000).Return(nil, newAppError())
+
+		count := 0
+		it := client.KV.Scan()
+		for it.Next() {
+			count++
+		}
+		assert.Equal(t, 1000, count)
+		assert.Error(t, it.Err())
+		assert.False(t, it.Next())
+	})
+
+	t.Run("checker error", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		api.On("KVList", 0, 1000).Return([]string{"key1"}, nil)
+
+		check := func(key string) (bool, error) {
+			return true, errors.New("checker failed")
+		}
+
+		it := client.KV.Scan(pluginapi.WithChecker(check))
+		assert.False(t, it.Next())
+		assert.Error(t, it.Err())
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	longKey := strings.Repeat("a", model.KeyValueKeyMaxRunes)
+
+	t.Run("set, get and delete are prefixed", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		users := client.KV.Namespace("users")
+
+		api.On("KVSetWithOptions", "users_1", []byte(`"2"`), model.PluginKVSetOptions{}).Return(true, nil).Once()
+		api.On("KVGet", "users_1").Return([]byte(`"2"`), nil).Once()
+		api.On("KVSetWithOptions", "users_1", []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+
+		written, err := users.Set("1", "2")
+		require.NoError(t, err)
+		assert.True(t, written)
+
+		var out string
+		err = users.Get("1", &out)
+		require.NoError(t, err)
+		assert.Equal(t, "2", out)
+
+		err = users.Delete("1")
+		require.NoError(t, err)
+	})
+
+	t.Run("nested namespaces", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		settings := client.KV.Namespace("users").Namespace("settings")
+
+		api.On("KVSetWithOptions", "users_settings_1", []byte(`"2"`), model.PluginKVSetOptions{}).Return(true, nil)
+
+		_, err := settings.Set("1", "2")
+		require.NoError(t, err)
+	})
+
+	t.Run("mmi_ prefix is not allowed", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+
+		_, err := client.KV.Namespace("users").Set("mmi_1", "2")
+		require.Error(t, err)
+	})
+
+	t.Run("names must not be empty or contain the separator", func(t *testing.T) {
+		client := pluginapi.NewClient(&plugintest.API{}, &plugintest.Driver{})
+
+		assert.Panics(t, func() { client.KV.Namespace("") })
+		assert.Panics(t, func() { client.KV.Namespace("users_archive") })
+	})
+
+	t.Run("long keys are hashed", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		users := client.KV.Namespace("users")
+
+		var hashedKey, mappingKey string
+		api.On("KVSetWithOptions", mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, "users_mmi_k_")
+		}), []byte(longKey), model.PluginKVSetOptions{ExpireInSeconds: 60}).Run(func(args mock.Arguments) {
+			mappingKey = args.String(0)
+		}).Return(true, nil).Once()
+		api.On("KVSetWithOptions", mock.MatchedBy(func(key string) bool {
+			return strings.HasPrefix(key, "users_mmi_h_")
+		}), []byte(`"2"`), model.PluginKVSetOptions{ExpireInSeconds: 60}).Run(func(args mock.Arguments) {
+			hashedKey = args.String(0)
+		}).Return(true, nil).Once()
+
+		_, err := users.Set(longKey, "2", pluginapi.SetExpiry(time.Minute))
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(hashedKey), model.KeyValueKeyMaxRunes)
+		assert.Equal(t, strings.TrimPrefix(hashedKey, "users_mmi_h_"), strings.TrimPrefix(mappingKey, "users_mmi_k_"))
+
+		api.On("KVGet", hashedKey).Return([]byte(`"2"`), nil).Once()
+
+		var out string
+		err = users.Get(longKey, &out)
+		require.NoError(t, err)
+		assert.Equal(t, "2", out)
+
+		api.On("KVList", 0, 1000).Return([]string{"other", hashedKey, mappingKey, "users_1"}, nil)
+		api.On("KVGet", mappingKey).Return([]byte(longKey), nil)
+
+		keys, err := users.ListKeys(0, 1000)
+		require.NoError(t, err)
+		assert.Equal(t, []string{longKey, "1"}, keys)
+
+		var scanned []string
+		it := users.Scan()
+		for it.Next() {
+			scanned = append(scanned, it.Key())
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, []string{longKey, "1"}, scanned)
+
+		api.On("KVSetWithOptions", hashedKey, []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+		api.On("KVSetWithOptions", mappingKey, []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+
+		err = users.Delete(longKey)
+		require.NoError(t, err)
+	})
+
+	t.Run("list keys only returns keys of the namespace", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		users := client.KV.Namespace("users")
+
+		api.On("KVList", 0, 100).Return([]string{"users_1", "teams_1", "users_2", "users_mmi_k_abc"}, nil)
+
+		keys, err := users.ListKeys(0, 100)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1", "2"}, keys)
+
+		keys, err = users.ListKeys(0, 100, pluginapi.WithPrefix("2"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"2"}, keys)
+	})
+
+	t.Run("delete all only removes keys of the namespace", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		users := client.KV.Namespace("users")
+
+		api.On("KVList", 0, 1000).Return([]string{"users_1", "teams_1", "users_mmi_h_abc", "users_mmi_k_abc"}, nil)
+		api.On("KVSetWithOptions", "users_1", []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+		api.On("KVSetWithOptions", "users_mmi_h_abc", []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+		api.On("KVSetWithOptions", "users_mmi_k_abc", []byte(nil), model.PluginKVSetOptions{}).Return(true, nil).Once()
+
+		err := users.DeleteAll()
+		require.NoError(t, err)
+	})
+}
+
+// newMemoryKVAPI returns a plugin API mock whose key-value methods are backed by the returned
+// map, for tests exercising several calls against the same keys.
+func newMemoryKVAPI(t *testing.T) (*plugintest.API, map[string][]byte) {
+	t.Helper()
+
+	var lock sync.Mutex
+	values := make(map[string][]byte)
+
+	api := &plugintest.API{}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte {
+		lock.Lock()
+		defer lock.Unlock()
+		return values[key]
+	}, func(key string) *model.AppError {
+		return nil
+	}).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			lock.Lock()
+			defer lock.Unlock()
+			if options.Atomic && !bytes.Equal(values[key], options.OldValue) {
+				return false
+			}
+			if value == nil {
+				delete(values, key)
+			} else {
+				values[key] = value
+			}
+			return true
+		}, func(key string, value []byte, options model.PluginKVSetOptions) *model.AppError {
+			return nil
+		}).Maybe()
+	api.On("KVList", mock.AnythingOfType("int"), mock.AnythingOfType("int")).Return(func(page, count int) []string {
+		lock.Lock()
+		defer lock.Unlock()
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		start := page * count
+		if start > len(keys) {
+			start = len(keys)
+		}
+		end := start + count
+		if end > len(keys) {
+			end = len(keys)
+		}
+		return keys[start:end]
+	}, func(page, count int) *model.AppError {
+		return nil
+	}).Maybe()
+
+	return api, values
+}
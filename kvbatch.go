@@ -0,0 +1,174 @@
+package pluginapi
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultBatchConcurrency is the number of concurrent requests of batch operations, unless
+// configured by WithBatchConcurrency.
+const defaultBatchConcurrency = 10
+
+// KVBatchError is returned by batch operations that failed for some of the keys. Keys missing
+// from Errors were processed successfully.
+type KVBatchError struct {
+	Errors map[string]error
+}
+
+func (e *KVBatchError) Error() string {
+	keys := make([]string, 0, len(e.Errors))
+	for key := range e.Errors {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	messages := make([]string, 0, len(keys))
+	for _, key := range keys {
+		messages = append(messages, fmt.Sprintf("%s: %s", key, e.Errors[key]))
+	}
+
+	return fmt.Sprintf("failed to process %d keys: %s", len(keys), strings.Join(messages, "; "))
+}
+
+// WithBatchConcurrency returns a KVService whose batch operations make at most n concurrent
+// requests to the key-value store.
+func (k *KVService) WithBatchConcurrency(n int) *KVService {
+	kv := *k
+	kv.batchConcurrency = n
+
+	return &kv
+}
+
+// GetMany gets the values for the given keys into the given map, which must be a non-nil map
+// with string keys. Keys that do not exist are not added to the map.
+//
+// If getting some of the values fails, the others are still added to the map, and a
+// *KVBatchError reporting the failed keys is returned.
+//
+// Minimum server version: 5.2
+func (k *KVService) GetMany(keys []string, into interface{}) error {
+	m := reflect.ValueOf(into)
+	if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String || m.IsNil() {
+		return errors.Errorf("expected a non-nil map with string keys, got %T", into)
+	}
+	elemType := m.Type().Elem()
+
+	var lock sync.Mutex
+	return k.forEachKeyConcurrently(keys, func(key string) error {
+		var data []byte
+		if err := k.Get(key, &data); err != nil {
+			return err
+		}
+		if len(data) == 0 {
+			return nil
+		}
+
+		value := reflect.New(elemType)
+		if err := k.decodeValue(data, value.Interface()); err != nil {
+			return errors.Wrap(err, "failed to unmarshal value")
+		}
+
+		lock.Lock()
+		m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), value.Elem())
+		lock.Unlock()
+
+		return nil
+	})
+}
+
+// SetMany stores the given key-value pairs, applying the given options to every key. See Set for
+// details. It returns the keys that were written, which excludes keys for which an atomic
+// operation did not match the stored value.
+//
+// If setting some of the values fails, the others are still set, and a *KVBatchError reporting
+// the failed keys is returned.
+//
+// Minimum server version: 5.18
+func (k *KVService) SetMany(values map[string]interface{}, options ...KVSetOption) ([]string, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	var lock sync.Mutex
+	var written []string
+	err := k.forEachKeyConcurrently(keys, func(key string) error {
+		ok, err := k.Set(key, values[key], options...)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			lock.Lock()
+			written = append(written, key)
+			lock.Unlock()
+		}
+
+		return nil
+	})
+
+	sort.Strings(written)
+
+	return written, err
+}
+
+// DeleteMany deletes the given keys.
+//
+// If deleting some of the keys fails, the others are still deleted, and a *KVBatchError
+// reporting the failed keys is returned.
+//
+// Minimum server version: 5.18
+func (k *KVService) DeleteMany(keys []string) error {
+	return k.forEachKeyConcurrently(keys, k.Delete)
+}
+
+// forEachKeyConcurrently calls f for each distinct key using a bounded number of goroutines,
+// collecting errors in a *KVBatchError.
+func (k *KVService) forEachKeyConcurrently(keys []string, f func(key string) error) error {
+	concurrency := k.batchConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	seen := make(map[string]bool, len(keys))
+	work := make(chan string)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	failed := make(map[string]error)
+
+	for i := 0; i < concurrency && i < len(keys); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				if err := f(key); err != nil {
+					lock.Lock()
+					failed[key] = err
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+
+	if len(failed) > 0 {
+		return &KVBatchError{Errors: failed}
+	}
+
+	return nil
+}
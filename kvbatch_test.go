@@ -0,0 +1,82 @@
+package pluginapi_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestKVBatch(t *testing.T) {
+	type settings struct {
+		Muted bool
+	}
+
+	api, values := newMemoryKVAPI(t)
+	client := pluginapi.NewClient(api, nil)
+	kv := client.KV.Namespace("settings").WithBatchConcurrency(3)
+
+	t.Run("set many", func(t *testing.T) {
+		batch := make(map[string]interface{})
+		for i := 0; i < 20; i++ {
+			batch["user"+strconv.Itoa(i)] = settings{Muted: i%2 == 0}
+		}
+
+		written, err := kv.SetMany(batch)
+		require.NoError(t, err)
+		assert.Len(t, written, 20)
+		assert.Len(t, values, 20)
+	})
+
+	t.Run("set many atomically", func(t *testing.T) {
+		written, err := kv.SetMany(map[string]interface{}{
+			"user0":  settings{},
+			"user20": settings{Muted: true},
+		}, pluginapi.SetAtomic(nil))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"user20"}, written)
+	})
+
+	t.Run("get many", func(t *testing.T) {
+		result := make(map[string]settings)
+		err := kv.GetMany([]string{"user0", "user1", "user20", "user0", "unknown"}, result)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]settings{
+			"user0":  {Muted: true},
+			"user1":  {Muted: false},
+			"user20": {Muted: true},
+		}, result)
+
+		err = kv.GetMany([]string{"user0"}, map[int]settings{})
+		require.Error(t, err)
+	})
+
+	t.Run("delete many", func(t *testing.T) {
+		var keys []string
+		for i := 0; i < 21; i++ {
+			keys = append(keys, "user"+strconv.Itoa(i))
+		}
+
+		err := kv.DeleteMany(keys)
+		require.NoError(t, err)
+		assert.Empty(t, values)
+	})
+
+	t.Run("per-key errors", func(t *testing.T) {
+		written, err := client.KV.SetMany(map[string]interface{}{
+			"mmi_reserved": 1,
+			"allowed":      2,
+		})
+		assert.Equal(t, []string{"allowed"}, written)
+
+		var batchErr *pluginapi.KVBatchError
+		require.True(t, errors.As(err, &batchErr))
+		require.Len(t, batchErr.Errors, 1)
+		assert.Error(t, batchErr.Errors["mmi_reserved"])
+		assert.Equal(t, []byte("2"), values["allowed"])
+	})
+}
@@ -0,0 +1,361 @@
+package pluginapi
+
+import (
+	"container/list"
+	"encoding/json"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// kvCacheInvalidateEventID identifies the cluster events invalidating cached key-value pairs.
+const kvCacheInvalidateEventID = internalKeyPrefix + "kv_cache_invalidate"
+
+// KVCacheOptions configures a KVCache.
+type KVCacheOptions struct {
+	// MaxEntries bounds the number of cached keys, evicting the least recently used keys first.
+	// Defaults to 1000.
+	MaxEntries int
+
+	// TTL is how long a key remains cached after being read from the key-value store. Defaults to
+	// one minute.
+	TTL time.Duration
+}
+
+// KVCacheStats reports the usage of a KVCache.
+type KVCacheStats struct {
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// KVCache is a read-through cache in front of a KVService, kept coherent across the cluster.
+//
+// Writes through the cache invalidate the written key on every plugin instance by publishing a
+// cluster event, which each instance must pass to HandleClusterEvent from its
+// OnPluginClusterEvent hook. Writes made without going through a KVCache are only observed once
+// the cached entry expires.
+type KVCache struct {
+	kv         *KVService
+	maxEntries int
+	ttl        time.Duration
+
+	hits   uint64
+	misses uint64
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+
+	// generation is incremented by every invalidation, so that a value read concurrently with
+	// an invalidation is not cached.
+	generation uint64
+}
+
+type kvCacheEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+}
+
+// kvCacheInvalidation is the payload of a cache invalidation event. Keys are namespaced.
+type kvCacheInvalidation struct {
+	Keys   []string `json:",omitempty"`
+	Prefix string   `json:",omitempty"`
+	All    bool     `json:",omitempty"`
+}
+
+// NewKVCache creates a KVCache reading and writing through the given KVService.
+func NewKVCache(kv *KVService, options KVCacheOptions) *KVCache {
+	if options.MaxEntries <= 0 {
+		options.MaxEntries = 1000
+	}
+	if options.TTL <= 0 {
+		options.TTL = time.Minute
+	}
+
+	return &KVCache{
+		kv:         kv,
+		maxEntries: options.MaxEntries,
+		ttl:        options.TTL,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Get gets the value for the given key into the given interface, reading from the key-value
+// store only if the key is not cached. Non-existent keys are cached too.
+//
+// Minimum server version: 5.2
+func (c *KVCache) Get(key string, o interface{}) error {
+	cacheKey := c.kv.namespace + key
+
+	data, ok := c.lookup(cacheKey)
+	if ok {
+		atomic.AddUint64(&c.hits, 1)
+	} else {
+		atomic.AddUint64(&c.misses, 1)
+
+		c.lock.Lock()
+		generation := c.generation
+		c.lock.Unlock()
+
+		if err := c.kv.Get(key, &data); err != nil {
+			return err
+		}
+
+		c.store(cacheKey, data, generation)
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	// The cached data is shared, so callers reading raw bytes get their own copy to modify.
+	if bytesOut, ok := o.(*[]byte); ok {
+		*bytesOut = append([]byte(nil), data...)
+		return nil
+	}
+
+	if err := c.kv.decodeValue(data, o); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal value for key %s", key)
+	}
+
+	return nil
+}
+
+// Set stores a key-value pair and invalidates the key on every plugin instance. See
+// KVService.Set for details.
+//
+// An error is returned if the other plugin instances could not be notified, even if the value
+// was set.
+//
+// Minimum server version: 5.36
+func (c *KVCache) Set(key string, value interface{}, options ...KVSetOption) (bool, error) {
+	written, err := c.kv.Set(key, value, options...)
+	if err != nil || !written {
+		return written, err
+	}
+
+	return true, c.invalidate(kvCacheInvalidation{Keys: []string{c.kv.namespace + key}})
+}
+
+// SetWithExpiry sets a key-value pair with the given expiration duration relative to now.
+//
+// Deprecated: SetWithExpiry exists to implement common.KVStore. Use Set with the appropriate
+// options instead.
+//
+// Minimum server version: 5.36
+func (c *KVCache) SetWithExpiry(key string, value interface{}, ttl time.Duration) error {
+	_, err := c.Set(key, value, SetExpiry(ttl))
+
+	return err
+}
+
+// CompareAndSet writes a key-value pair if the current value matches the given old value.
+//
+// Deprecated: CompareAndSet exists to implement common.KVStore. Use Set with the appropriate
+// options instead.
+//
+// Minimum server version: 5.36
+func (c *KVCache) CompareAndSet(key string, oldValue, value interface{}) (bool, error) {
+	return c.Set(key, value, SetAtomic(oldValue))
+}
+
+// CompareAndDelete deletes a key-value pair if the current value matches the given old value.
+//
+// Deprecated: CompareAndDelete exists to implement common.KVStore. Use Set with the appropriate
+// options instead.
+//
+// Minimum server version: 5.36
+func (c *KVCache) CompareAndDelete(key string, oldValue interface{}) (bool, error) {
+	return c.Set(key, nil, SetAtomic(oldValue))
+}
+
+// SetAtomicWithRetries sets a key-value pair using compare and set semantics, invalidating the
+// key on every plugin instance. See KVService.SetAtomicWithRetries for details.
+//
+// Minimum server version: 5.36
+func (c *KVCache) SetAtomicWithRetries(key string, valueFunc func(oldValue []byte) (newValue interface{}, err error)) error {
+	if err := c.kv.SetAtomicWithRetries(key, valueFunc); err != nil {
+		return err
+	}
+
+	return c.invalidate(kvCacheInvalidation{Keys: []string{c.kv.namespace + key}})
+}
+
+// Delete deletes the given key-value pair and invalidates the key on every plugin instance.
+//
+// Minimum server version: 5.36
+func (c *KVCache) Delete(key string) error {
+	_, err := c.Set(key, nil)
+	return err
+}
+
+// DeleteAll removes all key-value pairs of the underlying KVService, and invalidates them on
+// every plugin instance.
+//
+// Minimum server version: 5.36
+func (c *KVCache) DeleteAll() error {
+	if err := c.kv.DeleteAll(); err != nil {
+		return err
+	}
+
+	if c.kv.namespace == "" {
+		return c.invalidate(kvCacheInvalidation{All: true})
+	}
+
+	return c.invalidate(kvCacheInvalidation{Prefix: c.kv.namespace})
+}
+
+// ListKeys lists the keys of the underlying KVService, without caching them.
+//
+// Minimum server version: 5.6
+func (c *KVCache) ListKeys(page, count int, options ...ListKeysOption) ([]string, error) {
+	return c.kv.ListKeys(page, count, options...)
+}
+
+// HandleClusterEvent applies cache invalidations published by other plugin instances. Call it
+// from the plugin's OnPluginClusterEvent hook. It returns false if the event is unrelated to
+// caching, and can be safely called for every cache the plugin uses.
+func (c *KVCache) HandleClusterEvent(ev model.PluginClusterEvent) bool {
+	if ev.Id != kvCacheInvalidateEventID {
+		return false
+	}
+
+	var invalidation kvCacheInvalidation
+	if err := json.Unmarshal(ev.Data, &invalidation); err != nil {
+		// Without knowing which keys changed, drop everything.
+		invalidation = kvCacheInvalidation{All: true}
+	}
+
+	c.invalidateLocally(invalidation)
+
+	return true
+}
+
+// Stats returns the number of cache hits and misses since the cache was created, and the number
+// of keys currently cached.
+func (c *KVCache) Stats() KVCacheStats {
+	c.lock.Lock()
+	entries := c.lru.Len()
+	c.lock.Unlock()
+
+	return KVCacheStats{
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+		Entries: entries,
+	}
+}
+
+// Purge drops every cached key on this plugin instance only.
+func (c *KVCache) Purge() {
+	c.invalidateLocally(kvCacheInvalidation{All: true})
+}
+
+// lookup returns the cached value for the given key, if cached and not expired.
+func (c *KVCache) lookup(cacheKey string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, ok := c.entries[cacheKey]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*kvCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, cacheKey)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+
+	return entry.data, true
+}
+
+// store caches the given value, unless the cache was invalidated since generation.
+func (c *KVCache) store(cacheKey string, data []byte, generation uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+
+	entry := &kvCacheEntry{
+		key:       cacheKey,
+		data:      data,
+		expiresAt: time.Now().Add(c.ttl),
+	}
+
+	if element, ok := c.entries[cacheKey]; ok {
+		element.Value = entry
+		c.lru.MoveToFront(element)
+		return
+	}
+
+	c.entries[cacheKey] = c.lru.PushFront(entry)
+
+	for c.lru.Len() > c.maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*kvCacheEntry).key)
+	}
+}
+
+// invalidate drops the given keys on this plugin instance, then on every other instance.
+func (c *KVCache) invalidate(invalidation kvCacheInvalidation) error {
+	c.invalidateLocally(invalidation)
+
+	data, err := json.Marshal(invalidation)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cache invalidation")
+	}
+
+	err = c.kv.api.PublishPluginClusterEvent(model.PluginClusterEvent{
+		Id:   kvCacheInvalidateEventID,
+		Data: data,
+	}, model.PluginClusterEventSendOptions{
+		SendType: model.PluginClusterEventSendTypeReliable,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to publish cache invalidation")
+	}
+
+	return nil
+}
+
+func (c *KVCache) invalidateLocally(invalidation kvCacheInvalidation) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+
+	if invalidation.All {
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		return
+	}
+
+	for _, key := range invalidation.Keys {
+		if element, ok := c.entries[key]; ok {
+			c.lru.Remove(element)
+			delete(c.entries, key)
+		}
+	}
+
+	if invalidation.Prefix != "" {
+		for key, element := range c.entries {
+			if strings.HasPrefix(key, invalidation.Prefix) {
+				c.lru.Remove(element)
+				delete(c.entries, key)
+			}
+		}
+	}
+}
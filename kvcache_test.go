@@ -0,0 +1,169 @@
+package pluginapi_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestKVCache(t *testing.T) {
+	t.Run("reads through and counts hits and misses", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		cache := pluginapi.NewKVCache(&client.KV, pluginapi.KVCacheOptions{})
+
+		values["key"] = []byte(`"value"`)
+
+		for i := 0; i < 3; i++ {
+			var out string
+			require.NoError(t, cache.Get("key", &out))
+			assert.Equal(t, "value", out)
+		}
+
+		var missing string
+		require.NoError(t, cache.Get("missing", &missing))
+		require.NoError(t, cache.Get("missing", &missing))
+		assert.Empty(t, missing)
+
+		api.AssertNumberOfCalls(t, "KVGet", 2)
+		assert.Equal(t, pluginapi.KVCacheStats{Hits: 3, Misses: 2, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("raw bytes are copied", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		cache := pluginapi.NewKVCache(&client.KV, pluginapi.KVCacheOptions{})
+
+		values["key"] = []byte("value")
+
+		for i := 0; i < 2; i++ {
+			var out []byte
+			require.NoError(t, cache.Get("key", &out))
+			assert.Equal(t, []byte("value"), out)
+			out[0] = 'V'
+		}
+
+		assert.Equal(t, []byte("value"), values["key"])
+	})
+
+	t.Run("entries expire", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		cache := pluginapi.NewKVCache(&client.KV, pluginapi.KVCacheOptions{TTL: 50 * time.Millisecond})
+
+		values["key"] = []byte(`"value"`)
+
+		var out string
+		require.NoError(t, cache.Get("key", &out))
+
+		values["key"] = []byte(`"new value"`)
+		require.NoError(t, cache.Get("key", &out))
+		assert.Equal(t, "value", out)
+
+		time.Sleep(60 * time.Millisecond)
+		require.NoError(t, cache.Get("key", &out))
+		assert.Equal(t, "new value", out)
+	})
+
+	t.Run("least recently used keys are evicted", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		cache := pluginapi.NewKVCache(&client.KV, pluginapi.KVCacheOptions{MaxEntries: 2})
+
+		values["a"] = []byte(`1`)
+		values["b"] = []byte(`2`)
+		values["c"] = []byte(`3`)
+
+		var out int
+		require.NoError(t, cache.Get("a", &out))
+		require.NoError(t, cache.Get("b", &out))
+		require.NoError(t, cache.Get("a", &out))
+		require.NoError(t, cache.Get("c", &out))
+		assert.Equal(t, 2, cache.Stats().Entries)
+
+		// b was evicted, a was not
+		require.NoError(t, cache.Get("a", &out))
+		require.NoError(t, cache.Get("b", &out))
+		assert.Equal(t, pluginapi.KVCacheStats{Hits: 2, Misses: 4, Entries: 2}, cache.Stats())
+	})
+
+	t.Run("writes invalidate every node", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		users := client.KV.Namespace("users")
+		cache := pluginapi.NewKVCache(users, pluginapi.KVCacheOptions{})
+		otherNode := pluginapi.NewKVCache(users, pluginapi.KVCacheOptions{})
+
+		var events []model.PluginClusterEvent
+		api.On("PublishPluginClusterEvent", mock.AnythingOfType("model.PluginClusterEvent"), model.PluginClusterEventSendOptions{
+			SendType: model.PluginClusterEventSendTypeReliable,
+		}).Run(func(args mock.Arguments) {
+			events = append(events, args.Get(0).(model.PluginClusterEvent))
+		}).Return(nil)
+
+		_, err := cache.Set("key", "value")
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+
+		var out string
+		require.NoError(t, cache.Get("key", &out))
+		require.NoError(t, otherNode.Get("key", &out))
+		assert.Equal(t, "value", out)
+
+		_, err = cache.Set("key", "new value")
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+
+		var invalidation map[string]interface{}
+		require.NoError(t, json.Unmarshal(events[1].Data, &invalidation))
+		assert.Equal(t, []interface{}{"users_key"}, invalidation["Keys"])
+
+		// The writing node sees the new value, the other node only after handling the event.
+		require.NoError(t, cache.Get("key", &out))
+		assert.Equal(t, "new value", out)
+		require.NoError(t, otherNode.Get("key", &out))
+		assert.Equal(t, "value", out)
+
+		assert.True(t, otherNode.HandleClusterEvent(events[1]))
+		require.NoError(t, otherNode.Get("key", &out))
+		assert.Equal(t, "new value", out)
+
+		require.NoError(t, cache.DeleteAll())
+		require.Len(t, events, 3)
+		assert.True(t, otherNode.HandleClusterEvent(events[2]))
+		assert.Equal(t, 0, otherNode.Stats().Entries)
+
+		assert.False(t, otherNode.HandleClusterEvent(model.PluginClusterEvent{Id: "other"}))
+	})
+
+	t.Run("failed atomic writes do not invalidate", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		cache := pluginapi.NewKVCache(&client.KV, pluginapi.KVCacheOptions{})
+
+		written, err := cache.CompareAndSet("key", "old", "new")
+		require.NoError(t, err)
+		assert.False(t, written)
+		api.AssertNotCalled(t, "PublishPluginClusterEvent", mock.Anything, mock.Anything)
+	})
+
+	t.Run("publish error", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		cache := pluginapi.NewKVCache(&client.KV, pluginapi.KVCacheOptions{})
+
+		api.On("PublishPluginClusterEvent", mock.Anything, mock.Anything).Return(newAppError())
+
+		written, err := cache.Set("key", "value")
+		require.Error(t, err)
+		assert.True(t, written)
+		assert.Equal(t, []byte(`"value"`), values["key"])
+	})
+}
@@ -0,0 +1,379 @@
+package pluginapi
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// KVCodec converts values to and from the bytes stored in the key-value store.
+type KVCodec interface {
+	// ID identifies the codec in the header of stored values, and must never change once values
+	// have been stored. IDs below 128 are reserved for codecs provided by this package.
+	ID() byte
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte, value interface{}) error
+}
+
+// KVCompressor compresses the bytes stored in the key-value store.
+type KVCompressor interface {
+	// ID identifies the compressor in the header of stored values, and must never change once
+	// values have been stored. IDs below 128 are reserved for compressors provided by this package.
+	ID() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+const (
+	jsonCodecID     byte = 1
+	gobCodecID      byte = 2
+	msgpackCodecID  byte = 3
+	protobufCodecID byte = 4
+
+	noCompressionID   byte = 0
+	gzipCompressionID byte = 1
+	zstdCompressionID byte = 2
+)
+
+var (
+	// JSONCodec encodes values as JSON. It is the default codec, and values it encodes are stored
+	// without a header unless compressed.
+	JSONCodec KVCodec = jsonCodec{}
+
+	// GobCodec encodes values using encoding/gob.
+	GobCodec KVCodec = gobCodec{}
+
+	// MsgpackCodec encodes values as MessagePack.
+	MsgpackCodec KVCodec = msgpackCodec{}
+
+	// ProtobufCodec encodes values implementing proto.Message as protocol buffers.
+	ProtobufCodec KVCodec = protobufCodec{}
+
+	// GzipCompressor compresses values using gzip.
+	GzipCompressor KVCompressor = gzipCompressor{}
+
+	// ZstdCompressor compresses values using zstd.
+	ZstdCompressor KVCompressor = zstdCompressor{}
+)
+
+var builtinCodecs = map[byte]KVCodec{
+	jsonCodecID:     JSONCodec,
+	gobCodecID:      GobCodec,
+	msgpackCodecID:  MsgpackCodec,
+	protobufCodecID: ProtobufCodec,
+}
+
+var builtinCompressors = map[byte]KVCompressor{
+	gzipCompressionID: GzipCompressor,
+	zstdCompressionID: ZstdCompressor,
+}
+
+// valueHeaderMagic marks values stored with a header describing how they were encoded. It starts
+// with a byte that never begins a JSON document, so values stored without a header are never
+// mistaken for ones with a header.
+var valueHeaderMagic = []byte{0xff, 'k', 'v'}
+
+const (
+	// valueHeaderVersion is the version of the header layout.
+	valueHeaderVersion byte = 1
+
+	// valueHeaderLength is the length of the magic, version, codec ID and compressor ID.
+	valueHeaderLength = 6
+)
+
+// kvEncoding configures how values are converted to the bytes stored in the key-value store.
+type kvEncoding struct {
+	codec                KVCodec
+	compressor           KVCompressor
+	compressionThreshold int
+}
+
+// WithCodec returns a KVService using the given codec to encode values, instead of JSON.
+//
+// Values are stored with a small header identifying their codec, so values stored with other
+// codecs, or before a codec was configured, can still be read. Values given as a []byte are
+// always stored as is.
+func (k *KVService) WithCodec(codec KVCodec) *KVService {
+	kv := *k
+	kv.encoding.codec = codec
+
+	return &kv
+}
+
+// WithCompression returns a KVService compressing encoded values whose length reaches the given
+// threshold in bytes. Values are decompressed transparently when read.
+func (k *KVService) WithCompression(compressor KVCompressor, threshold int) *KVService {
+	kv := *k
+	kv.encoding.compressor = compressor
+	kv.encoding.compressionThreshold = threshold
+
+	return &kv
+}
+
+// SetCodec configures the codec used to encode the value of a single Set operation.
+//
+// Values stored with a codec not provided by this package can only be read by a KVService
+// configured with the same codec using WithCodec.
+func SetCodec(codec KVCodec) KVSetOption {
+	return func(o *KVSetOptions) {
+		o.encoding.codec = codec
+	}
+}
+
+// SetCompression configures a single Set operation to compress the encoded value if its length
+// reaches the given threshold in bytes.
+func SetCompression(compressor KVCompressor, threshold int) KVSetOption {
+	return func(o *KVSetOptions) {
+		o.encoding.compressor = compressor
+		o.encoding.compressionThreshold = threshold
+	}
+}
+
+// encodeValue converts a value into its stored representation. Byte slices are stored as is.
+func (k *KVService) encodeValue(value interface{}, encoding kvEncoding) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	if valueBytes, ok := value.([]byte); ok {
+		return valueBytes, nil
+	}
+
+	codec := encoding.codec
+	if codec == nil {
+		codec = JSONCodec
+	}
+
+	data, err := codec.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal value %v", value)
+	}
+
+	compressionID := noCompressionID
+	if encoding.compressor != nil && len(data) >= encoding.compressionThreshold {
+		data, err = encoding.compressor.Compress(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to compress value")
+		}
+		compressionID = encoding.compressor.ID()
+	}
+
+	// Plain JSON is stored without a header, as it always was.
+	if codec.ID() == jsonCodecID && compressionID == noCompressionID {
+		return data, nil
+	}
+
+	header := make([]byte, 0, valueHeaderLength+len(data))
+	header = append(header, valueHeaderMagic...)
+	header = append(header, valueHeaderVersion, codec.ID(), compressionID)
+
+	return append(header, data...), nil
+}
+
+// decodeValue converts a stored representation into the given interface, the inverse of
+// encodeValue. Decoding into a *[]byte returns the stored representation as is.
+func (k *KVService) decodeValue(data []byte, o interface{}) error {
+	if bytesOut, ok := o.(*[]byte); ok {
+		*bytesOut = data
+		return nil
+	}
+
+	codec, payload, err := k.unwrapValue(data)
+	if err != nil {
+		return err
+	}
+
+	return codec.Unmarshal(payload, o)
+}
+
+// unwrapValue parses the header of a stored value, returning the codec it was encoded with and
+// the decompressed payload. Values without a header are assumed to be JSON.
+func (k *KVService) unwrapValue(data []byte) (KVCodec, []byte, error) {
+	if len(data) < valueHeaderLength || !bytes.HasPrefix(data, valueHeaderMagic) {
+		return JSONCodec, data, nil
+	}
+
+	version, codecID, compressionID := data[3], data[4], data[5]
+	if version != valueHeaderVersion {
+		return nil, nil, errors.Errorf("unsupported value header version %d", version)
+	}
+
+	codec, ok := builtinCodecs[codecID]
+	if !ok {
+		if k.encoding.codec == nil || k.encoding.codec.ID() != codecID {
+			return nil, nil, errors.Errorf("unknown codec %d", codecID)
+		}
+		codec = k.encoding.codec
+	}
+
+	payload := data[valueHeaderLength:]
+	if compressionID == noCompressionID {
+		return codec, payload, nil
+	}
+
+	compressor, ok := builtinCompressors[compressionID]
+	if !ok {
+		if k.encoding.compressor == nil || k.encoding.compressor.ID() != compressionID {
+			return nil, nil, errors.Errorf("unknown compressor %d", compressionID)
+		}
+		compressor = k.encoding.compressor
+	}
+
+	payload, err := compressor.Decompress(payload)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decompress value")
+	}
+
+	return codec, payload, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte { return jsonCodecID }
+
+func (jsonCodec) Marshal(value interface{}) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (jsonCodec) Unmarshal(data []byte, value interface{}) error {
+	return json.Unmarshal(data, value)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ID() byte { return gobCodecID }
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(value); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, value interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ID() byte { return msgpackCodecID }
+
+func (msgpackCodec) Marshal(value interface{}) ([]byte, error) {
+	return msgpack.Marshal(value)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, value interface{}) error {
+	return msgpack.Unmarshal(data, value)
+}
+
+type protobufCodec struct{}
+
+func (protobufCodec) ID() byte { return protobufCodecID }
+
+func (protobufCodec) Marshal(value interface{}) ([]byte, error) {
+	message, ok := value.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("%T does not implement proto.Message", value)
+	}
+
+	return proto.Marshal(message)
+}
+
+func (protobufCodec) Unmarshal(data []byte, value interface{}) error {
+	message, ok := value.(proto.Message)
+	if !ok {
+		// Allow decoding into a pointer to a message pointer, e.g. a *T in a TypedKV[T].
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Pointer {
+			return errors.Errorf("%T does not implement proto.Message", value)
+		}
+		if v.Elem().IsNil() {
+			v.Elem().Set(reflect.New(v.Elem().Type().Elem()))
+		}
+
+		message, ok = v.Elem().Interface().(proto.Message)
+		if !ok {
+			return errors.Errorf("%T does not implement proto.Message", value)
+		}
+	}
+
+	return proto.Unmarshal(data, message)
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) ID() byte { return gzipCompressionID }
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// initZstd creates the shared zstd encoder and decoder, which are safe for concurrent use.
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+
+	return zstdErr
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) ID() byte { return zstdCompressionID }
+
+func (zstdCompressor) Compress(data []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+
+	return zstdEncoder.EncodeAll(data, nil), nil
+}
+
+func (zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	if err := initZstd(); err != nil {
+		return nil, err
+	}
+
+	return zstdDecoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,195 @@
+package pluginapi_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+type codecValue struct {
+	Name  string
+	Items []string
+}
+
+func TestKVCodecs(t *testing.T) {
+	value := codecValue{Name: "a", Items: []string{"b", "c"}}
+
+	for name, codec := range map[string]pluginapi.KVCodec{
+		"json":    pluginapi.JSONCodec,
+		"gob":     pluginapi.GobCodec,
+		"msgpack": pluginapi.MsgpackCodec,
+	} {
+		codec := codec
+		t.Run(name, func(t *testing.T) {
+			api, values := newMemoryKVAPI(t)
+			client := pluginapi.NewClient(api, nil)
+			kv := client.KV.WithCodec(codec)
+
+			_, err := kv.Set("key", value)
+			require.NoError(t, err)
+
+			var out codecValue
+			err = kv.Get("key", &out)
+			require.NoError(t, err)
+			assert.Equal(t, value, out)
+
+			// The codec is read from the header, not the service configuration.
+			out = codecValue{}
+			err = client.KV.Get("key", &out)
+			require.NoError(t, err)
+			assert.Equal(t, value, out)
+
+			if codec == pluginapi.JSONCodec {
+				expected, _ := json.Marshal(value)
+				assert.Equal(t, expected, values["key"])
+			}
+		})
+	}
+
+	t.Run("protobuf", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithCodec(pluginapi.ProtobufCodec)
+
+		_, err := kv.Set("key", wrapperspb.String("a"))
+		require.NoError(t, err)
+
+		out := &wrapperspb.StringValue{}
+		err = kv.Get("key", out)
+		require.NoError(t, err)
+		assert.Equal(t, "a", out.GetValue())
+
+		typed := pluginapi.NewTypedKV[*wrapperspb.StringValue](kv)
+		typedOut, exists, err := typed.Get("key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.True(t, proto.Equal(wrapperspb.String("a"), typedOut))
+
+		_, err = kv.Set("other", value)
+		require.Error(t, err)
+	})
+
+	t.Run("per write codec", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		_, err := client.KV.Set("key", value, pluginapi.SetCodec(pluginapi.GobCodec))
+		require.NoError(t, err)
+		assert.Equal(t, byte(0xff), values["key"][0])
+
+		var out codecValue
+		err = client.KV.Get("key", &out)
+		require.NoError(t, err)
+		assert.Equal(t, value, out)
+	})
+
+	t.Run("values without a header are read as JSON", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		values["key"], _ = json.Marshal(value)
+
+		var out codecValue
+		err := client.KV.WithCodec(pluginapi.GobCodec).Get("key", &out)
+		require.NoError(t, err)
+		assert.Equal(t, value, out)
+	})
+
+	t.Run("bytes are stored as is", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithCodec(pluginapi.GobCodec).WithCompression(pluginapi.GzipCompressor, 0)
+
+		_, err := kv.Set("key", []byte{1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, []byte{1, 2}, values["key"])
+	})
+}
+
+func TestKVCompression(t *testing.T) {
+	value := codecValue{Name: strings.Repeat("a", 1000)}
+
+	for name, compressor := range map[string]pluginapi.KVCompressor{
+		"gzip": pluginapi.GzipCompressor,
+		"zstd": pluginapi.ZstdCompressor,
+	} {
+		compressor := compressor
+		t.Run(name, func(t *testing.T) {
+			api, values := newMemoryKVAPI(t)
+			client := pluginapi.NewClient(api, nil)
+			kv := client.KV.WithCompression(compressor, 100)
+
+			_, err := kv.Set("large", value)
+			require.NoError(t, err)
+			assert.Less(t, len(values["large"]), 100)
+
+			_, err = kv.Set("small", "a")
+			require.NoError(t, err)
+			assert.Equal(t, []byte(`"a"`), values["small"])
+
+			var out codecValue
+			err = client.KV.Get("large", &out)
+			require.NoError(t, err)
+			assert.Equal(t, value, out)
+
+			var small string
+			err = kv.Get("small", &small)
+			require.NoError(t, err)
+			assert.Equal(t, "a", small)
+		})
+	}
+
+	t.Run("set atomic with retries is given the decompressed value", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithCompression(pluginapi.GzipCompressor, 0)
+
+		_, err := kv.Set("key", value)
+		require.NoError(t, err)
+
+		err = kv.SetAtomicWithRetries("key", func(oldValue []byte) (interface{}, error) {
+			var old codecValue
+			if err := json.Unmarshal(oldValue, &old); err != nil {
+				return nil, err
+			}
+			old.Items = append(old.Items, "b")
+			return old, nil
+		})
+		require.NoError(t, err)
+
+		var out codecValue
+		err = kv.Get("key", &out)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"b"}, out.Items)
+	})
+
+	t.Run("compare and set with a compressed old value", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithCodec(pluginapi.GobCodec).WithCompression(pluginapi.ZstdCompressor, 0)
+
+		_, err := kv.Set("key", value)
+		require.NoError(t, err)
+
+		written, err := kv.CompareAndSet("key", value, codecValue{Name: "b"})
+		require.NoError(t, err)
+		assert.True(t, written)
+	})
+
+	t.Run("unknown codec", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		values["key"] = bytes.Join([][]byte{{0xff, 'k', 'v', 1, 200, 0}, []byte("{}")}, nil)
+
+		var out codecValue
+		err := client.KV.Get("key", &out)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,71 @@
+package kvds
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/pkg/errors"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+// Counter is an integer counter, such as the number of times an event occurred.
+//
+// A counter incremented often by many plugin instances can be spread across multiple keys, each
+// incremented independently and summed when read, so that increments rarely conflict.
+type Counter struct {
+	kv     *pluginapi.TypedKV[int64]
+	name   string
+	shards int
+}
+
+// NewCounter creates a Counter stored under keys starting with the given name, spread across the
+// given number of keys. The number of keys must not decrease once the counter was incremented.
+func NewCounter(kv *pluginapi.KVService, name string, shards int) *Counter {
+	if shards <= 0 {
+		shards = 1
+	}
+
+	return &Counter{
+		kv:     pluginapi.NewTypedKV[int64](kv),
+		name:   name,
+		shards: shards,
+	}
+}
+
+func (c *Counter) shardKey(i int) string {
+	return fmt.Sprintf("%s:%d", c.name, i)
+}
+
+// Incr adds delta, which may be negative, to the counter.
+//
+// Minimum server version: 5.18
+func (c *Counter) Incr(delta int64) error {
+	key := c.shardKey(rand.Intn(c.shards))
+
+	err := c.kv.Update(key, func(oldValue int64, exists bool) (int64, error) {
+		return oldValue + delta, nil
+	}, retryBackoff)
+	if err != nil {
+		return errors.Wrapf(err, "failed to increment %s", c.name)
+	}
+
+	return nil
+}
+
+// Get returns the value of the counter.
+//
+// Minimum server version: 5.2
+func (c *Counter) Get() (int64, error) {
+	var total int64
+	for i := 0; i < c.shards; i++ {
+		value, _, err := c.kv.Get(c.shardKey(i))
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to get %s", c.name)
+		}
+
+		total += value
+	}
+
+	return total, nil
+}
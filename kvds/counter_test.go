@@ -0,0 +1,37 @@
+package kvds_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-api/kvds"
+)
+
+func TestCounter(t *testing.T) {
+	kv, store := newMemoryKV(t)
+	counter := kvds.NewCounter(kv, "hits", 4)
+
+	value, err := counter.Get()
+	require.NoError(t, err)
+	assert.Zero(t, value)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, counter.Incr(2))
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, counter.Incr(-5))
+
+	value, err = counter.Get()
+	require.NoError(t, err)
+	assert.Equal(t, int64(35), value)
+	assert.LessOrEqual(t, len(store.keys("hits:")), 4)
+}
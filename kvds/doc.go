@@ -0,0 +1,7 @@
+// Package kvds implements data structures stored in the key-value store, safe for concurrent use
+// across multiple plugin instances in a Mattermost cluster.
+//
+// Each data structure is stored under keys starting with its name followed by a colon, so the
+// names of data structures sharing a KVService must not be prefixes of one another followed by a
+// colon. Giving each data structure its own namespace using KVService.Namespace avoids this.
+package kvds
@@ -0,0 +1,241 @@
+package kvds
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+// listMeta tracks the indexes of the elements of a List. Indexes only ever grow, so that pushing
+// and trimming never move elements between keys.
+type listMeta struct {
+	// Head is the index of the first element.
+	Head int64
+
+	// Tail is the index following the last element.
+	Tail int64
+}
+
+// List is a list of values, such as the most recent events, appended at the end and trimmed
+// from the start.
+//
+// Elements are stored in chunks of consecutive elements, each under its own key.
+type List[T any] struct {
+	kv        *pluginapi.KVService
+	name      string
+	chunkSize int64
+
+	meta   *pluginapi.TypedKV[listMeta]
+	chunks *pluginapi.TypedKV[map[int64]T]
+}
+
+// NewList creates a List stored under keys starting with the given name.
+func NewList[T any](kv *pluginapi.KVService, name string, options Options) *List[T] {
+	if options.ShardSize <= 0 {
+		options.ShardSize = defaultListChunkSize
+	}
+
+	return &List[T]{
+		kv:        kv,
+		name:      name,
+		chunkSize: int64(options.ShardSize),
+		meta:      pluginapi.NewTypedKV[listMeta](kv),
+		chunks:    pluginapi.NewTypedKV[map[int64]T](kv),
+	}
+}
+
+func (l *List[T]) chunkKey(chunk int64) string {
+	return fmt.Sprintf("%s:%d", l.name, chunk)
+}
+
+func (l *List[T]) loadMeta() (listMeta, error) {
+	meta, _, err := l.meta.Get(l.name)
+	if err != nil {
+		return listMeta{}, errors.Wrapf(err, "failed to get metadata of %s", l.name)
+	}
+
+	return meta, nil
+}
+
+// Push appends the given values to the end of the list.
+//
+// Values are appended in order, but may be interleaved with the values of concurrent pushes.
+// If the plugin stops while pushing, some of the values may be missing from the list.
+//
+// Minimum server version: 5.18
+func (l *List[T]) Push(values ...T) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	// Reserve indexes for the values, then write them.
+	var start int64
+	err := l.meta.Update(l.name, func(old listMeta, exists bool) (listMeta, error) {
+		start = old.Tail
+		old.Tail += int64(len(values))
+		return old, nil
+	}, retryBackoff)
+	if err != nil {
+		return errors.Wrapf(err, "failed to push to %s", l.name)
+	}
+
+	for i := 0; i < len(values); {
+		index := start + int64(i)
+		chunk := index / l.chunkSize
+
+		// Write all the values belonging to the same chunk at once.
+		end := i + int(l.chunkSize-index%l.chunkSize)
+		if end > len(values) {
+			end = len(values)
+		}
+
+		err = l.chunks.Update(l.chunkKey(chunk), func(elements map[int64]T, exists bool) (map[int64]T, error) {
+			if elements == nil {
+				elements = make(map[int64]T)
+			}
+			for j := i; j < end; j++ {
+				elements[start+int64(j)] = values[j]
+			}
+			return elements, nil
+		}, retryBackoff)
+		if err != nil {
+			return errors.Wrapf(err, "failed to push to %s", l.name)
+		}
+
+		i = end
+	}
+
+	// If the list was trimmed meanwhile, the values may have been written to deleted chunks.
+	meta, err := l.loadMeta()
+	if err != nil {
+		return err
+	}
+	for chunk := start / l.chunkSize; (chunk+1)*l.chunkSize <= meta.Head; chunk++ {
+		_ = l.kv.Delete(l.chunkKey(chunk))
+	}
+
+	return nil
+}
+
+// Trim removes elements from the start of the list, keeping at most the given number of
+// elements. An error is returned if the number is negative.
+//
+// Minimum server version: 5.18
+func (l *List[T]) Trim(maxLen int) error {
+	if maxLen < 0 {
+		return errors.Errorf("failed to trim %s: negative length %d", l.name, maxLen)
+	}
+
+	var oldHead, newHead int64
+	err := l.meta.Update(l.name, func(old listMeta, exists bool) (listMeta, error) {
+		oldHead = old.Head
+		if old.Tail-old.Head <= int64(maxLen) {
+			newHead = old.Head
+			return old, errUnchanged
+		}
+
+		old.Head = old.Tail - int64(maxLen)
+		newHead = old.Head
+		return old, nil
+	}, retryBackoff)
+	if errors.Is(err, errUnchanged) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to trim %s", l.name)
+	}
+
+	for chunk := oldHead / l.chunkSize; chunk < newHead/l.chunkSize; chunk++ {
+		if err = l.kv.Delete(l.chunkKey(chunk)); err != nil {
+			return errors.Wrapf(err, "failed to trim %s", l.name)
+		}
+	}
+
+	if newHead%l.chunkSize == 0 {
+		return nil
+	}
+
+	// Remove the trimmed elements of the chunk holding the new first element.
+	err = l.chunks.Update(l.chunkKey(newHead/l.chunkSize), func(elements map[int64]T, exists bool) (map[int64]T, error) {
+		if !exists {
+			return elements, errUnchanged
+		}
+		for index := range elements {
+			if index < newHead {
+				delete(elements, index)
+			}
+		}
+		return elements, nil
+	}, retryBackoff)
+	if err != nil && !errors.Is(err, errUnchanged) {
+		return errors.Wrapf(err, "failed to trim %s", l.name)
+	}
+
+	return nil
+}
+
+// Len returns the number of elements of the list.
+//
+// Minimum server version: 5.2
+func (l *List[T]) Len() (int, error) {
+	meta, err := l.loadMeta()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(meta.Tail - meta.Head), nil
+}
+
+// Range returns the elements from start to stop inclusive. Negative indexes count from the end
+// of the list, so Range(-10, -1) returns the last ten elements. Out of range indexes are
+// clamped to the list.
+//
+// Minimum server version: 5.2
+func (l *List[T]) Range(start, stop int) ([]T, error) {
+	meta, err := l.loadMeta()
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(meta.Tail - meta.Head)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	if start > stop {
+		return []T{}, nil
+	}
+
+	first := meta.Head + int64(start)
+	last := meta.Head + int64(stop)
+
+	values := make([]T, 0, stop-start+1)
+	for chunk := first / l.chunkSize; chunk <= last/l.chunkSize; chunk++ {
+		elements, _, err := l.chunks.Get(l.chunkKey(chunk))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get elements of %s", l.name)
+		}
+
+		for index := chunk * l.chunkSize; index < (chunk+1)*l.chunkSize; index++ {
+			if index < first || index > last {
+				continue
+			}
+
+			// Elements of an interrupted push are missing.
+			if value, ok := elements[index]; ok {
+				values = append(values, value)
+			}
+		}
+	}
+
+	return values, nil
+}
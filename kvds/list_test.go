@@ -0,0 +1,59 @@
+package kvds_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-api/kvds"
+)
+
+func TestList(t *testing.T) {
+	type event struct {
+		ID int
+	}
+
+	kv, store := newMemoryKV(t)
+	list := kvds.NewList[event](kv, "events", kvds.Options{ShardSize: 4})
+
+	values, err := list.Range(0, -1)
+	require.NoError(t, err)
+	assert.Empty(t, values)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, list.Push(event{ID: i}))
+	}
+	require.NoError(t, list.Push(event{ID: 10}, event{ID: 11}, event{ID: 12}))
+
+	length, err := list.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 13, length)
+
+	values, err = list.Range(-3, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []event{{ID: 10}, {ID: 11}, {ID: 12}}, values)
+
+	values, err = list.Range(2, 4)
+	require.NoError(t, err)
+	assert.Equal(t, []event{{ID: 2}, {ID: 3}, {ID: 4}}, values)
+
+	require.NoError(t, list.Trim(6))
+
+	values, err = list.Range(0, 100)
+	require.NoError(t, err)
+	assert.Equal(t, []event{{ID: 7}, {ID: 8}, {ID: 9}, {ID: 10}, {ID: 11}, {ID: 12}}, values)
+
+	// Chunks holding only trimmed elements are deleted.
+	assert.Equal(t, []string{"events:1", "events:2", "events:3"}, store.keys("events:"))
+
+	require.NoError(t, list.Trim(10))
+	length, err = list.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 6, length)
+
+	require.Error(t, list.Trim(-1))
+	length, err = list.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 6, length)
+}
@@ -0,0 +1,70 @@
+package kvds_test
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/mock"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+// memoryKV is a key-value store backing a mocked plugin API.
+type memoryKV struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+// keys returns the stored keys starting with the given prefix, sorted.
+func (m *memoryKV) keys(prefix string) []string {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var keys []string
+	for key := range m.values {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func newMemoryKV(t *testing.T) (*pluginapi.KVService, *memoryKV) {
+	t.Helper()
+
+	store := &memoryKV{values: make(map[string][]byte)}
+
+	api := &plugintest.API{}
+	api.On("KVGet", mock.AnythingOfType("string")).Return(func(key string) []byte {
+		store.lock.Lock()
+		defer store.lock.Unlock()
+		return store.values[key]
+	}, func(key string) *model.AppError {
+		return nil
+	}).Maybe()
+	api.On("KVSetWithOptions", mock.AnythingOfType("string"), mock.Anything, mock.AnythingOfType("model.PluginKVSetOptions")).Return(
+		func(key string, value []byte, options model.PluginKVSetOptions) bool {
+			store.lock.Lock()
+			defer store.lock.Unlock()
+			if options.Atomic && !bytes.Equal(store.values[key], options.OldValue) {
+				return false
+			}
+			if value == nil {
+				delete(store.values, key)
+			} else {
+				store.values[key] = value
+			}
+			return true
+		}, func(key string, value []byte, options model.PluginKVSetOptions) *model.AppError {
+			return nil
+		}).Maybe()
+
+	return &pluginapi.NewClient(api, nil).KV, store
+}
@@ -0,0 +1,34 @@
+package kvds
+
+import (
+	"time"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+const (
+	// defaultSetShardSize is the default maximum number of members of a set stored under a
+	// single key, before the set is spread across more keys.
+	defaultSetShardSize = 1000
+
+	// defaultListChunkSize is the default number of elements of a list stored under a single key.
+	defaultListChunkSize = 100
+)
+
+// Options configures a Set, SortedSet or List.
+type Options struct {
+	// ShardSize is the number of members of a Set or SortedSet stored under a single key before
+	// the members are spread across twice as many keys, or the number of elements of a List
+	// stored under a single key. Defaults to 1000 for sets and 100 for lists.
+	//
+	// The shard size of a List must not change once elements have been pushed.
+	ShardSize int
+}
+
+// retryBackoff spreads out the atomic writes of plugin instances contending for the same key.
+var retryBackoff = pluginapi.RetryBackoff(pluginapi.ExponentialBackoff{
+	InitialInterval: 5 * time.Millisecond,
+	MaxInterval:     200 * time.Millisecond,
+	Jitter:          0.5,
+	MaxElapsedTime:  5 * time.Second,
+})
@@ -0,0 +1,103 @@
+package kvds
+
+import (
+	"sort"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+// Set is a set of strings, such as the users subscribed to a channel.
+type Set struct {
+	m *shardedMap[struct{}]
+}
+
+// NewSet creates a Set stored under keys starting with the given name.
+func NewSet(kv *pluginapi.KVService, name string, options Options) *Set {
+	if options.ShardSize <= 0 {
+		options.ShardSize = defaultSetShardSize
+	}
+
+	return &Set{
+		m: newShardedMap[struct{}](kv, name, options.ShardSize),
+	}
+}
+
+// Add adds the given members to the set.
+//
+// Minimum server version: 5.18
+func (s *Set) Add(members ...string) error {
+	for _, member := range members {
+		member := member
+		err := s.m.update(member, func(members map[string]struct{}) bool {
+			if _, ok := members[member]; ok {
+				return false
+			}
+
+			members[member] = struct{}{}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Remove removes the given members from the set.
+//
+// Minimum server version: 5.18
+func (s *Set) Remove(members ...string) error {
+	for _, member := range members {
+		member := member
+		err := s.m.update(member, func(members map[string]struct{}) bool {
+			if _, ok := members[member]; !ok {
+				return false
+			}
+
+			delete(members, member)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Contains returns whether the given member is in the set.
+//
+// Minimum server version: 5.2
+func (s *Set) Contains(member string) (bool, error) {
+	_, ok, err := s.m.get(member)
+
+	return ok, err
+}
+
+// Members returns the members of the set, sorted.
+//
+// Minimum server version: 5.2
+func (s *Set) Members() ([]string, error) {
+	all, err := s.m.all()
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0, len(all))
+	for member := range all {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	return members, nil
+}
+
+// Len returns the number of members of the set.
+//
+// Minimum server version: 5.2
+func (s *Set) Len() (int, error) {
+	all, err := s.m.all()
+
+	return len(all), err
+}
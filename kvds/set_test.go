@@ -0,0 +1,87 @@
+package kvds_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-api/kvds"
+)
+
+func TestSet(t *testing.T) {
+	t.Run("add, remove and contains", func(t *testing.T) {
+		kv, _ := newMemoryKV(t)
+		set := kvds.NewSet(kv, "subscribers", kvds.Options{})
+
+		require.NoError(t, set.Add("bob", "alice", "bob"))
+
+		members, err := set.Members()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice", "bob"}, members)
+
+		ok, err := set.Contains("alice")
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		require.NoError(t, set.Remove("alice", "carol"))
+
+		ok, err = set.Contains("alice")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		length, err := set.Len()
+		require.NoError(t, err)
+		assert.Equal(t, 1, length)
+	})
+
+	t.Run("sharded past the shard size", func(t *testing.T) {
+		kv, store := newMemoryKV(t)
+		set := kvds.NewSet(kv, "subscribers", kvds.Options{ShardSize: 10})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 25; j++ {
+					assert.NoError(t, set.Add("user"+strconv.Itoa(i*25+j)))
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		length, err := set.Len()
+		require.NoError(t, err)
+		assert.Equal(t, 100, length)
+
+		// Only the shards of the current generation remain.
+		assert.Greater(t, len(store.keys("subscribers:")), 8)
+		var generations []string
+		for _, key := range store.keys("subscribers:") {
+			generations = append(generations, key[:len("subscribers:")+1])
+		}
+		assert.Len(t, uniqueStrings(generations), 1)
+
+		for i := 0; i < 100; i++ {
+			ok, err := set.Contains("user" + strconv.Itoa(i))
+			require.NoError(t, err)
+			assert.True(t, ok)
+		}
+	})
+}
+
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+
+	return unique
+}
@@ -0,0 +1,309 @@
+package kvds
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/pkg/errors"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+var (
+	// errUnchanged aborts an update that would not change the stored value.
+	errUnchanged = errors.New("unchanged")
+
+	// errFrozen aborts an update of a shard that is being resharded.
+	errFrozen = errors.New("shard is frozen")
+)
+
+// shardMeta describes how the members of a shardedMap are spread across keys.
+type shardMeta struct {
+	// Generation is incremented every time the members are resharded.
+	Generation int
+
+	// Shards is the number of keys the members are spread across.
+	Shards int
+}
+
+// shard holds the members of a shardedMap hashing to the same key.
+type shard[V any] struct {
+	// Frozen is set while resharding, after which the shard is no longer modified.
+	Frozen bool `json:",omitempty"`
+
+	Members map[string]V
+}
+
+// shardedMap is a map of members to values, spread across keys by hashing the members. Once a
+// shard grows past the shard size, the members are resharded across twice as many keys.
+//
+// Resharding first freezes every shard, so that they can be copied consistently, then writes the
+// new shards and finally updates the metadata, at which point the old shards are deleted. Any
+// plugin instance finding a frozen shard completes the resharding itself, so that a plugin
+// instance stopping while resharding never blocks the others. Writes made to a shard of an old
+// generation are redone on the new generation, so operations must be idempotent.
+type shardedMap[V any] struct {
+	kv        *pluginapi.KVService
+	name      string
+	shardSize int
+
+	meta   *pluginapi.TypedKV[shardMeta]
+	shards *pluginapi.TypedKV[shard[V]]
+}
+
+func newShardedMap[V any](kv *pluginapi.KVService, name string, shardSize int) *shardedMap[V] {
+	return &shardedMap[V]{
+		kv:        kv,
+		name:      name,
+		shardSize: shardSize,
+		meta:      pluginapi.NewTypedKV[shardMeta](kv),
+		shards:    pluginapi.NewTypedKV[shard[V]](kv),
+	}
+}
+
+// loadMeta returns the current metadata, and whether it was stored.
+func (m *shardedMap[V]) loadMeta() (shardMeta, bool, error) {
+	meta, exists, err := m.meta.Get(m.name)
+	if err != nil {
+		return shardMeta{}, false, errors.Wrapf(err, "failed to get metadata of %s", m.name)
+	}
+	if !exists {
+		meta = shardMeta{Shards: 1}
+	}
+
+	return meta, exists, nil
+}
+
+func (m *shardedMap[V]) shardKey(meta shardMeta, i int) string {
+	return fmt.Sprintf("%s:%d:%d", m.name, meta.Generation, i)
+}
+
+func shardIndex(member string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(member))
+
+	return int(h.Sum32() % uint32(shards))
+}
+
+// update applies f to the members of the shard holding the given member. f returns false if it
+// did not change the members.
+func (m *shardedMap[V]) update(member string, f func(members map[string]V) bool) error {
+	for {
+		meta, _, err := m.loadMeta()
+		if err != nil {
+			return err
+		}
+		key := m.shardKey(meta, shardIndex(member, meta.Shards))
+
+		size := 0
+		err = m.shards.Update(key, func(old shard[V], exists bool) (shard[V], error) {
+			if old.Frozen {
+				return old, errFrozen
+			}
+			if old.Members == nil {
+				old.Members = make(map[string]V)
+			}
+			if !f(old.Members) {
+				return old, errUnchanged
+			}
+
+			size = len(old.Members)
+			return old, nil
+		}, retryBackoff)
+		if errors.Is(err, errFrozen) {
+			if err = m.reshard(meta); err != nil {
+				return err
+			}
+			continue
+		} else if errors.Is(err, errUnchanged) {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to update %s", m.name)
+		}
+
+		// If the shard was resharded before being written, the write is lost, so redo it.
+		current, _, err := m.loadMeta()
+		if err != nil {
+			return err
+		}
+		if current.Generation != meta.Generation {
+			_ = m.kv.Delete(key)
+			continue
+		}
+
+		if size > m.shardSize {
+			return m.reshard(meta)
+		}
+
+		return nil
+	}
+}
+
+// get returns the value of the given member.
+func (m *shardedMap[V]) get(member string) (V, bool, error) {
+	for {
+		meta, _, err := m.loadMeta()
+		if err != nil {
+			var value V
+			return value, false, err
+		}
+
+		s, _, err := m.shards.Get(m.shardKey(meta, shardIndex(member, meta.Shards)))
+		if err != nil {
+			var value V
+			return value, false, errors.Wrapf(err, "failed to get %s", m.name)
+		}
+
+		if changed, err := m.resharded(meta); err != nil {
+			var value V
+			return value, false, err
+		} else if changed {
+			continue
+		}
+
+		value, ok := s.Members[member]
+		return value, ok, nil
+	}
+}
+
+// all returns every member and its value.
+func (m *shardedMap[V]) all() (map[string]V, error) {
+	for {
+		meta, _, err := m.loadMeta()
+		if err != nil {
+			return nil, err
+		}
+
+		members := make(map[string]V)
+		for i := 0; i < meta.Shards; i++ {
+			s, _, err := m.shards.Get(m.shardKey(meta, i))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to get %s", m.name)
+			}
+
+			for member, value := range s.Members {
+				members[member] = value
+			}
+		}
+
+		if changed, err := m.resharded(meta); err != nil {
+			return nil, err
+		} else if changed {
+			continue
+		}
+
+		return members, nil
+	}
+}
+
+// resharded returns whether the members were resharded since the given metadata was loaded, in
+// which case the shards read may have been deleted.
+func (m *shardedMap[V]) resharded(meta shardMeta) (bool, error) {
+	current, _, err := m.loadMeta()
+	if err != nil {
+		return false, err
+	}
+
+	return current.Generation != meta.Generation, nil
+}
+
+// reshard spreads the members of the given generation across twice as many keys, unless that
+// generation was already resharded.
+func (m *shardedMap[V]) reshard(meta shardMeta) error {
+	if changed, err := m.resharded(meta); err != nil || changed {
+		return err
+	}
+
+	members := make(map[string]V)
+	for i := 0; i < meta.Shards; i++ {
+		err := m.shards.Update(m.shardKey(meta, i), func(old shard[V], exists bool) (shard[V], error) {
+			for member, value := range old.Members {
+				members[member] = value
+			}
+			if old.Frozen {
+				return old, errUnchanged
+			}
+
+			old.Frozen = true
+			return old, nil
+		}, retryBackoff)
+		if err != nil && !errors.Is(err, errUnchanged) {
+			return errors.Wrapf(err, "failed to freeze shard of %s", m.name)
+		}
+	}
+
+	// If another plugin instance completed the resharding meanwhile, the frozen shards created
+	// by this one must be deleted.
+	if changed, err := m.resharded(meta); err != nil {
+		return err
+	} else if changed {
+		m.deleteShards(meta)
+		return nil
+	}
+
+	next := shardMeta{
+		Generation: meta.Generation + 1,
+		Shards:     meta.Shards * 2,
+	}
+
+	shards := make([]shard[V], next.Shards)
+	for member, value := range members {
+		i := shardIndex(member, next.Shards)
+		if shards[i].Members == nil {
+			shards[i].Members = make(map[string]V)
+		}
+		shards[i].Members[member] = value
+	}
+
+	var written []int
+	for i, s := range shards {
+		// Shards already written by another plugin instance resharding the same generation hold
+		// the same members, and may have been modified since the metadata was updated.
+		ok, err := m.shards.Set(m.shardKey(next, i), s, pluginapi.SetAtomic(nil))
+		if err != nil {
+			return errors.Wrapf(err, "failed to write shard of %s", m.name)
+		}
+		if ok {
+			written = append(written, i)
+		}
+	}
+
+	var setOptions []pluginapi.KVSetOption
+	if _, exists, err := m.loadMeta(); err != nil {
+		return err
+	} else if exists {
+		setOptions = append(setOptions, pluginapi.SetAtomic(meta))
+	} else {
+		setOptions = append(setOptions, pluginapi.SetAtomic(nil))
+	}
+	updated, err := m.meta.Set(m.name, next, setOptions...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to update metadata of %s", m.name)
+	}
+
+	if !updated {
+		// If the new generation was itself resharded meanwhile, the shards written by this
+		// plugin instance were created after that generation was deleted.
+		current, _, loadErr := m.loadMeta()
+		if loadErr != nil {
+			return loadErr
+		}
+		if current.Generation > next.Generation {
+			for _, i := range written {
+				_ = m.kv.Delete(m.shardKey(next, i))
+			}
+		}
+	}
+
+	m.deleteShards(meta)
+
+	return nil
+}
+
+// deleteShards deletes the shards of the given generation. Shards that could not be deleted are
+// never read again.
+func (m *shardedMap[V]) deleteShards(meta shardMeta) {
+	for i := 0; i < meta.Shards; i++ {
+		_ = m.kv.Delete(m.shardKey(meta, i))
+	}
+}
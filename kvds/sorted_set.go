@@ -0,0 +1,118 @@
+package kvds
+
+import (
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+// ScoredMember is a member of a SortedSet and its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// SortedSet is a set of strings each associated with a score, such as a leaderboard.
+//
+// Members are stored unordered, so ranges are computed by reading every member.
+type SortedSet struct {
+	m *shardedMap[float64]
+}
+
+// NewSortedSet creates a SortedSet stored under keys starting with the given name.
+func NewSortedSet(kv *pluginapi.KVService, name string, options Options) *SortedSet {
+	if options.ShardSize <= 0 {
+		options.ShardSize = defaultSetShardSize
+	}
+
+	return &SortedSet{
+		m: newShardedMap[float64](kv, name, options.ShardSize),
+	}
+}
+
+// Add adds the given member to the set with the given score, replacing its score if already in
+// the set. The score must be a finite number.
+//
+// Minimum server version: 5.18
+func (s *SortedSet) Add(member string, score float64) error {
+	if math.IsNaN(score) || math.IsInf(score, 0) {
+		return errors.Errorf("failed to add %s: invalid score %v", member, score)
+	}
+
+	return s.m.update(member, func(members map[string]float64) bool {
+		if current, ok := members[member]; ok && current == score {
+			return false
+		}
+
+		members[member] = score
+		return true
+	})
+}
+
+// Remove removes the given members from the set.
+//
+// Minimum server version: 5.18
+func (s *SortedSet) Remove(members ...string) error {
+	for _, member := range members {
+		member := member
+		err := s.m.update(member, func(members map[string]float64) bool {
+			if _, ok := members[member]; !ok {
+				return false
+			}
+
+			delete(members, member)
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Score returns the score of the given member, and whether it is in the set.
+//
+// Minimum server version: 5.2
+func (s *SortedSet) Score(member string) (float64, bool, error) {
+	return s.m.get(member)
+}
+
+// RangeByScore returns the members whose score is between min and max inclusive, ordered by
+// score, then by member.
+//
+// Minimum server version: 5.2
+func (s *SortedSet) RangeByScore(min, max float64) ([]ScoredMember, error) {
+	all, err := s.m.all()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []ScoredMember
+	for member, score := range all {
+		if score >= min && score <= max {
+			members = append(members, ScoredMember{Member: member, Score: score})
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score != members[j].Score {
+			return members[i].Score < members[j].Score
+		}
+		return members[i].Member < members[j].Member
+	})
+
+	return members, nil
+}
+
+// Len returns the number of members of the set.
+//
+// Minimum server version: 5.2
+func (s *SortedSet) Len() (int, error) {
+	all, err := s.m.all()
+
+	return len(all), err
+}
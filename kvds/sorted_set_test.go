@@ -0,0 +1,49 @@
+package kvds_test
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-plugin-api/kvds"
+)
+
+func TestSortedSet(t *testing.T) {
+	kv, _ := newMemoryKV(t)
+	set := kvds.NewSortedSet(kv, "scores", kvds.Options{ShardSize: 4})
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, set.Add("user"+strconv.Itoa(i), float64(i%10)))
+	}
+	require.NoError(t, set.Add("user0", 9.5))
+	require.Error(t, set.Add("user0", math.NaN()))
+	require.Error(t, set.Add("user0", math.Inf(1)))
+	require.Error(t, set.Add("user0", math.Inf(-1)))
+	require.NoError(t, set.Remove("user1", "user11"))
+
+	score, ok, err := set.Score("user0")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 9.5, score)
+
+	_, ok, err = set.Score("user1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	members, err := set.RangeByScore(8, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []kvds.ScoredMember{
+		{Member: "user18", Score: 8},
+		{Member: "user8", Score: 8},
+		{Member: "user19", Score: 9},
+		{Member: "user9", Score: 9},
+		{Member: "user0", Score: 9.5},
+	}, members)
+
+	length, err := set.Len()
+	require.NoError(t, err)
+	assert.Equal(t, 18, length)
+}
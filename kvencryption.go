@@ -0,0 +1,184 @@
+package pluginapi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+)
+
+// encryptedValueMagic marks values encrypted by the service. Like valueHeaderMagic, it starts
+// with a byte that never begins a JSON document.
+var encryptedValueMagic = []byte{0xff, 'k', 'e'}
+
+const (
+	// encryptedValueVersion is the version of the encrypted value layout.
+	encryptedValueVersion byte = 1
+
+	// encryptionKeyIDLength is the length of the identifier of the key a value was encrypted with.
+	encryptionKeyIDLength = 4
+
+	// encryptedValueHeaderLength is the length of the magic, version and key ID.
+	encryptedValueHeaderLength = 4 + encryptionKeyIDLength
+
+	// encryptionKeyInfo is the context label the encryption key is derived from a secret with.
+	encryptionKeyInfo = "mattermost-plugin-api kv encryption v1"
+)
+
+// kvEncryption encrypts and decrypts stored values using AES-GCM.
+type kvEncryption struct {
+	// keys holds the current key first, followed by previous keys still accepted for decryption.
+	keys []kvEncryptionKey
+}
+
+type kvEncryptionKey struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// WithEncryption returns a KVService encrypting values at rest with AES-GCM, using a 256-bit key
+// derived from the given secret, e.g. a randomly generated plugin configuration setting. Values
+// are bound to the key they are stored under, and fail to decrypt if copied to another key.
+//
+// To rotate keys, pass the new secret as key and the secrets used before as previousKeys. Values
+// are decrypted with whichever key they were encrypted with, and written with the current key.
+// Values are not re-encrypted when read: to stop accepting a previous key, re-write the values
+// encrypted with it, e.g. with a KVMigration calling Get then Set for each key. Values stored
+// before encryption was enabled are read as is until written again.
+//
+// Keys remain in plain text, and values written by a KVService without encryption are stored
+// unencrypted.
+func (k *KVService) WithEncryption(key string, previousKeys ...string) *KVService {
+	encryption := &kvEncryption{}
+	for _, secret := range append([]string{key}, previousKeys...) {
+		if secret == "" {
+			continue
+		}
+
+		encryption.keys = append(encryption.keys, newKVEncryptionKey(secret))
+	}
+
+	kv := *k
+	kv.encryption = encryption
+
+	return &kv
+}
+
+func newKVEncryptionKey(secret string) kvEncryptionKey {
+	key := make([]byte, 32)
+
+	// Neither reading the derived key nor the calls below can fail given a 256-bit key and the
+	// default nonce size.
+	_, _ = io.ReadFull(hkdf.New(sha256.New, []byte(secret), nil, []byte(encryptionKeyInfo)), key)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	id := sha256.Sum256(key)
+
+	return kvEncryptionKey{
+		id:   id[:encryptionKeyIDLength],
+		aead: aead,
+	}
+}
+
+// additionalData returns the data authenticated along with a value stored under the given key:
+// the header of the encrypted value, followed by the key.
+func additionalData(header []byte, storeKey string) []byte {
+	ad := make([]byte, 0, len(header)+len(storeKey))
+	ad = append(ad, header...)
+	return append(ad, storeKey...)
+}
+
+// encrypt encrypts the given value stored under the given key with the current key.
+func (e *kvEncryption) encrypt(storeKey string, data []byte) ([]byte, error) {
+	if len(e.keys) == 0 {
+		return nil, errors.New("no encryption key configured")
+	}
+	key := e.keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+
+	header := make([]byte, 0, encryptedValueHeaderLength+len(nonce)+len(data)+key.aead.Overhead())
+	header = append(header, encryptedValueMagic...)
+	header = append(header, encryptedValueVersion)
+	header = append(header, key.id...)
+	header = append(header, nonce...)
+
+	return key.aead.Seal(header, nonce, data, additionalData(header[:encryptedValueHeaderLength], storeKey)), nil
+}
+
+// decrypt decrypts the given value stored under the given key. Values that are not encrypted are
+// returned as is.
+func (e *kvEncryption) decrypt(storeKey string, data []byte) ([]byte, error) {
+	if len(data) < encryptedValueHeaderLength || !bytes.HasPrefix(data, encryptedValueMagic) {
+		return data, nil
+	}
+
+	if version := data[3]; version != encryptedValueVersion {
+		return nil, errors.Errorf("unsupported encrypted value version %d", version)
+	}
+
+	keyID := data[4:encryptedValueHeaderLength]
+	for _, key := range e.keys {
+		if !bytes.Equal(key.id, keyID) {
+			continue
+		}
+
+		nonce := data[encryptedValueHeaderLength:]
+		if len(nonce) < key.aead.NonceSize() {
+			return nil, errors.New("encrypted value is too short")
+		}
+		ciphertext := nonce[key.aead.NonceSize():]
+		nonce = nonce[:key.aead.NonceSize()]
+
+		value, err := key.aead.Open(nil, nonce, ciphertext, additionalData(data[:encryptedValueHeaderLength], storeKey))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt value")
+		}
+
+		return value, nil
+	}
+
+	return nil, errors.New("value was encrypted with an unknown key")
+}
+
+// decryptValue decrypts a value read from the given key.
+func (k *KVService) decryptValue(storeKey string, data []byte) ([]byte, error) {
+	if k.encryption == nil || len(data) == 0 {
+		return data, nil
+	}
+
+	return k.encryption.decrypt(storeKey, data)
+}
+
+// encryptedOldValue returns the value to compare against the stored value of an atomic write
+// expecting the given unencrypted old value. As encryption is not deterministic, the stored value
+// is read back and used only if it decrypts to the expected old value.
+func (k *KVService) encryptedOldValue(storeKey string, oldValue []byte) (storedValue []byte, matches bool, err error) {
+	if oldValue == nil {
+		return nil, true, nil
+	}
+
+	data, appErr := k.api.KVGet(storeKey)
+	if appErr != nil {
+		return nil, false, normalizeAppErr(appErr)
+	}
+	if len(data) == 0 {
+		return nil, false, nil
+	}
+
+	value, err := k.encryption.decrypt(storeKey, data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return data, bytes.Equal(value, oldValue), nil
+}
@@ -0,0 +1,165 @@
+package pluginapi_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestKVEncryption(t *testing.T) {
+	t.Run("values are encrypted at rest", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithEncryption("secret")
+
+		_, err := kv.Set("token", "some token")
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(values["token"], []byte("some token")))
+
+		var out string
+		err = kv.Get("token", &out)
+		require.NoError(t, err)
+		assert.Equal(t, "some token", out)
+
+		_, err = kv.Set("payload", []byte("some payload"))
+		require.NoError(t, err)
+		assert.False(t, bytes.Contains(values["payload"], []byte("some payload")))
+
+		var payload []byte
+		err = kv.Get("payload", &payload)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("some payload"), payload)
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		_, err := client.KV.WithEncryption("secret").Set("token", "some token")
+		require.NoError(t, err)
+
+		var out string
+		err = client.KV.WithEncryption("other secret").Get("token", &out)
+		require.Error(t, err)
+		assert.Empty(t, out)
+	})
+
+	t.Run("values are bound to their key", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithEncryption("secret")
+
+		_, err := kv.Set("token", "some token")
+		require.NoError(t, err)
+		values["other"] = values["token"]
+
+		var out string
+		err = kv.Get("other", &out)
+		require.Error(t, err)
+		assert.Empty(t, out)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		tokens := client.KV.Namespace("tokens")
+
+		_, err := tokens.WithEncryption("old secret").Set("token", "some token")
+		require.NoError(t, err)
+		oldCiphertext := values["tokens_token"]
+
+		rotated := tokens.WithEncryption("new secret", "old secret")
+
+		// Previous keys are accepted, but values are not re-encrypted on read.
+		var out string
+		err = rotated.Get("token", &out)
+		require.NoError(t, err)
+		assert.Equal(t, "some token", out)
+		assert.Equal(t, oldCiphertext, values["tokens_token"])
+
+		err = rotated.Migrate(pluginapi.KVMigration{
+			Version: 1,
+			Migrate: func(kv *pluginapi.KVService, key string) error {
+				var value string
+				if err := kv.Get(key, &value); err != nil {
+					return err
+				}
+
+				_, err := kv.Set(key, value)
+				return err
+			},
+		})
+		require.NoError(t, err)
+
+		// Only the new key is now required.
+		out = ""
+		err = tokens.WithEncryption("new secret").Get("token", &out)
+		require.NoError(t, err)
+		assert.Equal(t, "some token", out)
+	})
+
+	t.Run("unencrypted values are read as is", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		_, err := client.KV.Set("token", "some token")
+		require.NoError(t, err)
+		plaintext := values["token"]
+
+		var out string
+		err = client.KV.WithEncryption("secret").Get("token", &out)
+		require.NoError(t, err)
+		assert.Equal(t, "some token", out)
+		assert.Equal(t, plaintext, values["token"])
+	})
+
+	t.Run("atomic writes compare decrypted values", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.WithEncryption("secret")
+
+		written, err := kv.Set("counter", 1, pluginapi.SetAtomic(nil))
+		require.NoError(t, err)
+		assert.True(t, written)
+
+		written, err = kv.CompareAndSet("counter", 2, 3)
+		require.NoError(t, err)
+		assert.False(t, written)
+
+		written, err = kv.CompareAndSet("counter", 1, 2)
+		require.NoError(t, err)
+		assert.True(t, written)
+
+		err = pluginapi.NewTypedKV[int](kv).Update("counter", func(oldValue int, exists bool) (int, error) {
+			return oldValue + 1, nil
+		})
+		require.NoError(t, err)
+
+		var out int
+		err = kv.Get("counter", &out)
+		require.NoError(t, err)
+		assert.Equal(t, 3, out)
+
+		written, err = kv.CompareAndDelete("counter", 3)
+		require.NoError(t, err)
+		assert.True(t, written)
+	})
+
+	t.Run("combined with a codec and compression", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		kv := client.KV.Namespace("tokens").WithCodec(pluginapi.GobCodec).WithCompression(pluginapi.GzipCompressor, 0).WithEncryption("secret")
+
+		_, err := kv.Set("token", codecValue{Name: "a"})
+		require.NoError(t, err)
+
+		var out codecValue
+		err = kv.Get("token", &out)
+		require.NoError(t, err)
+		assert.Equal(t, codecValue{Name: "a"}, out)
+	})
+}
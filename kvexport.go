@@ -0,0 +1,272 @@
+package pluginapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrKVImportConflict is returned by Import when a key already exists and the conflict policy is
+// KVImportFailOnExisting.
+var ErrKVImportConflict = errors.New("key already exists")
+
+// KVImportConflictPolicy determines how Import handles keys that already exist.
+type KVImportConflictPolicy int
+
+const (
+	// KVImportSkipExisting leaves existing keys unchanged.
+	KVImportSkipExisting KVImportConflictPolicy = iota
+
+	// KVImportOverwriteExisting replaces the value of existing keys.
+	KVImportOverwriteExisting
+
+	// KVImportFailOnExisting stops the import with ErrKVImportConflict.
+	KVImportFailOnExisting
+)
+
+// kvImportBatchSize is the number of records imported between progress reports.
+const kvImportBatchSize = 100
+
+// KVExportOptions configures an Export operation.
+type KVExportOptions struct {
+	// Prefixes restricts the export to the keys starting with any of the given prefixes. All keys
+	// are exported if empty.
+	Prefixes []string
+}
+
+// KVImportOptions configures an Import operation.
+type KVImportOptions struct {
+	// Prefixes restricts the import to the keys starting with any of the given prefixes. All keys
+	// are imported if empty.
+	Prefixes []string
+
+	// DryRun reports what would be imported without writing anything.
+	DryRun bool
+
+	// OnConflict determines how keys that already exist are handled.
+	OnConflict KVImportConflictPolicy
+
+	// Skip is the number of records to skip before importing, used to resume an interrupted import
+	// from the Processed count of its last progress report.
+	Skip int
+
+	// Progress, if set, is called after every batch of records and once the import completes.
+	Progress func(result KVImportResult)
+}
+
+// KVImportResult reports the progress of an Import operation.
+type KVImportResult struct {
+	// Processed is the number of records read, including skipped ones.
+	Processed int
+
+	// Imported is the number of keys written, or that would be written during a dry run.
+	Imported int
+
+	// Skipped is the number of keys left unchanged because they already existed, or did not
+	// match the prefixes.
+	Skipped int
+}
+
+// kvRecord is a single line of an export. Values that are valid, compact JSON are exported as is
+// to keep exports readable, other values are exported as base64 encoded data, so that every value
+// is imported exactly as stored.
+type kvRecord struct {
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+	Data  []byte          `json:"data,omitempty"`
+}
+
+// Export writes all key-value pairs matching the given options to w as JSON Lines, returning
+// the number of key-value pairs exported.
+//
+// Values are exported decrypted, but otherwise as stored. Expiry times are not exported. Keys
+// reserved for use by this package are never exported, but other keys used by the cluster
+// package are unless filtered out with Prefixes.
+//
+// Minimum server version: 5.6
+func (k *KVService) Export(w io.Writer, options KVExportOptions) (int, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+
+	count := 0
+	it := k.Scan(withAnyPrefix(options.Prefixes))
+	for it.Next() {
+		if strings.HasPrefix(it.Key(), internalKeyPrefix) {
+			continue
+		}
+
+		var data []byte
+		if err := it.Value(&data); err != nil {
+			return count, errors.Wrapf(err, "failed to get value for key %s", it.Key())
+		}
+		if len(data) == 0 {
+			// The key was deleted since it was listed.
+			continue
+		}
+
+		record := kvRecord{Key: it.Key()}
+		if isCompactJSON(data) {
+			record.Value = data
+		} else {
+			record.Data = data
+		}
+
+		if err := encoder.Encode(record); err != nil {
+			return count, errors.Wrap(err, "failed to write record")
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		return count, errors.Wrap(err, "failed to list keys")
+	}
+
+	return count, nil
+}
+
+// isCompactJSON reports whether the given data is valid JSON that encoding/json would write
+// unchanged.
+func isCompactJSON(data []byte) bool {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return false
+	}
+
+	return bytes.Equal(buf.Bytes(), data)
+}
+
+// Import reads key-value pairs written by Export from r, storing them according to the given
+// options.
+//
+// Records are streamed, so imports of any size use constant memory. If an import is interrupted,
+// it can be resumed by importing the same input again with Skip set to the Processed count of
+// the last progress report.
+//
+// Minimum server version: 5.18
+func (k *KVService) Import(r io.Reader, options KVImportOptions) (KVImportResult, error) {
+	var result KVImportResult
+
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return result, errors.Wrap(readErr, "failed to read record")
+		}
+
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 {
+			if result.Processed < options.Skip {
+				result.Processed++
+			} else {
+				if err := k.importRecord(data, options, &result); err != nil {
+					return result, errors.Wrapf(err, "failed to import line %d", line)
+				}
+
+				if options.Progress != nil && result.Processed%kvImportBatchSize == 0 {
+					options.Progress(result)
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+	}
+
+	if options.Progress != nil {
+		options.Progress(result)
+	}
+
+	return result, nil
+}
+
+func (k *KVService) importRecord(data []byte, options KVImportOptions, result *KVImportResult) error {
+	var record kvRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return errors.Wrap(err, "failed to decode record")
+	}
+
+	value := record.Data
+	if record.Value != nil {
+		value = record.Value
+	}
+
+	keep, _ := withAnyPrefixChecker(options.Prefixes)(record.Key)
+	if record.Key == "" || !keep {
+		result.Processed++
+		result.Skipped++
+		return nil
+	}
+
+	if options.DryRun {
+		if options.OnConflict != KVImportOverwriteExisting {
+			var existing []byte
+			if err := k.Get(record.Key, &existing); err != nil {
+				return errors.Wrapf(err, "failed to get value for key %s", record.Key)
+			}
+
+			if len(existing) > 0 {
+				if options.OnConflict == KVImportFailOnExisting {
+					return errors.Wrap(ErrKVImportConflict, record.Key)
+				}
+
+				result.Processed++
+				result.Skipped++
+				return nil
+			}
+		}
+
+		result.Processed++
+		result.Imported++
+		return nil
+	}
+
+	var setOptions []KVSetOption
+	if options.OnConflict != KVImportOverwriteExisting {
+		setOptions = append(setOptions, SetAtomic(nil))
+	}
+
+	written, err := k.Set(record.Key, value, setOptions...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to set value for key %s", record.Key)
+	}
+
+	if !written {
+		if options.OnConflict == KVImportFailOnExisting {
+			return errors.Wrap(ErrKVImportConflict, record.Key)
+		}
+
+		result.Processed++
+		result.Skipped++
+		return nil
+	}
+
+	result.Processed++
+	result.Imported++
+	return nil
+}
+
+// withAnyPrefix only returns keys that start with any of the given prefixes, or all keys if no
+// prefixes are given.
+func withAnyPrefix(prefixes []string) ListKeysOption {
+	return WithChecker(withAnyPrefixChecker(prefixes))
+}
+
+func withAnyPrefixChecker(prefixes []string) func(key string) (bool, error) {
+	return func(key string) (bool, error) {
+		if len(prefixes) == 0 {
+			return true, nil
+		}
+
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+}
@@ -0,0 +1,171 @@
+package pluginapi_test
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestKVExport(t *testing.T) {
+	api, values := newMemoryKVAPI(t)
+	client := pluginapi.NewClient(api, nil)
+
+	values["config_a"] = []byte(`{"enabled":true}`)
+	values["config_b"] = []byte{0xff, 0x00}
+	values["config_c"] = []byte(`{"enabled": true}`)
+	values["config_d"] = []byte(`"<b>&</b>"`)
+	values["user_1"] = []byte(`"jane"`)
+	values["mmi_botid"] = []byte(`"bot"`)
+
+	var buf bytes.Buffer
+	count, err := client.KV.Export(&buf, pluginapi.KVExportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, `{"key":"config_a","value":{"enabled":true}}
+{"key":"config_b","data":"/wA="}
+{"key":"config_c","data":"eyJlbmFibGVkIjogdHJ1ZX0="}
+{"key":"config_d","value":"<b>&</b>"}
+{"key":"user_1","value":"jane"}
+`, buf.String())
+
+	buf.Reset()
+	count, err = client.KV.Export(&buf, pluginapi.KVExportOptions{Prefixes: []string{"user_"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, `{"key":"user_1","value":"jane"}`+"\n", buf.String())
+
+	t.Run("encrypted values are exported decrypted", func(t *testing.T) {
+		encrypted := client.KV.Namespace("secrets").WithEncryption("secret")
+		_, err := encrypted.Set("token", "abc")
+		require.NoError(t, err)
+
+		buf.Reset()
+		count, err := encrypted.Export(&buf, pluginapi.KVExportOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Equal(t, `{"key":"token","value":"abc"}`+"\n", buf.String())
+	})
+}
+
+func TestKVImport(t *testing.T) {
+	input := `{"key":"config_a","value":{"enabled":true}}
+{"key":"config_b","data":"/wA="}
+
+{"key":"user_1","value":"jane"}
+`
+
+	t.Run("imports into an empty store", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		result, err := client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 3, Imported: 3}, result)
+		assert.Equal(t, []byte(`{"enabled":true}`), values["config_a"])
+		assert.Equal(t, []byte{0xff, 0x00}, values["config_b"])
+		assert.Equal(t, []byte(`"jane"`), values["user_1"])
+	})
+
+	t.Run("round trip", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		_, err := client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{})
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		_, err = client.KV.Export(&buf, pluginapi.KVExportOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, strings.ReplaceAll(input, "\n\n", "\n"), buf.String())
+	})
+
+	t.Run("prefixes", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		result, err := client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{Prefixes: []string{"config_"}})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 3, Imported: 2, Skipped: 1}, result)
+		assert.Nil(t, values["user_1"])
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		values["user_1"] = []byte(`"john"`)
+
+		result, err := client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 3, Imported: 2, Skipped: 1}, result)
+		assert.Len(t, values, 1)
+
+		_, err = client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{DryRun: true, OnConflict: pluginapi.KVImportFailOnExisting})
+		require.True(t, errors.Is(err, pluginapi.ErrKVImportConflict))
+	})
+
+	t.Run("conflict policies", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		values["user_1"] = []byte(`"john"`)
+		result, err := client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{OnConflict: pluginapi.KVImportSkipExisting})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 3, Imported: 2, Skipped: 1}, result)
+		assert.Equal(t, []byte(`"john"`), values["user_1"])
+
+		result, err = client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{OnConflict: pluginapi.KVImportOverwriteExisting})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 3, Imported: 3}, result)
+		assert.Equal(t, []byte(`"jane"`), values["user_1"])
+
+		result, err = client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{OnConflict: pluginapi.KVImportFailOnExisting})
+		require.True(t, errors.Is(err, pluginapi.ErrKVImportConflict))
+		assert.Equal(t, pluginapi.KVImportResult{}, result)
+	})
+
+	t.Run("skip counts records", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		result, err := client.KV.Import(strings.NewReader(input), pluginapi.KVImportOptions{Skip: 3})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 3}, result)
+		assert.Empty(t, values)
+	})
+
+	t.Run("resume with progress", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		var lines []string
+		for i := 0; i < 250; i++ {
+			lines = append(lines, `{"key":"key`+strconv.Itoa(i)+`","value":`+strconv.Itoa(i)+`}`)
+		}
+		lines[220] = `not json`
+
+		var reports []pluginapi.KVImportResult
+		progress := func(result pluginapi.KVImportResult) {
+			reports = append(reports, result)
+		}
+
+		_, err := client.KV.Import(strings.NewReader(strings.Join(lines, "\n")), pluginapi.KVImportOptions{Progress: progress})
+		require.Error(t, err)
+		require.Len(t, reports, 2)
+		last := reports[1]
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 200, Imported: 200}, last)
+
+		lines[220] = `{"key":"key220","value":220}`
+		delete(values, "key0")
+		result, err := client.KV.Import(strings.NewReader(strings.Join(lines, "\n")), pluginapi.KVImportOptions{Skip: last.Processed})
+		require.NoError(t, err)
+		assert.Equal(t, pluginapi.KVImportResult{Processed: 250, Imported: 30, Skipped: 20}, result)
+		assert.Nil(t, values["key0"])
+		assert.Len(t, values, 249)
+	})
+}
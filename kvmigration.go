@@ -0,0 +1,195 @@
+package pluginapi
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+)
+
+const (
+	// migrationStateKey is the key under which the state of applied migrations is stored.
+	migrationStateKey = internalKeyPrefix + "kv_migrations"
+
+	// migrationBatchSize is the number of keys migrated between saving progress.
+	migrationBatchSize = 100
+)
+
+// KVMigration is a versioned change to the values stored in the key-value store.
+type KVMigration struct {
+	// Version orders the migrations, and must be unique and positive. Migrations are applied in
+	// increasing order of version, and a migration is never applied again once a migration with
+	// the same or a greater version has been applied.
+	Version int
+
+	// Prefix restricts the migration to the keys starting with it. All keys of the namespace are
+	// migrated if empty, which is only allowed for a KVService created by Namespace, as the root
+	// of the key-value store also holds the keys of cluster mutexes and jobs.
+	Prefix string
+
+	// Migrate migrates the value of a single key, reading and writing it through the given
+	// KVService. It may be called more than once for the same key if the migration is interrupted.
+	Migrate func(kv *KVService, key string) error
+}
+
+// kvMigrationState records the migrations applied to the key-value store.
+type kvMigrationState struct {
+	// Version is the version of the last migration applied in full.
+	Version int
+
+	// InProgress is the version of a migration that was interrupted, if any.
+	InProgress int `json:",omitempty"`
+
+	// LastKey is the last stored key migrated by the interrupted migration.
+	LastKey string `json:",omitempty"`
+}
+
+// Migrate applies the given migrations not yet applied to the key-value store, recording the
+// version of the last migration applied. Call Migrate from OnActivate: migrations run under a
+// cluster mutex, so they are applied exactly once even when every plugin instance calls it.
+//
+// Progress is saved periodically and when a key fails to migrate, so a migration interrupted by
+// an error or crash resumes after the last keys it saved as completed when Migrate is next called. If the service was created by
+// Namespace, the migrations and their recorded version are scoped to the namespace.
+//
+// Minimum server version: 5.18
+func (k *KVService) Migrate(migrations ...KVMigration) error {
+	m, err := cluster.NewMutex(k.api, k.namespace+migrationStateKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to create mutex")
+	}
+
+	return k.migrate(m, migrations)
+}
+
+func (k *KVService) migrate(m mutex, migrations []KVMigration) error {
+	migrations = append([]KVMigration(nil), migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return errors.Errorf("invalid migration version %d", migration.Version)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return errors.Errorf("duplicate migration version %d", migration.Version)
+		}
+		if migration.Prefix == "" && k.namespace == "" {
+			return errors.Errorf("migration %d must specify a prefix", migration.Version)
+		}
+		if migration.Migrate == nil {
+			return errors.Errorf("migration %d has no Migrate function", migration.Version)
+		}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	state, err := k.readMigrationState()
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= state.Version {
+			continue
+		}
+
+		if state.InProgress != migration.Version {
+			state.InProgress = migration.Version
+			state.LastKey = ""
+		}
+
+		if err := k.applyMigration(migration, &state); err != nil {
+			return errors.Wrapf(err, "failed to apply migration %d", migration.Version)
+		}
+
+		state = kvMigrationState{
+			Version: migration.Version,
+		}
+		if err := k.saveMigrationState(state); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyMigration migrates the matching keys stored after state.LastKey, saving progress after
+// every batch and before returning an error.
+func (k *KVService) applyMigration(migration KVMigration, state *kvMigrationState) error {
+	type migrationKey struct {
+		key      string
+		storeKey string
+	}
+
+	// Collect the keys before migrating any, as migrations deleting keys shift the pages being
+	// walked.
+	var keys []migrationKey
+	it := k.Scan(WithPrefix(migration.Prefix))
+	for it.Next() {
+		if it.storeKey > state.LastKey {
+			keys = append(keys, migrationKey{key: it.Key(), storeKey: it.storeKey})
+		}
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "failed to list keys")
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].storeKey < keys[j].storeKey
+	})
+
+	for i, key := range keys {
+		if err := migration.Migrate(k, key.key); err != nil {
+			// Save the keys completed so far, so that they aren't migrated again. The migration's
+			// error is returned even if this fails.
+			_ = k.saveMigrationState(*state)
+			return errors.Wrapf(err, "failed to migrate key %s", key.key)
+		}
+
+		state.LastKey = key.storeKey
+		if (i+1)%migrationBatchSize == 0 {
+			if err := k.saveMigrationState(*state); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (k *KVService) readMigrationState() (kvMigrationState, error) {
+	var state kvMigrationState
+
+	data, appErr := k.api.KVGet(k.namespace + migrationStateKey)
+	if appErr != nil {
+		return state, errors.Wrap(normalizeAppErr(appErr), "failed to read migration state")
+	}
+
+	if len(data) == 0 {
+		return state, nil
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, errors.Wrap(err, "failed to decode migration state")
+	}
+
+	return state, nil
+}
+
+func (k *KVService) saveMigrationState(state kvMigrationState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode migration state")
+	}
+
+	if _, appErr := k.api.KVSetWithOptions(k.namespace+migrationStateKey, data, model.PluginKVSetOptions{}); appErr != nil {
+		return errors.Wrap(normalizeAppErr(appErr), "failed to save migration state")
+	}
+
+	return nil
+}
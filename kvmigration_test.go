@@ -0,0 +1,182 @@
+package pluginapi_test
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestKVMigrate(t *testing.T) {
+	type userV1 struct {
+		Name string
+	}
+	type userV2 struct {
+		FirstName string
+		LastName  string
+	}
+
+	splitName := pluginapi.KVMigration{
+		Version: 1,
+		Prefix:  "user_",
+		Migrate: func(kv *pluginapi.KVService, key string) error {
+			var user userV1
+			if err := kv.Get(key, &user); err != nil {
+				return err
+			}
+			names := strings.SplitN(user.Name, " ", 2)
+			_, err := kv.Set(key, userV2{FirstName: names[0], LastName: names[1]})
+			return err
+		},
+	}
+
+	t.Run("applies migrations once", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		_, err := client.KV.Set("user_1", userV1{Name: "Jane Doe"})
+		require.NoError(t, err)
+		_, err = client.KV.Set("other", userV1{Name: "Jane Doe"})
+		require.NoError(t, err)
+
+		calls := 0
+		renameOther := pluginapi.KVMigration{
+			Version: 2,
+			Prefix:  "other",
+			Migrate: func(kv *pluginapi.KVService, key string) error {
+				calls++
+				var user userV1
+				if err := kv.Get(key, &user); err != nil {
+					return err
+				}
+				if _, err := kv.Set("user_2", user); err != nil {
+					return err
+				}
+				return kv.Delete(key)
+			},
+		}
+
+		err = client.KV.Migrate(renameOther, splitName)
+		require.NoError(t, err)
+
+		var user userV2
+		err = client.KV.Get("user_1", &user)
+		require.NoError(t, err)
+		assert.Equal(t, userV2{FirstName: "Jane", LastName: "Doe"}, user)
+
+		// Migrations ran in order of version, so user_2 is not migrated.
+		var moved userV1
+		err = client.KV.Get("user_2", &moved)
+		require.NoError(t, err)
+		assert.Equal(t, userV1{Name: "Jane Doe"}, moved)
+		assert.Equal(t, 1, calls)
+
+		err = client.KV.Migrate(splitName, renameOther)
+		require.NoError(t, err)
+		assert.Equal(t, 1, calls)
+		assert.JSONEq(t, `{"Version":2}`, string(values["mmi_kv_migrations"]))
+	})
+
+	t.Run("resumes an interrupted migration", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		for i := 0; i < 250; i++ {
+			_, err := client.KV.Set("counter_"+strconv.Itoa(1000+i), i)
+			require.NoError(t, err)
+		}
+
+		migrated := map[string]int{}
+		failAt := "counter_1220"
+		increment := pluginapi.KVMigration{
+			Version: 1,
+			Prefix:  "counter_",
+			Migrate: func(kv *pluginapi.KVService, key string) error {
+				if key == failAt {
+					return errors.New("crash")
+				}
+				migrated[key]++
+				var value int
+				if err := kv.Get(key, &value); err != nil {
+					return err
+				}
+				_, err := kv.Set(key, value+1)
+				return err
+			},
+		}
+
+		err := client.KV.Migrate(increment)
+		require.Error(t, err)
+
+		var state struct {
+			Version    int
+			InProgress int
+			LastKey    string
+		}
+		require.NoError(t, json.Unmarshal(values["mmi_kv_migrations"], &state))
+		assert.Equal(t, 0, state.Version)
+		assert.Equal(t, 1, state.InProgress)
+		assert.Equal(t, "counter_1219", state.LastKey)
+
+		failAt = ""
+		err = client.KV.Migrate(increment)
+		require.NoError(t, err)
+
+		for i := 0; i < 250; i++ {
+			key := "counter_" + strconv.Itoa(1000+i)
+			var value int
+			require.NoError(t, client.KV.Get(key, &value))
+			// The keys completed before the failure are not migrated again.
+			assert.Equal(t, 1, migrated[key], key)
+			assert.Equal(t, i+1, value, key)
+		}
+	})
+
+	t.Run("namespaced migrations", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		users := client.KV.Namespace("users")
+
+		_, err := users.Set("1", userV1{Name: "Jane Doe"})
+		require.NoError(t, err)
+
+		err = users.Migrate(pluginapi.KVMigration{
+			Version: 3,
+			Migrate: func(kv *pluginapi.KVService, key string) error {
+				assert.Equal(t, "1", key)
+				return nil
+			},
+		})
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"Version":3}`, string(values["users_mmi_kv_migrations"]))
+		assert.Empty(t, values["mmi_kv_migrations"])
+	})
+
+	t.Run("invalid migrations", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		migrate := func(kv *pluginapi.KVService, key string) error { return nil }
+
+		err := client.KV.Migrate(pluginapi.KVMigration{Version: 0, Prefix: "a", Migrate: migrate})
+		require.Error(t, err)
+
+		err = client.KV.Migrate(
+			pluginapi.KVMigration{Version: 1, Prefix: "a", Migrate: migrate},
+			pluginapi.KVMigration{Version: 1, Prefix: "b", Migrate: migrate},
+		)
+		require.Error(t, err)
+
+		err = client.KV.Migrate(pluginapi.KVMigration{Version: 1, Migrate: migrate})
+		require.Error(t, err)
+
+		err = client.KV.Migrate(pluginapi.KVMigration{Version: 1, Prefix: "a"})
+		require.Error(t, err)
+	})
+}
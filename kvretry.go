@@ -0,0 +1,145 @@
+package pluginapi
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrConflict is returned when an atomic write could not be completed before retries were
+// exhausted, because the value kept being modified concurrently.
+var ErrConflict = errors.New("value was modified concurrently")
+
+// KVBackoff determines how long to wait between the attempts of an atomic write.
+type KVBackoff interface {
+	// NextWait returns how long to wait before the next attempt, given the number of attempts
+	// made so far and the time elapsed since the first one, or false to stop retrying.
+	NextWait(attempts int, elapsed time.Duration) (time.Duration, bool)
+}
+
+// ConstantBackoff waits the same interval between attempts.
+type ConstantBackoff struct {
+	// Interval is the wait between attempts.
+	Interval time.Duration
+
+	// MaxAttempts bounds the number of attempts. Zero means unbounded.
+	MaxAttempts int
+}
+
+// NextWait implements KVBackoff.
+func (b ConstantBackoff) NextWait(attempts int, elapsed time.Duration) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempts >= b.MaxAttempts {
+		return 0, false
+	}
+
+	return b.Interval, true
+}
+
+// ExponentialBackoff waits exponentially longer between attempts, randomizing each wait to
+// spread out writers contending for the same key.
+//
+// If neither MaxAttempts nor MaxElapsedTime is set, attempts are made until the context is done.
+type ExponentialBackoff struct {
+	// InitialInterval is the wait after the first attempt. Defaults to 10 milliseconds.
+	InitialInterval time.Duration
+
+	// MaxInterval caps the wait between attempts. Defaults to one second.
+	MaxInterval time.Duration
+
+	// Multiplier is the factor by which the wait grows after each attempt. Defaults to 2.
+	Multiplier float64
+
+	// Jitter randomizes each wait by up to the given fraction of it, e.g. 0.5 waits between 50%
+	// and 150% of the computed interval. Zero disables randomization.
+	Jitter float64
+
+	// MaxAttempts bounds the number of attempts. Zero means unbounded.
+	MaxAttempts int
+
+	// MaxElapsedTime stops retrying once the given time has elapsed since the first attempt.
+	// Zero means unbounded.
+	MaxElapsedTime time.Duration
+}
+
+// NextWait implements KVBackoff.
+func (b ExponentialBackoff) NextWait(attempts int, elapsed time.Duration) (time.Duration, bool) {
+	if b.MaxAttempts > 0 && attempts >= b.MaxAttempts {
+		return 0, false
+	}
+
+	initialInterval := b.InitialInterval
+	if initialInterval <= 0 {
+		initialInterval = 10 * time.Millisecond
+	}
+	maxInterval := b.MaxInterval
+	if maxInterval <= 0 {
+		maxInterval = time.Second
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	interval := float64(initialInterval) * math.Pow(multiplier, float64(attempts-1))
+	if interval > float64(maxInterval) {
+		interval = float64(maxInterval)
+	}
+	if b.Jitter > 0 {
+		interval += interval * b.Jitter * (2*rand.Float64() - 1)
+	}
+	wait := time.Duration(interval)
+
+	if b.MaxElapsedTime > 0 && elapsed+wait > b.MaxElapsedTime {
+		return 0, false
+	}
+
+	return wait, true
+}
+
+// defaultBackoff is the backoff of SetAtomicWithRetries.
+var defaultBackoff KVBackoff = ConstantBackoff{
+	Interval:    10 * time.Millisecond,
+	MaxAttempts: 5,
+}
+
+// KVRetryOption configures SetAtomicWithRetriesContext.
+type KVRetryOption func(*kvRetryOptions)
+
+type kvRetryOptions struct {
+	backoff KVBackoff
+}
+
+// RetryBackoff configures the backoff between the attempts of an atomic write. Without it, five
+// attempts are made ten milliseconds apart, as with SetAtomicWithRetries.
+func RetryBackoff(backoff KVBackoff) KVRetryOption {
+	return func(o *kvRetryOptions) {
+		o.backoff = backoff
+	}
+}
+
+// SetAtomicWithRetriesContext is like SetAtomicWithRetries, but stops retrying once the given
+// context is done and waits between attempts according to the configured backoff.
+//
+// It returns the number of attempts made, including the successful one, so that contention can
+// be monitored. If retries were exhausted, the returned error wraps ErrConflict. If the context
+// is done, the returned error wraps the context's error.
+//
+// Minimum server version: 5.18
+func (k *KVService) SetAtomicWithRetriesContext(
+	ctx context.Context,
+	key string,
+	valueFunc func(oldValue []byte) (newValue interface{}, err error),
+	options ...KVRetryOption,
+) (int, error) {
+	opts := kvRetryOptions{
+		backoff: defaultBackoff,
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	return k.setAtomicWithRetries(ctx, key, k.unwrappedValueFunc(key, valueFunc), opts.backoff)
+}
@@ -0,0 +1,103 @@
+package pluginapi_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestSetAtomicWithRetriesContext(t *testing.T) {
+	api, values := newMemoryKVAPI(t)
+	client := pluginapi.NewClient(api, nil)
+
+	// conflicting modifies the stored value the given number of times before returning the new
+	// value, as if written concurrently by another plugin instance.
+	conflicting := func(conflicts int) func(oldValue []byte) (interface{}, error) {
+		return func(oldValue []byte) (interface{}, error) {
+			if conflicts > 0 {
+				conflicts--
+				values["counter"] = append(oldValue, '0')
+			}
+
+			return 1, nil
+		}
+	}
+
+	t.Run("reports attempts", func(t *testing.T) {
+		values["counter"] = []byte("1")
+
+		attempts, err := client.KV.SetAtomicWithRetriesContext(context.Background(), "counter", conflicting(2))
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+		assert.Equal(t, []byte("1"), values["counter"])
+	})
+
+	t.Run("conflict when retries are exhausted", func(t *testing.T) {
+		values["counter"] = []byte("1")
+
+		attempts, err := client.KV.SetAtomicWithRetriesContext(context.Background(), "counter", conflicting(10),
+			pluginapi.RetryBackoff(pluginapi.ConstantBackoff{MaxAttempts: 3}))
+		require.True(t, errors.Is(err, pluginapi.ErrConflict))
+		assert.Equal(t, 3, attempts)
+
+		err = client.KV.SetAtomicWithRetries("counter", conflicting(10))
+		require.True(t, errors.Is(err, pluginapi.ErrConflict))
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		values["counter"] = []byte("1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		attempts, err := client.KV.SetAtomicWithRetriesContext(ctx, "counter", conflicting(1000),
+			pluginapi.RetryBackoff(pluginapi.ConstantBackoff{Interval: 20 * time.Millisecond}))
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		assert.GreaterOrEqual(t, attempts, 1)
+		assert.Less(t, attempts, 5)
+	})
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		backoff := pluginapi.ExponentialBackoff{}
+
+		for attempts, expected := range []time.Duration{10, 20, 40, 80} {
+			wait, retry := backoff.NextWait(attempts+1, 0)
+			assert.True(t, retry)
+			assert.Equal(t, expected*time.Millisecond, wait)
+		}
+
+		wait, retry := backoff.NextWait(20, 0)
+		assert.True(t, retry)
+		assert.Equal(t, time.Second, wait)
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		backoff := pluginapi.ExponentialBackoff{InitialInterval: 100 * time.Millisecond, Jitter: 0.5}
+
+		for i := 0; i < 100; i++ {
+			wait, retry := backoff.NextWait(1, 0)
+			assert.True(t, retry)
+			assert.GreaterOrEqual(t, wait, 50*time.Millisecond)
+			assert.LessOrEqual(t, wait, 150*time.Millisecond)
+		}
+	})
+
+	t.Run("limits", func(t *testing.T) {
+		backoff := pluginapi.ExponentialBackoff{MaxAttempts: 3, MaxElapsedTime: time.Second}
+
+		_, retry := backoff.NextWait(2, 0)
+		assert.True(t, retry)
+		_, retry = backoff.NextWait(3, 0)
+		assert.False(t, retry)
+		_, retry = backoff.NextWait(1, 995*time.Millisecond)
+		assert.False(t, retry)
+	})
+}
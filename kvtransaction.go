@@ -0,0 +1,265 @@
+package pluginapi
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/mattermost/mattermost-plugin-api/cluster"
+)
+
+// transactionMutexPrefix namespaces the mutexes locking the keys written by a transaction.
+const transactionMutexPrefix = internalKeyPrefix + "kv_tx_"
+
+// KVTransaction reads and stages writes to key-value pairs, to be committed together by
+// KVService.Transaction.
+type KVTransaction struct {
+	kv *KVService
+
+	// reads holds the values read from the key-value store, nil for keys that did not exist.
+	reads map[string][]byte
+
+	// writes holds the staged values in their stored representation, nil for keys to delete.
+	writes map[string][]byte
+}
+
+// Get gets the value for the given key into the given interface, as staged by the transaction
+// or otherwise as stored when first read by the transaction. A non-existent key will return no
+// error, with nothing written to the given interface.
+//
+// Minimum server version: 5.2
+func (tx *KVTransaction) Get(key string, o interface{}) error {
+	data, staged := tx.writes[key]
+	if !staged {
+		var read bool
+		data, read = tx.reads[key]
+		if !read {
+			if err := tx.kv.Get(key, &data); err != nil {
+				return err
+			}
+			tx.reads[key] = data
+		}
+	}
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	if err := tx.kv.decodeValue(data, o); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal value for key %s", key)
+	}
+
+	return nil
+}
+
+// Set stages writing the given value to the given key when the transaction commits. A nil value
+// deletes the key.
+func (tx *KVTransaction) Set(key string, value interface{}) error {
+	if strings.HasPrefix(key, internalKeyPrefix) {
+		return errors.New("'mmi_' prefix is not allowed for keys")
+	}
+
+	data, err := tx.kv.encodeValue(value, tx.kv.encoding)
+	if err != nil {
+		return err
+	}
+	tx.writes[key] = data
+
+	return nil
+}
+
+// Delete stages deleting the given key when the transaction commits.
+func (tx *KVTransaction) Delete(key string) error {
+	return tx.Set(key, nil)
+}
+
+// Transaction runs f, then atomically commits the writes it staged, provided none of the keys
+// it read or wrote were modified in the meantime. Otherwise, any writes already made are rolled
+// back and f is run again, waiting between attempts according to the configured backoff. The
+// returned error wraps ErrConflict if the transaction could not be committed before retries were
+// exhausted.
+//
+// f may be run more than once, and must not have side effects other than on the transaction.
+// If f returns an error, nothing is written and the error is returned.
+//
+// While committing, each key read or written is locked with a cluster mutex, so that
+// transactions over overlapping keys are serialized. Writes made outside of transactions are
+// detected using compare and set semantics. If the plugin stops while a transaction is being
+// committed, only some of its writes may have been made.
+//
+// Minimum server version: 5.18
+func (k *KVService) Transaction(f func(tx *KVTransaction) error, options ...KVRetryOption) error {
+	opts := kvRetryOptions{
+		backoff: defaultBackoff,
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	start := time.Now()
+	for attempts := 1; ; attempts++ {
+		tx := &KVTransaction{
+			kv:     k,
+			reads:  make(map[string][]byte),
+			writes: make(map[string][]byte),
+		}
+
+		if err := f(tx); err != nil {
+			return err
+		}
+
+		committed, err := tx.commit()
+		if err != nil {
+			return errors.Wrap(err, "failed to commit transaction")
+		}
+		if committed {
+			return nil
+		}
+
+		wait, retry := opts.backoff.NextWait(attempts, time.Since(start))
+		if !retry {
+			return errors.Wrapf(ErrConflict, "failed to commit transaction after %d attempts", attempts)
+		}
+
+		time.Sleep(wait)
+	}
+}
+
+// kvTransactionWrite is a write made while committing a transaction, kept to roll it back.
+type kvTransactionWrite struct {
+	key      string
+	oldValue []byte
+	newValue []byte
+}
+
+// commit makes the staged writes, returning false if a conflict was detected.
+func (tx *KVTransaction) commit() (bool, error) {
+	if len(tx.writes) == 0 {
+		return true, nil
+	}
+
+	var keys []string
+	for key := range tx.reads {
+		keys = append(keys, key)
+	}
+	for key := range tx.writes {
+		if _, read := tx.reads[key]; !read {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	unlock, err := tx.lock(keys)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	// Check that the keys only read are unchanged, as they are not otherwise compared.
+	for key, readValue := range tx.reads {
+		if _, staged := tx.writes[key]; staged {
+			continue
+		}
+
+		var current []byte
+		if err = tx.kv.Get(key, &current); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(current, readValue) {
+			return false, nil
+		}
+	}
+
+	var written []kvTransactionWrite
+	for _, key := range keys {
+		newValue, staged := tx.writes[key]
+		if !staged {
+			continue
+		}
+
+		oldValue, read := tx.reads[key]
+		if !read {
+			if err = tx.kv.Get(key, &oldValue); err != nil {
+				return false, tx.rollback(written, err)
+			}
+		}
+
+		var ok bool
+		ok, err = tx.write(key, oldValue, newValue)
+		if err != nil {
+			return false, tx.rollback(written, err)
+		}
+		if !ok {
+			return false, tx.rollback(written, nil)
+		}
+
+		written = append(written, kvTransactionWrite{
+			key:      key,
+			oldValue: oldValue,
+			newValue: newValue,
+		})
+	}
+
+	return true, nil
+}
+
+// write sets the given key to newValue if its current value is oldValue.
+func (tx *KVTransaction) write(key string, oldValue, newValue []byte) (bool, error) {
+	if oldValue == nil && newValue == nil {
+		// Deleting a key that does not exist has no effect, but the key must still not exist.
+		var current []byte
+		if err := tx.kv.Get(key, &current); err != nil {
+			return false, err
+		}
+
+		return current == nil, nil
+	}
+
+	return tx.kv.Set(key, newValue, SetAtomic(oldValue))
+}
+
+// rollback restores the values replaced by the given writes, returning the error that caused
+// the rollback, if any.
+func (tx *KVTransaction) rollback(written []kvTransactionWrite, cause error) error {
+	for i := len(written) - 1; i >= 0; i-- {
+		w := written[i]
+
+		ok, err := tx.write(w.key, w.newValue, w.oldValue)
+		if err != nil {
+			return errors.Wrapf(err, "failed to roll back key %s", w.key)
+		} else if !ok {
+			return errors.Errorf("failed to roll back key %s, as it was modified concurrently", w.key)
+		}
+	}
+
+	return cause
+}
+
+// lock locks a cluster mutex for each of the given keys, in order, returning a function
+// unlocking them.
+func (tx *KVTransaction) lock(keys []string) (func(), error) {
+	var mutexes []*cluster.Mutex
+	unlock := func() {
+		for i := len(mutexes) - 1; i >= 0; i-- {
+			mutexes[i].Unlock()
+		}
+	}
+
+	for _, key := range keys {
+		storeKey, _ := tx.kv.storeKey(key)
+
+		m, err := cluster.NewMutex(tx.kv.api, transactionMutexPrefix+hashKey(storeKey))
+		if err != nil {
+			unlock()
+			return nil, errors.Wrapf(err, "failed to create mutex for key %s", key)
+		}
+
+		m.Lock()
+		mutexes = append(mutexes, m)
+	}
+
+	return unlock, nil
+}
@@ -0,0 +1,108 @@
+package pluginapi_test
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+func TestTransaction(t *testing.T) {
+	type record struct {
+		Name string
+	}
+
+	t.Run("commits staged writes", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		kv := pluginapi.NewClient(api, nil).KV.Namespace("records")
+
+		err := kv.Transaction(func(tx *pluginapi.KVTransaction) error {
+			var id string
+			require.NoError(t, tx.Get("index_user1", &id))
+			assert.Empty(t, id)
+
+			require.NoError(t, tx.Set("record_1", record{Name: "one"}))
+			require.NoError(t, tx.Set("index_user1", "1"))
+
+			require.NoError(t, tx.Get("index_user1", &id))
+			assert.Equal(t, "1", id)
+
+			return nil
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string][]byte{
+			"records_index_user1": []byte(`"1"`),
+			"records_record_1":    []byte(`{"Name":"one"}`),
+		}, values)
+
+		err = kv.Transaction(func(tx *pluginapi.KVTransaction) error {
+			require.NoError(t, tx.Delete("record_1"))
+			return tx.Delete("index_user1")
+		})
+		require.NoError(t, err)
+		assert.Empty(t, values)
+	})
+
+	t.Run("nothing is written on error", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		kv := pluginapi.NewClient(api, nil).KV
+
+		failure := errors.New("failure")
+		err := kv.Transaction(func(tx *pluginapi.KVTransaction) error {
+			require.NoError(t, tx.Set("record_1", record{Name: "one"}))
+			return failure
+		})
+		require.Equal(t, failure, err)
+		assert.Empty(t, values)
+
+		err = kv.Transaction(func(tx *pluginapi.KVTransaction) error {
+			return tx.Set("mmi_record", 1)
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("retries when a read key changes", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		kv := pluginapi.NewClient(api, nil).KV
+		values["counter"] = []byte("1")
+
+		attempts := 0
+		err := kv.Transaction(func(tx *pluginapi.KVTransaction) error {
+			attempts++
+
+			var counter int
+			require.NoError(t, tx.Get("counter", &counter))
+			if attempts == 1 {
+				values["counter"] = []byte("5")
+			}
+
+			return tx.Set("copy", counter)
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, []byte("5"), values["copy"])
+	})
+
+	t.Run("rolls back on conflict", func(t *testing.T) {
+		api, values := newMemoryKVAPI(t)
+		kv := pluginapi.NewClient(api, nil).KV
+		values["b"] = []byte("1")
+
+		err := kv.Transaction(func(tx *pluginapi.KVTransaction) error {
+			var b int
+			require.NoError(t, tx.Get("b", &b))
+			values["b"] = []byte(strconv.Itoa(b + 10))
+
+			require.NoError(t, tx.Set("a", b+1))
+			return tx.Set("b", b+1)
+		}, pluginapi.RetryBackoff(pluginapi.ConstantBackoff{MaxAttempts: 2}))
+		require.True(t, errors.Is(err, pluginapi.ErrConflict))
+
+		assert.Equal(t, map[string][]byte{"b": []byte("21")}, values)
+	})
+}
@@ -0,0 +1,301 @@
+package pluginapi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/pkg/errors"
+)
+
+// kvChangeEventID identifies the cluster events notifying of changed key-value pairs.
+const kvChangeEventID = internalKeyPrefix + "kv_change"
+
+// KVOp is the kind of change made to a key-value pair.
+type KVOp int
+
+const (
+	// KVOpSet means the key was set.
+	KVOpSet KVOp = iota + 1
+
+	// KVOpDelete means the key was deleted.
+	KVOpDelete
+)
+
+func (op KVOp) String() string {
+	switch op {
+	case KVOpSet:
+		return "set"
+	case KVOpDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// KVWatcherOptions configures a KVWatcher.
+type KVWatcherOptions struct {
+	// PollInterval is how often watched keys are compared against the key-value store, to notice
+	// changes made without going through the watcher. Defaults to one minute.
+	PollInterval time.Duration
+}
+
+// KVWatcher notifies of changes to key-value pairs on every plugin instance.
+//
+// Writes made through the KVService returned by KV are dispatched to the local watches
+// immediately, and to the watches of other plugin instances by publishing a cluster event, which
+// each instance must pass to HandleClusterEvent from its OnPluginClusterEvent hook. Changes made
+// by other means are noticed by periodically polling the watched keys.
+//
+// Watch callbacks are called synchronously, so they should return quickly, and may be called
+// more than once for the same change.
+type KVWatcher struct {
+	kv *KVService
+
+	lock    sync.Mutex
+	watches map[int]*kvWatch
+	nextID  int
+
+	// hashes holds the hash of the value of each watched key as of the last poll, or an empty
+	// hash if the key was changed since and its new value is unknown.
+	hashes map[string][]byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type kvWatch struct {
+	prefix string
+	f      func(key string, op KVOp)
+
+	// baselined is false until the keys of the watch were first polled, so that existing keys
+	// are not reported as changed by the poll.
+	baselined bool
+}
+
+// kvChange is the payload of a change event. The key is namespaced.
+type kvChange struct {
+	Key string
+	Op  KVOp
+}
+
+// NewKVWatcher creates a KVWatcher for the key-value pairs of the given KVService, polling the
+// watched keys until Close is called.
+func NewKVWatcher(kv *KVService, options KVWatcherOptions) *KVWatcher {
+	if options.PollInterval <= 0 {
+		options.PollInterval = time.Minute
+	}
+
+	w := &KVWatcher{
+		kv:      kv,
+		watches: make(map[int]*kvWatch),
+		hashes:  make(map[string][]byte),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go w.run(options.PollInterval)
+
+	return w
+}
+
+// KV returns a KVService whose writes are dispatched to the watches of every plugin instance.
+func (w *KVWatcher) KV() *KVService {
+	kv := *w.kv
+	kv.onChange = w.publish
+
+	return &kv
+}
+
+// Watch calls f whenever a key starting with the given prefix changes, until the returned
+// function is called.
+func (w *KVWatcher) Watch(prefix string, f func(key string, op KVOp)) (unwatch func()) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	id := w.nextID
+	w.nextID++
+	w.watches[id] = &kvWatch{
+		prefix: prefix,
+		f:      f,
+	}
+
+	return func() {
+		w.lock.Lock()
+		defer w.lock.Unlock()
+
+		delete(w.watches, id)
+	}
+}
+
+// HandleClusterEvent dispatches changes published by other plugin instances. Call it from the
+// plugin's OnPluginClusterEvent hook. It returns false if the event is unrelated to watches, and
+// can be safely called for every watcher the plugin uses.
+func (w *KVWatcher) HandleClusterEvent(ev model.PluginClusterEvent) bool {
+	if ev.Id != kvChangeEventID {
+		return false
+	}
+
+	var change kvChange
+	if err := json.Unmarshal(ev.Data, &change); err != nil {
+		w.kv.api.LogError("failed to unmarshal key-value change", "err", err)
+		return true
+	}
+
+	w.dispatch(change)
+
+	return true
+}
+
+// Close stops polling the watched keys.
+func (w *KVWatcher) Close() {
+	close(w.stop)
+	<-w.done
+}
+
+// publish dispatches a write to the local watches, then to other plugin instances.
+func (w *KVWatcher) publish(key string, op KVOp) {
+	change := kvChange{Key: key, Op: op}
+	w.dispatch(change)
+
+	data, err := json.Marshal(change)
+	if err == nil {
+		err = w.kv.api.PublishPluginClusterEvent(model.PluginClusterEvent{
+			Id:   kvChangeEventID,
+			Data: data,
+		}, model.PluginClusterEventSendOptions{
+			SendType: model.PluginClusterEventSendTypeReliable,
+		})
+	}
+	if err != nil {
+		// Other plugin instances will notice the change when next polling.
+		w.kv.api.LogError("failed to publish key-value change", "err", err, "key", key)
+	}
+}
+
+// dispatch calls the watches matching a change to a namespaced key.
+func (w *KVWatcher) dispatch(change kvChange) {
+	if !strings.HasPrefix(change.Key, w.kv.namespace) {
+		return
+	}
+	key := strings.TrimPrefix(change.Key, w.kv.namespace)
+
+	w.lock.Lock()
+	if change.Op == KVOpDelete {
+		delete(w.hashes, key)
+	} else {
+		w.hashes[key] = nil
+	}
+	watches := w.matchingWatches(key, false)
+	w.lock.Unlock()
+
+	for _, watch := range watches {
+		watch.f(key, change.Op)
+	}
+}
+
+// matchingWatches returns the watches for the given key, only including the baselined ones if
+// polled is true. The lock must be held.
+func (w *KVWatcher) matchingWatches(key string, polled bool) []*kvWatch {
+	var watches []*kvWatch
+	for _, watch := range w.watches {
+		if (watch.baselined || !polled) && strings.HasPrefix(key, watch.prefix) {
+			watches = append(watches, watch)
+		}
+	}
+
+	return watches
+}
+
+func (w *KVWatcher) run(pollInterval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.poll(); err != nil {
+			w.kv.api.LogError("failed to poll watched keys", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// poll compares the watched keys against the key-value store, dispatching the changes found.
+func (w *KVWatcher) poll() error {
+	w.lock.Lock()
+	var prefixes []string
+	for _, watch := range w.watches {
+		prefixes = append(prefixes, watch.prefix)
+	}
+	w.lock.Unlock()
+
+	if len(prefixes) == 0 {
+		return nil
+	}
+
+	hashes := make(map[string][]byte)
+	it := w.kv.Scan(withAnyPrefix(prefixes))
+	for it.Next() {
+		if strings.HasPrefix(it.Key(), internalKeyPrefix) {
+			continue
+		}
+
+		var data []byte
+		if err := it.Value(&data); err != nil {
+			return errors.Wrapf(err, "failed to get value for key %s", it.Key())
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		hash := sha256.Sum256(data)
+		hashes[it.Key()] = hash[:]
+	}
+	if err := it.Err(); err != nil {
+		return errors.Wrap(err, "failed to list keys")
+	}
+
+	var changes []kvChange
+	w.lock.Lock()
+	for key, hash := range hashes {
+		previous, known := w.hashes[key]
+		if !known || (previous != nil && string(previous) != string(hash)) {
+			changes = append(changes, kvChange{Key: key, Op: KVOpSet})
+		}
+	}
+	for key := range w.hashes {
+		if _, exists := hashes[key]; !exists {
+			changes = append(changes, kvChange{Key: key, Op: KVOpDelete})
+		}
+	}
+
+	var dispatches []func()
+	for _, change := range changes {
+		change := change
+		for _, watch := range w.matchingWatches(change.Key, true) {
+			f := watch.f
+			dispatches = append(dispatches, func() { f(change.Key, change.Op) })
+		}
+	}
+
+	w.hashes = hashes
+	for _, watch := range w.watches {
+		watch.baselined = true
+	}
+	w.lock.Unlock()
+
+	for _, dispatch := range dispatches {
+		dispatch()
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package pluginapi_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+// kvChanges records the changes reported to a watch.
+type kvChanges struct {
+	lock    sync.Mutex
+	changes []string
+}
+
+func (c *kvChanges) record(key string, op pluginapi.KVOp) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.changes = append(c.changes, op.String()+" "+key)
+}
+
+func (c *kvChanges) get() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string(nil), c.changes...)
+}
+
+func TestKVWatcher(t *testing.T) {
+	t.Run("writes are dispatched locally and to other nodes", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		var events []model.PluginClusterEvent
+		api.On("PublishPluginClusterEvent", mock.AnythingOfType("model.PluginClusterEvent"), model.PluginClusterEventSendOptions{
+			SendType: model.PluginClusterEventSendTypeReliable,
+		}).Run(func(args mock.Arguments) {
+			events = append(events, args.Get(0).(model.PluginClusterEvent))
+		}).Return(nil)
+
+		kv := client.KV.Namespace("config")
+		watcher := pluginapi.NewKVWatcher(kv, pluginapi.KVWatcherOptions{})
+		defer watcher.Close()
+		otherNode := pluginapi.NewKVWatcher(kv, pluginapi.KVWatcherOptions{})
+		defer otherNode.Close()
+
+		var local, remote kvChanges
+		watcher.Watch("feature_", local.record)
+		otherNode.Watch("feature_", remote.record)
+
+		_, err := watcher.KV().Set("feature_a", true)
+		require.NoError(t, err)
+		require.NoError(t, watcher.KV().Delete("feature_a"))
+		_, err = watcher.KV().Set("other", true)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"set feature_a", "delete feature_a"}, local.get())
+
+		require.Len(t, events, 3)
+		for _, event := range events {
+			assert.True(t, otherNode.HandleClusterEvent(event))
+		}
+		assert.Equal(t, []string{"set feature_a", "delete feature_a"}, remote.get())
+
+		assert.False(t, otherNode.HandleClusterEvent(model.PluginClusterEvent{Id: "unrelated"}))
+	})
+
+	t.Run("delete all is dispatched", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+		api.On("PublishPluginClusterEvent", mock.AnythingOfType("model.PluginClusterEvent"), mock.Anything).Return(nil)
+
+		_, err := client.KV.Set("other", true)
+		require.NoError(t, err)
+
+		watcher := pluginapi.NewKVWatcher(client.KV.Namespace("config"), pluginapi.KVWatcherOptions{})
+		defer watcher.Close()
+
+		var changes kvChanges
+		watcher.Watch("feature_", changes.record)
+
+		_, err = watcher.KV().Set("feature_a", true)
+		require.NoError(t, err)
+		require.NoError(t, watcher.KV().DeleteAll())
+
+		assert.Equal(t, []string{"set feature_a", "delete feature_a"}, changes.get())
+
+		var other bool
+		require.NoError(t, client.KV.Get("other", &other))
+		assert.True(t, other)
+	})
+
+	t.Run("writes made by other means are polled", func(t *testing.T) {
+		api, _ := newMemoryKVAPI(t)
+		client := pluginapi.NewClient(api, nil)
+
+		_, err := client.KV.Set("feature_a", true)
+		require.NoError(t, err)
+
+		watcher := pluginapi.NewKVWatcher(&client.KV, pluginapi.KVWatcherOptions{PollInterval: 10 * time.Millisecond})
+		defer watcher.Close()
+
+		var changes kvChanges
+		unwatch := watcher.Watch("feature_", changes.record)
+
+		// Wait for the existing keys to be polled, which are not reported.
+		time.Sleep(50 * time.Millisecond)
+		assert.Empty(t, changes.get())
+
+		_, err = client.KV.Set("feature_a", false)
+		require.NoError(t, err)
+		_, err = client.KV.Set("feature_b", true)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return len(changes.get()) == 2 }, time.Second, 10*time.Millisecond)
+		assert.ElementsMatch(t, []string{"set feature_a", "set feature_b"}, changes.get())
+
+		require.NoError(t, client.KV.Delete("feature_a"))
+		require.Eventually(t, func() bool { return len(changes.get()) == 3 }, time.Second, 10*time.Millisecond)
+		assert.Equal(t, "delete feature_a", changes.get()[2])
+
+		unwatch()
+		require.NoError(t, client.KV.Delete("feature_b"))
+		time.Sleep(50 * time.Millisecond)
+		assert.Len(t, changes.get(), 3)
+	})
+}
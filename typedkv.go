@@ -0,0 +1,127 @@
+package pluginapi
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// TypedKV is a type-safe view over the key-value store for values of type T.
+//
+// Values are stored exactly as KVService would store them, so a TypedKV can read values written
+// through KVService and vice versa.
+type TypedKV[T any] struct {
+	kv *KVService
+}
+
+// NewTypedKV creates a TypedKV storing values of type T through the given KVService.
+func NewTypedKV[T any](kv *KVService) *TypedKV[T] {
+	return &TypedKV[T]{
+		kv: kv,
+	}
+}
+
+// Get gets the value for the given key.
+//
+// Returns (value, true, nil) if the key exists.
+// Returns (zero value, false, nil) if the key does not exist.
+// Returns (zero value, false, err) if the value could not be fetched or decoded.
+//
+// Minimum server version: 5.2
+func (t *TypedKV[T]) Get(key string) (T, bool, error) {
+	var value T
+
+	var data []byte
+	if err := t.kv.Get(key, &data); err != nil {
+		return value, false, err
+	}
+
+	if len(data) == 0 {
+		return value, false, nil
+	}
+
+	if err := t.kv.decodeValue(data, &value); err != nil {
+		return value, false, errors.Wrapf(err, "failed to unmarshal value for key %s", key)
+	}
+
+	return value, true, nil
+}
+
+// Set stores a key-value pair. See KVService.Set for the supported options.
+//
+// Returns (false, err) if DB error occurred
+// Returns (false, nil) if the value was not set
+// Returns (true, nil) if the value was set
+//
+// Minimum server version: 5.18
+func (t *TypedKV[T]) Set(key string, value T, options ...KVSetOption) (bool, error) {
+	return t.kv.Set(key, value, options...)
+}
+
+// Delete deletes the given key-value pair.
+//
+// An error is returned only if the value failed to be deleted. A non-existent key will return
+// no error.
+//
+// Minimum server version: 5.18
+func (t *TypedKV[T]) Delete(key string) error {
+	return t.kv.Delete(key)
+}
+
+// Update atomically replaces the value for the given key with the value returned by updateFunc,
+// using compare and set semantics. updateFunc is given the current value and whether the key
+// exists, and may be called more than once if the value is modified concurrently.
+//
+// Returns an error if the key could not be read or written, if updateFunc returned an error, or
+// if the value could not be set after retries. Retries can be configured as for
+// SetAtomicWithRetriesContext.
+//
+// Minimum server version: 5.18
+func (t *TypedKV[T]) Update(key string, updateFunc func(oldValue T, exists bool) (T, error), options ...KVRetryOption) error {
+	opts := kvRetryOptions{
+		backoff: defaultBackoff,
+	}
+	for _, o := range options {
+		o(&opts)
+	}
+
+	_, err := t.kv.setAtomicWithRetries(context.Background(), key, func(oldData []byte) (interface{}, error) {
+		var oldValue T
+		exists := len(oldData) > 0
+		if exists {
+			if err := t.kv.decodeValue(oldData, &oldValue); err != nil {
+				return nil, errors.Wrapf(err, "failed to unmarshal value for key %s", key)
+			}
+		}
+
+		return updateFunc(oldValue, exists)
+	}, opts.backoff)
+
+	return err
+}
+
+// List returns the decoded values of all keys starting with the given prefix, indexed by key.
+// Keys that no longer exist by the time their value is read are omitted.
+//
+// Minimum server version: 5.6
+func (t *TypedKV[T]) List(prefix string) (map[string]T, error) {
+	values := make(map[string]T)
+
+	it := t.kv.Scan(WithPrefix(prefix))
+	for it.Next() {
+		value, exists, err := t.Get(it.Key())
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+
+		values[it.Key()] = value
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
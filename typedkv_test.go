@@ -0,0 +1,205 @@
+package pluginapi_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/v6/model"
+	"github.com/mattermost/mattermost-server/v6/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pluginapi "github.com/mattermost/mattermost-plugin-api"
+)
+
+type typedValue struct {
+	Name  string
+	Count int
+}
+
+func TestTypedKVGet(t *testing.T) {
+	t.Run("existing key", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[typedValue](&client.KV)
+
+		api.On("KVGet", "1").Return([]byte(`{"Name":"a","Count":2}`), nil)
+
+		value, exists, err := store.Get("1")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, typedValue{Name: "a", Count: 2}, value)
+	})
+
+	t.Run("zero value is distinguished from a missing key", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[int](&client.KV)
+
+		api.On("KVGet", "zero").Return([]byte(`0`), nil)
+		api.On("KVGet", "missing").Return(nil, nil)
+
+		value, exists, err := store.Get("zero")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, 0, value)
+
+		value, exists, err = store.Get("missing")
+		require.NoError(t, err)
+		assert.False(t, exists)
+		assert.Equal(t, 0, value)
+	})
+
+	t.Run("bytes are returned as is", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[[]byte](&client.KV)
+
+		api.On("KVGet", "1").Return([]byte{1, 2}, nil)
+
+		value, exists, err := store.Get("1")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []byte{1, 2}, value)
+	})
+
+	t.Run("invalid value", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[int](&client.KV)
+
+		api.On("KVGet", "1").Return([]byte(`"a"`), nil)
+
+		_, exists, err := store.Get("1")
+		require.Error(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[int](&client.KV)
+
+		api.On("KVGet", "1").Return(nil, newAppError())
+
+		_, exists, err := store.Get("1")
+		require.Error(t, err)
+		assert.False(t, exists)
+	})
+}
+
+func TestTypedKVSet(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	client := pluginapi.NewClient(api, &plugintest.Driver{})
+	store := pluginapi.NewTypedKV[typedValue](&client.KV)
+
+	api.On("KVSetWithOptions", "1", []byte(`{"Name":"a","Count":2}`), model.PluginKVSetOptions{
+		ExpireInSeconds: 60,
+	}).Return(true, nil)
+
+	written, err := store.Set("1", typedValue{Name: "a", Count: 2}, pluginapi.SetExpiry(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, written)
+}
+
+func TestTypedKVDelete(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	client := pluginapi.NewClient(api, &plugintest.Driver{})
+	store := pluginapi.NewTypedKV[typedValue](&client.KV)
+
+	api.On("KVSetWithOptions", "1", []byte(nil), model.PluginKVSetOptions{}).Return(true, nil)
+
+	err := store.Delete("1")
+	require.NoError(t, err)
+}
+
+func TestTypedKVUpdate(t *testing.T) {
+	t.Run("missing key", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[int](&client.KV)
+
+		api.On("KVGet", "counter").Return(nil, nil)
+		api.On("KVSetWithOptions", "counter", []byte(`1`), model.PluginKVSetOptions{
+			Atomic: true,
+		}).Return(true, nil)
+
+		err := store.Update("counter", func(oldValue int, exists bool) (int, error) {
+			assert.False(t, exists)
+			return oldValue + 1, nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("retries on conflict", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[int](&client.KV)
+
+		api.On("KVGet", "counter").Return([]byte(`1`), nil).Once()
+		api.On("KVSetWithOptions", "counter", []byte(`2`), model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: []byte(`1`),
+		}).Return(false, nil).Once()
+		api.On("KVGet", "counter").Return([]byte(`5`), nil).Once()
+		api.On("KVSetWithOptions", "counter", []byte(`6`), model.PluginKVSetOptions{
+			Atomic:   true,
+			OldValue: []byte(`5`),
+		}).Return(true, nil).Once()
+
+		calls := 0
+		err := store.Update("counter", func(oldValue int, exists bool) (int, error) {
+			calls++
+			assert.True(t, exists)
+			return oldValue + 1, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("updateFunc error", func(t *testing.T) {
+		api := &plugintest.API{}
+		defer api.AssertExpectations(t)
+		client := pluginapi.NewClient(api, &plugintest.Driver{})
+		store := pluginapi.NewTypedKV[int](&client.KV)
+
+		api.On("KVGet", "counter").Return([]byte(`1`), nil)
+
+		err := store.Update("counter", func(oldValue int, exists bool) (int, error) {
+			return 0, errors.New("failed")
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestTypedKVList(t *testing.T) {
+	api := &plugintest.API{}
+	defer api.AssertExpectations(t)
+	client := pluginapi.NewClient(api, &plugintest.Driver{})
+	store := pluginapi.NewTypedKV[typedValue](&client.KV)
+
+	a, _ := json.Marshal(typedValue{Name: "a"})
+	b, _ := json.Marshal(typedValue{Name: "b"})
+	api.On("KVList", 0, 1000).Return([]string{"user_a", "other", "user_b", "user_gone"}, nil)
+	api.On("KVGet", "user_a").Return(a, nil)
+	api.On("KVGet", "user_b").Return(b, nil)
+	api.On("KVGet", "user_gone").Return(nil, nil)
+
+	values, err := store.List("user_")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]typedValue{
+		"user_a": {Name: "a"},
+		"user_b": {Name: "b"},
+	}, values)
+}